@@ -1,16 +1,28 @@
 package main
 
 import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
 
 	"github.com/joho/godotenv"
 
 	"wakemap/internal/data"
+	"wakemap/internal/export"
+	"wakemap/internal/redact"
 	"wakemap/internal/server"
+	"wakemap/internal/tiles"
 )
 
 func getenvExpanded(key, def string) string {
@@ -35,19 +47,487 @@ func main() {
 		log.Fatalf("create db dir: %v", err)
 	}
 
+	// migrate and restore run before Open, which would apply pending
+	// migrations itself and refuses a database a newer wakemap has
+	// migrated; restore also needs the file closed.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			if err := runMigrate(dbPath, os.Args[2:]); err != nil {
+				log.Fatalf("migrate: %v", err)
+			}
+			return
+		case "restore":
+			if len(os.Args) != 3 {
+				log.Fatalf("usage: wakemap restore <snapshot>  (stop the server first)")
+			}
+			backups, err := backupPolicy(dbPath)
+			if err != nil {
+				log.Fatalf("restore: %v", err)
+			}
+			if err := runRestore(dbPath, backups.Dir, os.Args[2]); err != nil {
+				log.Fatalf("restore: %v", err)
+			}
+			return
+		}
+	}
+
 	store, err := data.Open(dbPath)
 	if err != nil {
 		log.Fatalf("open db: %v", err)
 	}
 	defer store.Close()
 
-	api := &server.API{Store: store}
+	// Maintenance commands run against the DB and exit.
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "rebuild-stats":
+			n, err := store.RebuildAllSummaries(context.Background())
+			if err != nil {
+				log.Fatalf("rebuild-stats: %v", err)
+			}
+			log.Printf("rebuilt stats for %d tracks", n)
+			return
+		case "gazetteer-import":
+			if len(os.Args) != 3 {
+				log.Fatalf("usage: wakemap gazetteer-import <geonames .txt|.zip | osm .geojson|.geojsonseq>")
+			}
+			source, n, err := importGazetteer(context.Background(), store, os.Args[2])
+			if err != nil {
+				log.Fatalf("gazetteer-import: %v", err)
+			}
+			log.Printf("loaded %d %s places", n, source)
+			return
+		case "backup":
+			backups, err := backupPolicy(dbPath)
+			if err != nil {
+				log.Fatalf("backup: %v", err)
+			}
+			rep, err := store.Backup(context.Background(), backups)
+			if err != nil {
+				log.Fatalf("backup: %v", err)
+			}
+			logBackup(rep)
+			return
+		case "retention":
+			policy, err := retentionPolicy()
+			if err != nil {
+				log.Fatalf("retention: %v", err)
+			}
+			for _, arg := range os.Args[2:] {
+				switch arg {
+				case "-dry-run", "--dry-run":
+					policy.DryRun = true
+				case "-vacuum", "--vacuum":
+					policy.Vacuum = true
+				default:
+					log.Fatalf("usage: wakemap retention [-dry-run] [-vacuum]")
+				}
+			}
+			rep, err := store.Retain(context.Background(), policy)
+			if err != nil {
+				log.Fatalf("retention: %v", err)
+			}
+			logRetention(policy, rep)
+			return
+		default:
+			log.Fatalf("unknown command %q (want: migrate, backup, restore, rebuild-stats, gazetteer-import, retention)", os.Args[1])
+		}
+	}
+
+	// Rendered vector tiles live next to the DB unless told otherwise.
+	tileDir := getenvExpanded("WAKEMAP_TILE_CACHE", filepath.Join(filepath.Dir(dbPath), "tiles"))
+
+	// WAKEMAP_SOURCE_VESSELS routes ingest sources to vessels, e.g.
+	// "import=1,tender-logger=2,*=1"; "*" catches any other source.
+	sourceVessels, err := parseSourceVessels(os.Getenv("WAKEMAP_SOURCE_VESSELS"))
+	if err != nil {
+		log.Fatalf("WAKEMAP_SOURCE_VESSELS: %v", err)
+	}
+
+	// Private zones kept out of exports and public views; edited through
+	// /api/redaction-zones or by hand.
+	zonesPath := getenvExpanded("REDACTION_GEOJSON", filepath.Join(filepath.Dir(dbPath), "redaction.geojson"))
+	zones, err := redact.Load(zonesPath)
+	if err != nil {
+		log.Fatalf("redaction zones: %v", err)
+	}
+
+	// WAKEMAP_RETENTION_EVERY (e.g. "24h") runs the retention job in the
+	// background; unset, old tracks are only thinned by `wakemap retention`.
+	if every := getenvExpanded("WAKEMAP_RETENTION_EVERY", ""); every != "" {
+		d, err := time.ParseDuration(every)
+		if err != nil || d < time.Minute {
+			log.Fatalf("WAKEMAP_RETENTION_EVERY: want a duration of a minute or more, got %q", every)
+		}
+		policy, err := retentionPolicy()
+		if err != nil {
+			log.Fatalf("retention: %v", err)
+		}
+		go runRetention(store, policy, d)
+	}
+
+	// Auto-named tracks follow their routes; resolve changed routes off the
+	// request path.
+	go refreshRoutes(store, time.Minute)
+
+	backups, err := backupPolicy(dbPath)
+	if err != nil {
+		log.Fatalf("backup: %v", err)
+	}
+	// WAKEMAP_BACKUP_EVERY (e.g. "6h") snapshots the database into
+	// WAKEMAP_BACKUP_DIR in the background, keeping WAKEMAP_BACKUP_KEEP.
+	if every := getenvExpanded("WAKEMAP_BACKUP_EVERY", ""); every != "" {
+		d, err := time.ParseDuration(every)
+		if err != nil || d < time.Minute {
+			log.Fatalf("WAKEMAP_BACKUP_EVERY: want a duration of a minute or more, got %q", every)
+		}
+		go runBackups(store, backups, d)
+	}
 
-	mux := server.NewMux(api)
+	// Appended positions go through one writer that batches them into
+	// shared transactions; WAKEMAP_INGEST_QUEUE=0 writes each request alone.
+	ingestCfg, err := ingestConfig()
+	if err != nil {
+		log.Fatalf("ingest: %v", err)
+	}
+	var ingest *data.IngestWriter
+	if ingestCfg.Queue > 0 {
+		if ingest, err = store.NewIngestWriter(ingestCfg); err != nil {
+			log.Fatalf("ingest: %v", err)
+		}
+	}
+
+	api := &server.API{
+		Store:         store,
+		Tiles:         tiles.NewCache(tileDir),
+		SourceVessels: sourceVessels,
+		Zones:         zones,
+		// Without an owner token every client sees raw data, as on a boat
+		// LAN; set one before exposing the server.
+		OwnerToken: strings.TrimSpace(os.Getenv("WAKEMAP_OWNER_TOKEN")),
+		Backups:    backups,
+		Ingest:     ingest,
+	}
+
+	srv := &http.Server{Addr: addr, Handler: server.NewMux(api)}
+
+	// On SIGINT/SIGTERM write out queued positions, which also answers
+	// requests waiting on them (later senders get 503 and retry), then
+	// finish open requests before the DB closes.
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+		if ingest != nil {
+			if err := ingest.Close(); err != nil {
+				log.Printf("ingest: %v", err)
+			}
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("shutdown: %v", err)
+		}
+	}()
 
 	log.Printf("wakemap dev server on http://localhost:%s  db=%s", port, dbPath)
 
-	if err := http.ListenAndServe(addr, mux); err != nil {
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	<-stopped
+}
+
+// runMigrate handles `wakemap migrate status|up`: list each migration and
+// whether it has been applied, or apply the pending ones.
+func runMigrate(dbPath string, args []string) error {
+	if len(args) != 1 || (args[0] != "status" && args[0] != "up") {
+		return fmt.Errorf("usage: wakemap migrate status|up")
+	}
+	if _, err := os.Stat(dbPath); args[0] == "status" && err != nil {
+		return err // status only reads; don't leave an empty database behind
+	}
+	db, err := data.OpenDB(dbPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	if args[0] == "up" {
+		done, err := data.MigrateUp(ctx, db)
+		for _, m := range done {
+			log.Printf("applied %03d_%s", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(done) == 0 {
+			log.Printf("schema is up to date")
+		}
+		return nil
+	}
+
+	states, err := data.Migrations(ctx, db)
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED")
+	pending := 0
+	for _, st := range states {
+		state, applied := "pending", ""
+		switch {
+		case !st.Known:
+			state = "unknown (newer wakemap)"
+		case st.Modified:
+			state = "modified since applied"
+		case st.Applied:
+			state = "applied"
+		default:
+			pending++
+		}
+		if st.Applied {
+			applied = "before tracking"
+			if st.AppliedAt > 0 {
+				applied = data.UnixToTime(st.AppliedAt).Format(time.RFC3339)
+			}
+		}
+		fmt.Fprintf(tw, "%03d\t%s\t%s\t%s\n", st.Version, st.Name, state, applied)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	fmt.Printf("%d pending\n", pending)
+	return nil
+}
+
+// backupPolicy reads where snapshots go, WAKEMAP_BACKUP_DIR (default
+// backups/ beside the database), and how many to keep, WAKEMAP_BACKUP_KEEP
+// (default 7, 0 = all). Put the directory on another card or disk if
+// there is one.
+func backupPolicy(dbPath string) (data.BackupPolicy, error) {
+	p := data.BackupPolicy{
+		Dir:  getenvExpanded("WAKEMAP_BACKUP_DIR", filepath.Join(filepath.Dir(dbPath), "backups")),
+		Keep: 7,
+	}
+	if s := strings.TrimSpace(os.Getenv("WAKEMAP_BACKUP_KEEP")); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("WAKEMAP_BACKUP_KEEP: want a non-negative number, got %q", s)
+		}
+		p.Keep = n
+	}
+	return p, nil
+}
+
+// ingestConfig reads the ingest writer's settings; unset ones take the
+// writer's defaults (10000 queued, 500 per batch, 1s, block for 5s).
+func ingestConfig() (data.IngestConfig, error) {
+	c := data.IngestConfig{Queue: 10000, Policy: data.IngestPolicy(strings.TrimSpace(os.Getenv("WAKEMAP_INGEST_POLICY")))}
+	for _, v := range []struct {
+		key string
+		dst *int
+	}{
+		{"WAKEMAP_INGEST_QUEUE", &c.Queue},
+		{"WAKEMAP_INGEST_BATCH", &c.MaxBatch},
+	} {
+		if s := strings.TrimSpace(os.Getenv(v.key)); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return c, fmt.Errorf("%s: want a non-negative number, got %q", v.key, s)
+			}
+			*v.dst = n
+		}
+	}
+	for _, v := range []struct {
+		key string
+		dst *time.Duration
+	}{
+		{"WAKEMAP_INGEST_FLUSH", &c.FlushEvery},
+		{"WAKEMAP_INGEST_WAIT", &c.MaxWait},
+	} {
+		if s := strings.TrimSpace(os.Getenv(v.key)); s != "" {
+			d, err := time.ParseDuration(s)
+			if err != nil || d <= 0 {
+				return c, fmt.Errorf("%s: want a positive duration, got %q", v.key, s)
+			}
+			*v.dst = d
+		}
+	}
+	return c, nil
+}
+
+// refreshRoutes re-resolves changed track routes now and every interval.
+func refreshRoutes(store *data.Store, every time.Duration) {
+	for {
+		if err := store.RefreshTrackRoutes(context.Background()); err != nil {
+			log.Printf("track routes: %v", err)
+		}
+		time.Sleep(every)
+	}
+}
+
+// runBackups snapshots the database every interval, giving each run until
+// the next is due.
+func runBackups(store *data.Store, policy data.BackupPolicy, every time.Duration) {
+	for {
+		time.Sleep(every)
+		ctx, cancel := context.WithTimeout(context.Background(), every)
+		rep, err := store.Backup(ctx, policy)
+		cancel()
+		if err != nil {
+			log.Printf("backup: %v", err)
+			continue
+		}
+		logBackup(rep)
+	}
+}
+
+func logBackup(rep *data.BackupReport) {
+	log.Printf("backup: wrote %s (%.1f MB, %d pages, integrity ok) in %s, pruned %d older",
+		rep.Path, float64(rep.Bytes)/(1<<20), rep.Pages, rep.Duration.Round(time.Millisecond), len(rep.Pruned))
+}
+
+// runRestore handles `wakemap restore <snapshot>`. A bare snapshot name is
+// looked up in the backup directory.
+func runRestore(dbPath, backupDir, snapshot string) error {
+	if _, err := os.Stat(snapshot); err != nil && filepath.Base(snapshot) == snapshot {
+		snapshot = filepath.Join(backupDir, snapshot)
+	}
+	rep, err := data.Restore(context.Background(), dbPath, snapshot)
+	if err != nil {
+		return err
+	}
+	for _, m := range rep.Applied {
+		log.Printf("applied %03d_%s to the restored database", m.Version, m.Name)
+	}
+	if rep.Replaced != "" {
+		log.Printf("previous database kept as %s", rep.Replaced)
+	}
+	log.Printf("restored %s from %s", dbPath, rep.From)
+	return nil
+}
+
+// retentionPolicy reads the retention job's settings: tracks that ended
+// within WAKEMAP_RETAIN_FULL_DAYS keep every fix, older ones are thinned to
+// a fix per WAKEMAP_DOWNSAMPLE_S seconds and/or WAKEMAP_DOWNSAMPLE_M metres,
+// keeping turns sharper than WAKEMAP_DOWNSAMPLE_TURN_M.
+func retentionPolicy() (data.RetentionPolicy, error) {
+	p := data.RetentionPolicy{FullDays: 90, EveryS: 30, TurnM: 10}
+	for _, v := range []struct {
+		key string
+		dst any
+	}{
+		{"WAKEMAP_RETAIN_FULL_DAYS", &p.FullDays},
+		{"WAKEMAP_DOWNSAMPLE_S", &p.EveryS},
+		{"WAKEMAP_DOWNSAMPLE_M", &p.EveryM},
+		{"WAKEMAP_DOWNSAMPLE_TURN_M", &p.TurnM},
+	} {
+		s := strings.TrimSpace(os.Getenv(v.key))
+		if s == "" {
+			continue
+		}
+		var err error
+		switch dst := v.dst.(type) {
+		case *int:
+			*dst, err = strconv.Atoi(s)
+		case *int64:
+			*dst, err = strconv.ParseInt(s, 10, 64)
+		case *float64:
+			*dst, err = strconv.ParseFloat(s, 64)
+		}
+		if err != nil || strings.HasPrefix(s, "-") {
+			return p, fmt.Errorf("%s: want a non-negative number, got %q", v.key, s)
+		}
+	}
+	return p, nil
+}
+
+func runRetention(store *data.Store, policy data.RetentionPolicy, every time.Duration) {
+	for {
+		time.Sleep(every)
+		rep, err := store.Retain(context.Background(), policy)
+		if err != nil {
+			log.Printf("retention: %v", err)
+			continue
+		}
+		logRetention(policy, rep)
+	}
+}
+
+func logRetention(policy data.RetentionPolicy, rep *data.RetentionReport) {
+	verb := "thinned"
+	if policy.DryRun {
+		verb = "would thin"
+	}
+	log.Printf("retention (%s, full resolution for %d days): %s %d tracks from %d to %d fixes (%d busy, left for next time), rtree repaired %d, reclaimed %.1f MB (vacuumed: %v) in %s",
+		policy, policy.FullDays, verb, rep.Tracks, rep.PositionsBefore, rep.PositionsAfter, rep.Skipped, rep.RTreeRepaired,
+		float64(rep.Reclaimed())/(1<<20), rep.Vacuumed, rep.Duration.Round(time.Millisecond))
+}
+
+func parseSourceVessels(v string) (map[string]int64, error) {
+	out := map[string]int64{}
+	for _, pair := range strings.Split(v, ",") {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+		source, id, ok := strings.Cut(pair, "=")
+		source = strings.TrimSpace(source)
+		n, err := strconv.ParseInt(strings.TrimSpace(id), 10, 64)
+		if !ok || source == "" || err != nil || n <= 0 {
+			return nil, fmt.Errorf("want source=vessel_id, got %q", pair)
+		}
+		out[source] = n
+	}
+	return out, nil
+}
+
+// importGazetteer loads a place file, picking the reader by extension:
+// GeoNames dumps are .txt (or the .zip they're distributed as), OSM
+// extracts are GeoJSON.
+func importGazetteer(ctx context.Context, store *data.Store, path string) (source string, n int, err error) {
+	var r io.Reader
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".zip":
+		zr, err := zip.OpenReader(path)
+		if err != nil {
+			return "", 0, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if strings.HasSuffix(f.Name, ".txt") && !strings.EqualFold(f.Name, "readme.txt") {
+				rc, err := f.Open()
+				if err != nil {
+					return "", 0, err
+				}
+				defer rc.Close()
+				r = rc
+				break
+			}
+		}
+		if r == nil {
+			return "", 0, fmt.Errorf("%s: no GeoNames .txt inside", path)
+		}
+	default:
+		f, err := os.Open(path)
+		if err != nil {
+			return "", 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json", ".geojsonseq", ".geojsonl":
+		source = "osm"
+		n, err = store.LoadGazetteer(ctx, source, export.NewOSMPlacesReader(r).Next)
+	default:
+		source = "geonames"
+		n, err = store.LoadGazetteer(ctx, source, export.NewGeoNamesReader(r).Next)
+	}
+	return source, n, err
 }
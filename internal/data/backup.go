@@ -0,0 +1,346 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrBackupRunning means another snapshot is being taken.
+var ErrBackupRunning = errors.New("a backup is already running")
+
+const (
+	snapshotPrefix = "wakemap-"
+	snapshotSuffix = ".db"
+	// Snapshot names sort by time: wakemap-20261018T170325.123Z.db
+	snapshotTimeLayout = "20060102T150405.000Z"
+)
+
+// BackupPolicy says where snapshots go and how many to keep (0 = all).
+type BackupPolicy struct {
+	Dir  string
+	Keep int
+}
+
+// BackupReport is what one snapshot did.
+type BackupReport struct {
+	Path     string
+	Bytes    int64
+	Pages    int
+	Pruned   []string // older snapshots removed to stay within Keep
+	Duration time.Duration
+}
+
+// Backup writes a consistent snapshot of the live database into p.Dir with
+// SQLite's online backup API, checks it with PRAGMA integrity_check, and
+// only then gives it its final name, so a snapshot on disk is always a
+// whole, verified one. Older snapshots beyond p.Keep are then removed.
+func (s *Store) Backup(ctx context.Context, p BackupPolicy) (*BackupReport, error) {
+	if !s.backupMu.TryLock() {
+		return nil, ErrBackupRunning
+	}
+	defer s.backupMu.Unlock()
+
+	start := time.Now()
+	if err := os.MkdirAll(p.Dir, 0o755); err != nil {
+		return nil, err
+	}
+	name := snapshotPrefix + start.UTC().Format(snapshotTimeLayout) + snapshotSuffix
+	final := filepath.Join(p.Dir, name)
+	partial := final + ".partial"
+	defer os.Remove(partial)
+
+	rep := &BackupReport{Path: final}
+	var err error
+	if rep.Pages, err = s.backupTo(ctx, partial); err != nil {
+		return nil, fmt.Errorf("backup: %w", err)
+	}
+	if err := VerifySnapshot(ctx, partial); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(partial, final); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(final)
+	if err != nil {
+		return nil, err
+	}
+	rep.Bytes = fi.Size()
+	if rep.Pruned, err = pruneSnapshots(p.Dir, p.Keep); err != nil {
+		return rep, err
+	}
+	rep.Duration = time.Since(start)
+	return rep, nil
+}
+
+// backupTo copies the live database into a new file at path and returns
+// its page count. The copy is one step, so one read transaction: in WAL
+// mode writers carry on meanwhile, and their commits can't restart it the
+// way they restart a copy taken in several steps.
+func (s *Store) backupTo(ctx context.Context, path string) (int, error) {
+	_ = os.Remove(path)
+	dst, err := sql.Open("sqlite3", "file:"+path)
+	if err != nil {
+		return 0, err
+	}
+	defer dst.Close()
+	dstConn, err := dst.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer dstConn.Close()
+	srcConn, err := s.DB.Conn(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer srcConn.Close()
+
+	pages := 0
+	err = dstConn.Raw(func(d any) error {
+		return srcConn.Raw(func(src any) error {
+			b, err := d.(*sqlite3.SQLiteConn).Backup("main", src.(*sqlite3.SQLiteConn), "main")
+			if err != nil {
+				return err
+			}
+			if _, err := b.Step(-1); err != nil {
+				_ = b.Finish()
+				return err
+			}
+			pages = b.PageCount()
+			return b.Finish()
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	// The copy inherits WAL mode from the live file; a snapshot is better
+	// as one self-contained file.
+	if _, err := dstConn.ExecContext(ctx, `PRAGMA journal_mode = DELETE`); err != nil {
+		return 0, err
+	}
+	return pages, nil
+}
+
+// VerifySnapshot runs PRAGMA integrity_check on the database file at path.
+func VerifySnapshot(ctx context.Context, path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	d, err := sql.Open("sqlite3", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	rows, err := d.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	defer rows.Close()
+	var problems []string
+	for rows.Next() {
+		var msg string
+		if err := rows.Scan(&msg); err != nil {
+			return err
+		}
+		if msg != "ok" {
+			problems = append(problems, msg)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s fails integrity check: %s", filepath.Base(path), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Snapshots lists the snapshots in dir, oldest first.
+func Snapshots(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, e := range entries {
+		n := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(n, snapshotPrefix) && strings.HasSuffix(n, snapshotSuffix) {
+			out = append(out, filepath.Join(dir, n))
+		}
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+// pruneSnapshots removes all but the newest keep snapshots in dir.
+func pruneSnapshots(dir string, keep int) ([]string, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	all, err := Snapshots(dir)
+	if err != nil || len(all) <= keep {
+		return nil, err
+	}
+	old := all[:len(all)-keep]
+	for _, p := range old {
+		if err := os.Remove(p); err != nil {
+			return nil, err
+		}
+	}
+	return old, nil
+}
+
+// RestoreReport is what a restore did.
+type RestoreReport struct {
+	From     string      // the snapshot restored
+	Replaced string      // where the previous database was moved; "" if there was none
+	Applied  []Migration // migrations the snapshot needed to match this binary
+}
+
+// ErrDatabaseInUse means Restore found another process using the database.
+var ErrDatabaseInUse = errors.New("database is in use; stop the server first")
+
+// Restore replaces the database at dbPath with snapshot. The snapshot is
+// copied next to dbPath, checked for integrity and for a schema this
+// binary understands, and migrated up to it; only then is the current
+// database moved aside (to dbPath.pre-restore-<time>) and the copy put in
+// its place, under an exclusive lock on the current database. Nothing else
+// may have dbPath open: stop the server first.
+func Restore(ctx context.Context, dbPath, snapshot string) (*RestoreReport, error) {
+	if err := VerifySnapshot(ctx, snapshot); err != nil {
+		return nil, err
+	}
+	rep := &RestoreReport{From: snapshot}
+	staged := dbPath + ".restoring"
+	defer removeDB(staged)
+	removeDB(staged)
+	if err := copyFile(snapshot, staged); err != nil {
+		return nil, err
+	}
+
+	d, err := OpenDB(staged)
+	if err != nil {
+		return nil, err
+	}
+	rep.Applied, err = MigrateUp(ctx, d)
+	if err == nil {
+		// Fold the WAL back in so the file stands alone once closed.
+		_, err = d.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	}
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(snapshot), err)
+	}
+	if err := VerifySnapshot(ctx, staged); err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(dbPath); errors.Is(err, os.ErrNotExist) {
+		return rep, moveDB(staged, dbPath)
+	} else if err != nil {
+		return nil, err
+	}
+	live, err := OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	defer live.Close()
+	conn, err := lockForRestore(ctx, live)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	defer func() { _, _ = conn.ExecContext(context.Background(), `ROLLBACK`) }()
+	rep.Replaced = dbPath + ".pre-restore-" + time.Now().UTC().Format(snapshotTimeLayout)
+	if err := moveDB(dbPath, rep.Replaced); err != nil {
+		return nil, err
+	}
+	if err := moveDB(staged, dbPath); err != nil {
+		return nil, err
+	}
+	return rep, nil
+}
+
+// lockForRestore folds the live database's WAL into the file and takes
+// its write lock, holding it on the returned connection while the files
+// are swapped. A checkpoint held up by readers, or a lock still taken
+// after the busy timeout, means something has the database open.
+func lockForRestore(ctx context.Context, live *sql.DB) (*sql.Conn, error) {
+	conn, err := live.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var busy, logPages, done int
+	if err := conn.QueryRowContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`).Scan(&busy, &logPages, &done); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("checkpoint current database: %w", err)
+	}
+	if busy != 0 {
+		conn.Close()
+		return nil, ErrDatabaseInUse
+	}
+	if _, err := conn.ExecContext(ctx, `PRAGMA locking_mode = EXCLUSIVE`); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if _, err := conn.ExecContext(ctx, `BEGIN EXCLUSIVE`); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("%w: %v", ErrDatabaseInUse, err)
+	}
+	return conn, nil
+}
+
+// moveDB renames a database file along with any WAL and shared-memory
+// files beside it.
+func moveDB(from, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	for _, sfx := range []string{"-wal", "-shm"} {
+		if err := os.Rename(from+sfx, to+sfx); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func removeDB(path string) {
+	for _, sfx := range []string{"", "-wal", "-shm", "-journal"} {
+		_ = os.Remove(path + sfx)
+	}
+}
+
+func copyFile(from, to string) error {
+	src, err := os.Open(from)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	if err := dst.Sync(); err != nil {
+		_ = dst.Close()
+		return err
+	}
+	return dst.Close()
+}
@@ -0,0 +1,512 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+const (
+	// A stay at least this long ends one passage and starts the next.
+	passageBreakS = 2 * 3600
+	// Shorter passages are moving about an anchorage or marina.
+	minPassageM = 500.0
+	// The best run is the most distance made good in this long.
+	bestRunS = 24 * 3600
+)
+
+// Cruise statistic groupings.
+const (
+	GroupMonth = "month"
+	GroupYear  = "year"
+)
+
+// CruiseQuery selects the archive to summarise: a time range (0 = open),
+// a vessel (0 = all) and how to group it (GroupMonth, GroupYear or "").
+// Top is how many destinations to list. Distance and time underway are
+// counted by whole UTC day, so a range cuts at the days it falls in.
+type CruiseQuery struct {
+	From, To int64
+	VesselID int64
+	Group    string
+	Top      int
+}
+
+// CruiseTotals are the headline figures for a range.
+type CruiseTotals struct {
+	DistanceM      float64
+	UnderwayS      int64
+	NightsAnchored int
+	NightsMoored   int
+	Passages       int
+}
+
+// CruisePeriod is one month or year of a grouped summary.
+type CruisePeriod struct {
+	Key      string // "2025-09" or "2025"
+	From, To int64
+	CruiseTotals
+}
+
+// Passage is a departure to an arrival, with the gazetteer's names for
+// both ends when it knows them.
+type Passage struct {
+	db.Passage
+	FromName, ToName string
+}
+
+// BestRun is the most distance covered in 24 hours of one track.
+type BestRun struct {
+	TrackID   int64
+	StartedAt int64
+	DistanceM float64
+}
+
+// Destination is somewhere passages ended, aggregated like Place.
+type Destination struct {
+	Lon, Lat  float64
+	Name      string
+	Arrivals  int
+	Nights    int
+	LastAt    int64
+	weightSum float64
+}
+
+// CruiseStats is a season or lifetime summary.
+type CruiseStats struct {
+	Totals         CruiseTotals
+	Periods        []CruisePeriod
+	LongestPassage *Passage
+	BestRun        *BestRun
+	Destinations   []*Destination
+}
+
+// refreshCruiseStats re-derives track_days, passages and the best run for
+// live tracks whose summary has moved on since they were computed.
+func (s *Store) refreshCruiseStats(ctx context.Context) error {
+	work, err := s.staleDerived(ctx, "cruise_version")
+	if err != nil {
+		return err
+	}
+	for _, w := range work {
+		ts, err := s.ComputeTrackStats(ctx, w.id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+		if err := s.saveCruiseStats(ctx, w.id, w.version, ts); err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+	}
+	return nil
+}
+
+// cruiseDay is a track's distance and time underway on one UTC day.
+type cruiseDay struct {
+	distanceM  float64
+	underwayMs int64
+}
+
+func (s *Store) saveCruiseStats(ctx context.Context, trackID, version int64, ts *TrackStats) error {
+	days, passages, best := deriveCruise(ts)
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM track_days WHERE track_id = ?`, trackID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM passages WHERE track_id = ?`, trackID); err != nil {
+		return err
+	}
+	for day, d := range days {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO track_days (track_id, day, distance_m, underway_s) VALUES (?, ?, ?, ?)
+		`, trackID, day, d.distanceM, msToS(d.underwayMs)); err != nil {
+			return err
+		}
+	}
+	for _, p := range passages {
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO passages (track_id, started_at, ended_at, distance_m, underway_s, from_lon, from_lat, to_lon, to_lat)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, trackID, p.StartedAt, p.EndedAt, p.DistanceM, p.UnderwayS, p.FromLon, p.FromLat, p.ToLon, p.ToLat); err != nil {
+			return err
+		}
+	}
+	var bestM sql.NullFloat64
+	var bestAt sql.NullInt64
+	if best.DistanceM > 0 {
+		bestM = sql.NullFloat64{Float64: best.DistanceM, Valid: true}
+		bestAt = sql.NullInt64{Int64: best.StartedAt, Valid: true}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE track_summaries SET best_run_m = ?, best_run_at = ? WHERE track_id = ?`,
+		bestM, bestAt, trackID); err != nil {
+		return err
+	}
+	if err := markDerivedTx(ctx, tx, "cruise_version", trackID, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// deriveCruise splits a track into per-day figures and passages and finds
+// its best 24-hour run. Time anchored or moored is not underway, and the
+// swinging about while there is not distance sailed.
+func deriveCruise(ts *TrackStats) (map[int64]*cruiseDay, []db.Passage, BestRun) {
+	n := len(ts.Coords)
+	days := map[int64]*cruiseDay{}
+	if n < 2 {
+		return days, nil, BestRun{}
+	}
+
+	stopped := make([]bool, n) // interval k-1 -> k is at a stay
+	var breaks [][2]int
+	for _, sg := range ts.Segments {
+		if sg.Kind != SegAnchored && sg.Kind != SegMoored {
+			continue
+		}
+		for k := sg.start + 1; k <= sg.end; k++ {
+			stopped[k] = true
+		}
+		if sg.EndedAt-sg.StartedAt >= passageBreakS {
+			breaks = append(breaks, [2]int{sg.start, sg.end})
+		}
+	}
+
+	// Cumulative distance and time underway (ms) up to each fix.
+	cumM := make([]float64, n)
+	cumMs := make([]int64, n)
+	for k := 1; k < n; k++ {
+		cumM[k], cumMs[k] = cumM[k-1], cumMs[k-1]
+		if stopped[k] {
+			continue
+		}
+		d := haversineCoords(ts.Coords[k-1], ts.Coords[k])
+		dt := max(ts.TimesMs[k]-ts.TimesMs[k-1], 0)
+		cumM[k] += d
+		cumMs[k] += dt
+		day := floorDiv(ts.Times[k-1], 86400)
+		cd := days[day]
+		if cd == nil {
+			cd = &cruiseDay{}
+			days[day] = cd
+		}
+		cd.distanceM += d
+		cd.underwayMs += dt
+	}
+
+	var passages []db.Passage
+	from := 0
+	addPassage := func(i, j int) {
+		if j <= i || cumM[j]-cumM[i] < minPassageM {
+			return
+		}
+		passages = append(passages, db.Passage{
+			StartedAt: ts.Times[i],
+			EndedAt:   ts.Times[j],
+			DistanceM: cumM[j] - cumM[i],
+			UnderwayS: msToS(cumMs[j] - cumMs[i]),
+			FromLon:   ts.Coords[i][0],
+			FromLat:   ts.Coords[i][1],
+			ToLon:     ts.Coords[j][0],
+			ToLat:     ts.Coords[j][1],
+		})
+	}
+	for _, b := range breaks {
+		addPassage(from, b[0])
+		from = b[1]
+	}
+	addPassage(from, n-1)
+
+	var best BestRun
+	j := 0
+	for i := 0; i < n; i++ {
+		for j+1 < n && ts.Times[j+1]-ts.Times[i] <= bestRunS {
+			j++
+		}
+		if d := cumM[j] - cumM[i]; d > best.DistanceM {
+			best = BestRun{StartedAt: ts.Times[i], DistanceM: d}
+		}
+	}
+	return days, passages, best
+}
+
+// CruiseStats summarises the archive: distance, time underway, nights at
+// anchor or moored and passages, in total and per period, plus the longest
+// passage, the best 24-hour run and the most visited destinations. It reads
+// only the per-track figures kept in track_days and passages, so it stays
+// quick however many tracks there are.
+func (s *Store) CruiseStats(ctx context.Context, q CruiseQuery) (*CruiseStats, error) {
+	if err := s.refreshCruiseStats(ctx); err != nil {
+		return nil, err
+	}
+	inRange := func(t int64) bool {
+		return (q.From <= 0 || t >= q.From) && (q.To <= 0 || t <= q.To)
+	}
+	out := &CruiseStats{}
+	periods := map[string]*CruisePeriod{}
+	var lo, hi int64
+	bucket := func(t int64) *CruiseTotals {
+		if lo == 0 || t < lo {
+			lo = t
+		}
+		hi = max(hi, t)
+		if q.Group == "" {
+			return &out.Totals
+		}
+		key, from, to := cruisePeriod(t, q.Group)
+		p := periods[key]
+		if p == nil {
+			p = &CruisePeriod{Key: key, From: from, To: to}
+			periods[key] = p
+		}
+		return &p.CruiseTotals
+	}
+
+	vf, vargs := vesselFilter("t.vessel_id", q.VesselID)
+	where := "t.deleted_at IS NULL" + vf
+	args := vargs
+	if q.From > 0 {
+		where += " AND d.day >= ?"
+		args = append(args, floorDiv(q.From, 86400))
+	}
+	if q.To > 0 {
+		where += " AND d.day <= ?"
+		args = append(args, floorDiv(q.To, 86400))
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT d.day, SUM(d.distance_m), SUM(d.underway_s)
+		FROM track_days d JOIN tracks t ON t.id = d.track_id
+		WHERE `+where+`
+		GROUP BY d.day
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var day, underwayS int64
+		var distM float64
+		if err := rows.Scan(&day, &distM, &underwayS); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		b := bucket(day * 86400)
+		b.DistanceM += distM
+		b.UnderwayS += underwayS
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	passages, err := s.passages(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	for i := range passages {
+		p := &passages[i]
+		bucket(p.StartedAt).Passages++
+		if out.LongestPassage == nil || p.DistanceM > out.LongestPassage.DistanceM {
+			out.LongestPassage = &Passage{Passage: *p}
+		}
+	}
+
+	stays, err := s.stays(ctx, q.VesselID)
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range stays {
+		// Each local midnight spent there, as NightsAt counts them.
+		off := int64(st.Lon / 15 * 3600)
+		for k := floorDiv(st.StartedAt+off, 86400) + 1; k <= floorDiv(st.EndedAt+off, 86400); k++ {
+			midnight := k*86400 - off
+			if !inRange(midnight) {
+				continue
+			}
+			if st.Kind == SegMoored {
+				bucket(midnight).NightsMoored++
+			} else {
+				bucket(midnight).NightsAnchored++
+			}
+		}
+	}
+
+	if q.Group != "" {
+		if q.From > 0 {
+			lo = q.From
+		}
+		if q.To > 0 {
+			hi = q.To
+		}
+		// Every period in the range, so an idle month shows as zero.
+		for t := lo; lo > 0 && t <= hi; {
+			key, from, to := cruisePeriod(t, q.Group)
+			p := periods[key]
+			if p == nil {
+				p = &CruisePeriod{Key: key, From: from, To: to}
+			}
+			out.Periods = append(out.Periods, *p)
+			out.Totals.add(p.CruiseTotals)
+			t = to + 1
+		}
+	}
+
+	if err := s.bestRun(ctx, q, out); err != nil {
+		return nil, err
+	}
+	if lp := out.LongestPassage; lp != nil {
+		if lp.FromName, err = s.placeName(ctx, lp.FromLon, lp.FromLat); err != nil {
+			return nil, err
+		}
+		if lp.ToName, err = s.placeName(ctx, lp.ToLon, lp.ToLat); err != nil {
+			return nil, err
+		}
+	}
+	var rangeStays []db.TrackStop
+	for _, st := range stays {
+		if inRange(st.StartedAt) {
+			rangeStays = append(rangeStays, st)
+		}
+	}
+	if out.Destinations, err = s.destinations(ctx, passages, rangeStays, q.Top); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (t *CruiseTotals) add(o CruiseTotals) {
+	t.DistanceM += o.DistanceM
+	t.UnderwayS += o.UnderwayS
+	t.NightsAnchored += o.NightsAnchored
+	t.NightsMoored += o.NightsMoored
+	t.Passages += o.Passages
+}
+
+// cruisePeriod is the UTC month or year containing t: its key and first
+// and last second.
+func cruisePeriod(t int64, group string) (string, int64, int64) {
+	u := time.Unix(t, 0).UTC()
+	if group == GroupYear {
+		from := time.Date(u.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+		return u.Format("2006"), from.Unix(), from.AddDate(1, 0, 0).Unix() - 1
+	}
+	from := time.Date(u.Year(), u.Month(), 1, 0, 0, 0, 0, time.UTC)
+	return u.Format("2006-01"), from.Unix(), from.AddDate(0, 1, 0).Unix() - 1
+}
+
+// passages lists passages that started in the query's range.
+func (s *Store) passages(ctx context.Context, q CruiseQuery) ([]db.Passage, error) {
+	vf, vargs := vesselFilter("t.vessel_id", q.VesselID)
+	where := []string{"t.deleted_at IS NULL"}
+	args := vargs
+	if q.From > 0 {
+		where = append(where, "p.started_at >= ?")
+		args = append(args, q.From)
+	}
+	if q.To > 0 {
+		where = append(where, "p.started_at <= ?")
+		args = append(args, q.To)
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.id, p.track_id, p.started_at, p.ended_at, p.distance_m, p.underway_s, p.from_lon, p.from_lat, p.to_lon, p.to_lat
+		FROM passages p JOIN tracks t ON t.id = p.track_id
+		WHERE `+strings.Join(where, " AND ")+vf+`
+		ORDER BY p.started_at
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []db.Passage
+	for rows.Next() {
+		var p db.Passage
+		if err := rows.Scan(&p.ID, &p.TrackID, &p.StartedAt, &p.EndedAt, &p.DistanceM, &p.UnderwayS, &p.FromLon, &p.FromLat, &p.ToLon, &p.ToLat); err != nil {
+			return nil, err
+		}
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+// bestRun fills in the best 24-hour run that started in the query's range.
+func (s *Store) bestRun(ctx context.Context, q CruiseQuery, out *CruiseStats) error {
+	vf, vargs := vesselFilter("t.vessel_id", q.VesselID)
+	var b BestRun
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT s.track_id, s.best_run_at, s.best_run_m
+		FROM track_summaries s JOIN tracks t ON t.id = s.track_id
+		WHERE t.deleted_at IS NULL AND s.best_run_m IS NOT NULL
+		  AND (?1 <= 0 OR s.best_run_at >= ?1) AND (?2 <= 0 OR s.best_run_at <= ?2)`+strings.Replace(vf, "?", "?3", 1)+`
+		ORDER BY s.best_run_m DESC
+		LIMIT 1
+	`, append([]any{q.From, q.To}, vargs...)...).Scan(&b.TrackID, &b.StartedAt, &b.DistanceM)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	out.BestRun = &b
+	return nil
+}
+
+// destinations clusters where passages ended, ranked by arrivals and then
+// nights spent there during stays, and names the top ones.
+func (s *Store) destinations(ctx context.Context, passages []db.Passage, stays []db.TrackStop, top int) ([]*Destination, error) {
+	var dests []*Destination
+	for _, p := range passages {
+		to := [2]float64{p.ToLon, p.ToLat}
+		var best *Destination
+		bestD := placeRadiusM
+		for _, d := range dests {
+			if dd := haversineCoords([2]float64{d.Lon, d.Lat}, to); dd <= bestD {
+				best, bestD = d, dd
+			}
+		}
+		if best == nil {
+			best = &Destination{}
+			dests = append(dests, best)
+		}
+		best.Lon = (best.Lon*best.weightSum + to[0]) / (best.weightSum + 1)
+		best.Lat = (best.Lat*best.weightSum + to[1]) / (best.weightSum + 1)
+		best.weightSum++
+		best.Arrivals++
+		best.LastAt = max(best.LastAt, p.EndedAt)
+	}
+	for _, st := range stays {
+		for _, d := range dests {
+			if haversineCoords([2]float64{d.Lon, d.Lat}, [2]float64{st.Lon, st.Lat}) <= placeRadiusM {
+				d.Nights += NightsAt(st.StartedAt, st.EndedAt, st.Lon)
+				break
+			}
+		}
+	}
+
+	sort.SliceStable(dests, func(i, j int) bool {
+		if dests[i].Arrivals != dests[j].Arrivals {
+			return dests[i].Arrivals > dests[j].Arrivals
+		}
+		return dests[i].Nights > dests[j].Nights
+	})
+	if top > 0 && len(dests) > top {
+		dests = dests[:top]
+	}
+	for _, d := range dests {
+		var err error
+		if d.Name, err = s.placeName(ctx, d.Lon, d.Lat); err != nil {
+			return nil, err
+		}
+	}
+	return dests, nil
+}
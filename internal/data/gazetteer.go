@@ -0,0 +1,332 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+
+	"wakemap/internal/db"
+	"wakemap/internal/geo"
+)
+
+const (
+	// routePlaceRadiusM is how far a track's first or last fix may be from
+	// a place and still be named after it. Passages logged from well
+	// offshore get no name at that end.
+	routePlaceRadiusM = 15000
+	// DefaultGeocodeRadiusM and MaxGeocodeRadiusM bound reverse lookups.
+	DefaultGeocodeRadiusM = 15000
+	MaxGeocodeRadiusM     = 100000
+)
+
+// placeKindWeight favours places a sailor would name a passage after: a
+// town or harbour a few km away beats a hamlet next door. A candidate's
+// score is its distance divided by weight; lowest wins.
+var placeKindWeight = map[string]float64{
+	"city":      4,
+	"town":      3,
+	"harbour":   3,
+	"marina":    3,
+	"port":      3,
+	"bay":       2,
+	"anchorage": 2,
+	"island":    2,
+	"village":   1.5,
+	"suburb":    1.5,
+	"cape":      1.5,
+}
+
+// GeoPlace is a gazetteer entry and how far it is from the query point.
+type GeoPlace struct {
+	db.Gazetteer
+	DistanceM float64
+	score     float64
+}
+
+// ReverseGeocode returns gazetteer places within radiusM of lon/lat, most
+// fitting name first: distance weighted by kind and population.
+func (s *Store) ReverseGeocode(ctx context.Context, lon, lat, radiusM float64, limit int) ([]GeoPlace, error) {
+	area := NearArea(lon, lat, radiusM)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT g.id, g.source, g.source_id, g.name, g.kind, g.country, g.admin1, g.population, g.lon, g.lat
+		FROM gazetteer_rtree r JOIN gazetteer g ON g.id = r.id
+		WHERE r.minX >= ? AND r.maxX <= ? AND r.minY >= ? AND r.maxY <= ?
+	`, area.BBox[0], area.BBox[2], area.BBox[1], area.BBox[3])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []GeoPlace
+	for rows.Next() {
+		var p GeoPlace
+		g := &p.Gazetteer
+		if err := rows.Scan(&g.ID, &g.Source, &g.SourceID, &g.Name, &g.Kind, &g.Country, &g.Admin1, &g.Population, &g.Lon, &g.Lat); err != nil {
+			return nil, err
+		}
+		p.DistanceM = geo.HaversineM(lon, lat, g.Lon, g.Lat)
+		if p.DistanceM > radiusM {
+			continue
+		}
+		w := placeKindWeight[g.Kind]
+		if w == 0 {
+			w = 1
+		}
+		w *= 1 + math.Log10(1+float64(g.Population))/6
+		// 200 m floor so a place right on top doesn't beat everything by
+		// dividing into zero.
+		p.score = math.Max(p.DistanceM, 200) / w
+		out = append(out, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].score != out[j].score {
+			return out[i].score < out[j].score
+		}
+		return out[i].ID < out[j].ID
+	})
+	if limit > 0 && len(out) > limit {
+		out = out[:limit]
+	}
+	return out, nil
+}
+
+// placeName is the best name for a point, or "" if nothing is close.
+func (s *Store) placeName(ctx context.Context, lon, lat float64) (string, error) {
+	ps, err := s.ReverseGeocode(ctx, lon, lat, routePlaceRadiusM, 1)
+	if err != nil || len(ps) == 0 {
+		return "", err
+	}
+	return ps[0].Name, nil
+}
+
+// LoadGazetteer replaces every place from source with the places next
+// yields until io.EOF, in one transaction. Logbook entries are re-derived
+// against the new places on next read, track routes by RefreshTrackRoutes.
+func (s *Store) LoadGazetteer(ctx context.Context, source string, next func() (db.Gazetteer, error)) (n int, err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM gazetteer WHERE source = ?`, source); err != nil {
+		return 0, err
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO gazetteer (source, source_id, name, kind, country, admin1, population, lon, lat)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (source, source_id) DO UPDATE SET
+		  name = excluded.name, kind = excluded.kind, country = excluded.country, admin1 = excluded.admin1,
+		  population = excluded.population, lon = excluded.lon, lat = excluded.lat
+	`)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	for {
+		g, nerr := next()
+		if errors.Is(nerr, io.EOF) {
+			break
+		}
+		if nerr != nil {
+			return n, nerr
+		}
+		if _, err = stmt.ExecContext(ctx, source, g.SourceID, g.Name, g.Kind, g.Country, g.Admin1, g.Population, g.Lon, g.Lat); err != nil {
+			return n, err
+		}
+		n++
+	}
+
+	if _, err = tx.ExecContext(ctx, `UPDATE track_summaries SET place_version = NULL, log_version = NULL`); err != nil {
+		return n, err
+	}
+	return n, tx.Commit()
+}
+
+// TrackRoute is where a track started and finished, as place names; either
+// may be empty when no place is near enough. The coordinates are the ends
+// the names were resolved for.
+type TrackRoute struct {
+	From, To         string
+	FromLon, FromLat float64
+	ToLon, ToLat     float64
+}
+
+func routeFromSummary(from, to sql.NullString, fromLon, fromLat, toLon, toLat sql.NullFloat64) TrackRoute {
+	return TrackRoute{
+		From: from.String, To: to.String,
+		FromLon: fromLon.Float64, FromLat: fromLat.Float64,
+		ToLon: toLon.Float64, ToLat: toLat.Float64,
+	}
+}
+
+// Name is the route as a track name: "Port Stephens → Newcastle".
+func (r TrackRoute) Name() string {
+	switch {
+	case r.From != "" && r.From == r.To:
+		return "Round trip from " + r.From
+	case r.From != "" && r.To != "":
+		return r.From + " → " + r.To
+	case r.From != "":
+		return "From " + r.From
+	case r.To != "":
+		return "To " + r.To
+	}
+	return ""
+}
+
+// TrackRoute resolves a live track's route, re-resolving it first if the
+// track has changed since. Other tracks are left to RefreshTrackRoutes.
+func (s *Store) TrackRoute(ctx context.Context, id int64) (TrackRoute, error) {
+	var r TrackRoute
+	sum, err := s.TrackSummary(ctx, id)
+	if err != nil {
+		return r, err
+	}
+	if !sum.PlaceVersion.Valid || sum.PlaceVersion.Int64 != sum.Version {
+		if err := s.saveTrackRoute(ctx, id, sum.Version); err != nil {
+			return r, err
+		}
+		if sum, err = s.TrackSummary(ctx, id); err != nil {
+			return r, err
+		}
+	}
+	return routeFromSummary(sum.FromPlace, sum.ToPlace, sum.FromLon, sum.FromLat, sum.ToLon, sum.ToLat), nil
+}
+
+// NamedRoute is a track whose name is still the one generated from its
+// route.
+type NamedRoute struct {
+	Route     TrackRoute
+	StartedAt int64
+}
+
+// NamedRoutes returns, by id, those of ids whose name was generated from
+// their route and hasn't been changed since.
+func (s *Store) NamedRoutes(ctx context.Context, ids []int64) (map[int64]NamedRoute, error) {
+	out := map[int64]NamedRoute{}
+	for len(ids) > 0 {
+		chunk := ids[:min(len(ids), 500)]
+		ids = ids[len(chunk):]
+		args := make([]any, len(chunk))
+		for i, id := range chunk {
+			args[i] = id
+		}
+		rows, err := s.DB.QueryContext(ctx, `
+			SELECT t.id, t.name, t.started_at, ts.from_place, ts.to_place, ts.from_lon, ts.from_lat, ts.to_lon, ts.to_lat
+			FROM tracks t JOIN track_summaries ts ON ts.track_id = t.id
+			WHERE t.name_auto = 1 AND t.id IN (?`+strings.Repeat(",?", len(chunk)-1)+`)`, args...)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var id, started int64
+			var name string
+			var from, to sql.NullString
+			var fromLon, fromLat, toLon, toLat sql.NullFloat64
+			if err := rows.Scan(&id, &name, &started, &from, &to, &fromLon, &fromLat, &toLon, &toLat); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			r := routeFromSummary(from, to, fromLon, fromLat, toLon, toLat)
+			if n := r.Name(); n != "" && n == name {
+				out[id] = NamedRoute{Route: r, StartedAt: started}
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// RefreshTrackRoutes re-resolves routes for live tracks whose summary has
+// moved on, renaming auto-named tracks to match. Each route geocodes both
+// ends, so the server runs this in the background rather than on reads.
+func (s *Store) RefreshTrackRoutes(ctx context.Context) error {
+	work, err := s.staleDerived(ctx, "place_version")
+	if err != nil {
+		return err
+	}
+	for _, w := range work {
+		if err := s.saveTrackRoute(ctx, w.id, w.version); err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) saveTrackRoute(ctx context.Context, trackID, version int64) error {
+	var r TrackRoute
+	var ends [2][2]float64
+	found := 0
+	for i, end := range []struct {
+		dst   *string
+		order string
+	}{{&r.From, "ASC"}, {&r.To, "DESC"}} {
+		err := s.DB.QueryRowContext(ctx, `SELECT lon, lat FROM positions WHERE track_id = ? ORDER BY t_ms `+end.order+` LIMIT 1`,
+			trackID).Scan(&ends[i][0], &ends[i][1])
+		if errors.Is(err, sql.ErrNoRows) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if i == 1 && ends[1] == ends[0] {
+			break // a single fix went nowhere; no round trip
+		}
+		found++
+		if *end.dst, err = s.placeName(ctx, ends[i][0], ends[i][1]); err != nil {
+			return err
+		}
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	end := func(i int) (sql.NullFloat64, sql.NullFloat64) {
+		if i >= found {
+			return sql.NullFloat64{}, sql.NullFloat64{}
+		}
+		return sql.NullFloat64{Float64: ends[i][0], Valid: true}, sql.NullFloat64{Float64: ends[i][1], Valid: true}
+	}
+	fromLon, fromLat := end(0)
+	toLon, toLat := end(1)
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE track_summaries SET from_place = ?, to_place = ?, from_lon = ?, from_lat = ?, to_lon = ?, to_lat = ?
+		WHERE track_id = ?`,
+		sql.NullString{String: r.From, Valid: r.From != ""}, sql.NullString{String: r.To, Valid: r.To != ""},
+		fromLon, fromLat, toLon, toLat, trackID); err != nil {
+		return err
+	}
+	if name := r.Name(); name != "" {
+		if _, err := tx.ExecContext(ctx, `UPDATE tracks SET name = ? WHERE id = ? AND name_auto = 1`, name, trackID); err != nil {
+			return err
+		}
+	}
+	if err := markDerivedTx(ctx, tx, "place_version", trackID, version); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.InvalidateTrack(trackID) // the cached stats carry the name
+	return nil
+}
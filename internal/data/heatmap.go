@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+
+	"wakemap/internal/geo"
+)
+
+// HeatLevels are the grid levels heat_cells is stored at; coarser views
+// sum the next finer stored level. Level 18 cells are ~150 m at the
+// equator.
+var HeatLevels = []int{10, 14, 18}
+
+// A fix dwells until the next one, but no longer than this, so a logger
+// switched off overnight doesn't paint a week at the last position.
+const maxDwellS = 900
+
+// HeatQuery selects the cells of one grid level within [X0,X1]×[Y0,Y1].
+type HeatQuery struct {
+	Level          int   // requested level; summed from a finer stored one
+	X0, Y0, X1, Y1 int64 // inclusive cell range at Level
+	From, To       int64 // epoch seconds, day resolution; 0 = open
+	VesselID       int64 // 0 = any vessel
+
+	// Exclude, if set, drops cells whose centre it reports true for. It is
+	// tested on the finest stored grid, before cells are summed into
+	// coarser ones, so a hidden spot doesn't show up as a coarse blob.
+	Exclude func(lon, lat float64) bool
+}
+
+// HeatCell is the dwell time in one grid cell.
+type HeatCell struct {
+	X, Y   int64
+	DwellS int64
+}
+
+// refreshHeatCells re-derives heat_cells for live tracks whose summary has
+// moved on since the grid was last built.
+func (s *Store) refreshHeatCells(ctx context.Context) error {
+	work, err := s.staleDerived(ctx, "heat_version")
+	if err != nil {
+		return err
+	}
+	for _, w := range work {
+		if err := s.buildHeatCells(ctx, w.id, w.version); err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+	}
+	return nil
+}
+
+type heatKey struct {
+	level  int
+	cx, cy int64
+	day    int64
+}
+
+func (s *Store) buildHeatCells(ctx context.Context, trackID, version int64) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	rows, err := tx.QueryContext(ctx, `SELECT t, lon, lat FROM positions WHERE track_id = ? ORDER BY t_ms ASC`, trackID)
+	if err != nil {
+		return err
+	}
+	cells := map[heatKey]int64{}
+	var havePrev bool
+	var pt int64
+	var px, py float64
+	add := func(next int64) {
+		dwell := min(next-pt, maxDwellS)
+		if dwell <= 0 {
+			return
+		}
+		day := floorDiv(pt, 86400)
+		for _, l := range HeatLevels {
+			n := math.Ldexp(1, l)
+			cells[heatKey{l, int64(px * n), int64(py * n), day}] += dwell
+		}
+	}
+	for rows.Next() {
+		var t int64
+		var lon, lat float64
+		if err := rows.Scan(&t, &lon, &lat); err != nil {
+			rows.Close()
+			return err
+		}
+		if havePrev {
+			add(t)
+		}
+		pt, havePrev = t, true
+		px, py = geo.MercatorXY(lon, lat)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM heat_cells WHERE track_id = ?`, trackID); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `INSERT INTO heat_cells (track_id, level, cx, cy, day, dwell_s) VALUES (?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for k, dwell := range cells {
+		if _, err := stmt.ExecContext(ctx, trackID, k.level, k.cx, k.cy, k.day, dwell); err != nil {
+			return err
+		}
+	}
+	if err := markDerivedTx(ctx, tx, "heat_version", trackID, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// HeatCells sums dwell time per cell for q, bringing the grid up to date
+// with any changed tracks first.
+func (s *Store) HeatCells(ctx context.Context, q HeatQuery) ([]HeatCell, error) {
+	if err := s.refreshHeatCells(ctx); err != nil {
+		return nil, err
+	}
+
+	stored := -1
+	for _, l := range HeatLevels {
+		if l >= q.Level {
+			stored = l
+			break
+		}
+	}
+	if stored < 0 {
+		return nil, errors.New("heat level finer than the stored grid")
+	}
+	if q.Exclude != nil {
+		stored = HeatLevels[len(HeatLevels)-1]
+	}
+	shift := stored - q.Level
+
+	where := `h.level = ? AND h.cx BETWEEN ? AND ? AND h.cy BETWEEN ? AND ? AND t.deleted_at IS NULL`
+	args := []any{stored, q.X0 << shift, (q.X1+1)<<shift - 1, q.Y0 << shift, (q.Y1+1)<<shift - 1}
+	if q.From > 0 {
+		where += ` AND h.day >= ?`
+		args = append(args, floorDiv(q.From, 86400))
+	}
+	if q.To > 0 {
+		where += ` AND h.day <= ?`
+		args = append(args, floorDiv(q.To, 86400))
+	}
+	vf, vargs := vesselFilter("t.vessel_id", q.VesselID)
+	where += vf
+	args = append(args, vargs...)
+
+	// Excluding works on stored cells, so summing up moves out of SQL.
+	sqlShift := shift
+	if q.Exclude != nil {
+		sqlShift = 0
+	}
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT h.cx >> %[1]d, h.cy >> %[1]d, SUM(h.dwell_s)
+		FROM heat_cells h JOIN tracks t ON t.id = h.track_id
+		WHERE %[2]s
+		GROUP BY 1, 2
+	`, sqlShift, where), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []HeatCell
+	sums := map[[2]int64]int64{}
+	n := math.Ldexp(1, stored)
+	for rows.Next() {
+		var c HeatCell
+		if err := rows.Scan(&c.X, &c.Y, &c.DwellS); err != nil {
+			return nil, err
+		}
+		if q.Exclude == nil {
+			out = append(out, c)
+			continue
+		}
+		if q.Exclude(geo.MercatorLonLat((float64(c.X)+0.5)/n, (float64(c.Y)+0.5)/n)) {
+			continue
+		}
+		sums[[2]int64{c.X >> shift, c.Y >> shift}] += c.DwellS
+	}
+	for k, dwell := range sums {
+		out = append(out, HeatCell{X: k[0], Y: k[1], DwellS: dwell})
+	}
+	return out, rows.Err()
+}
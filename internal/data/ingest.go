@@ -0,0 +1,419 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+// IngestPolicy says what Submit does when the ingest queue is full.
+type IngestPolicy string
+
+const (
+	// IngestBlock makes the caller wait for room, up to MaxWait.
+	IngestBlock IngestPolicy = "block"
+	// IngestDropNewest refuses the incoming positions.
+	IngestDropNewest IngestPolicy = "drop-newest"
+	// IngestDropOldest evicts the oldest queued submissions, whole, to make
+	// room.
+	IngestDropOldest IngestPolicy = "drop-oldest"
+)
+
+var (
+	// ErrIngestFull means the queue had no room: refused under
+	// drop-newest, or still full after MaxWait under block.
+	ErrIngestFull = errors.New("ingest queue full")
+	// ErrIngestDropped is the result for a submission evicted under
+	// drop-oldest before any of it was written.
+	ErrIngestDropped = errors.New("dropped from ingest queue")
+	// ErrIngestTooLarge means one submission is bigger than the whole
+	// queue; write it with AppendPositions instead.
+	ErrIngestTooLarge = errors.New("more positions than the ingest queue holds")
+	// ErrIngestClosed means the writer has shut down.
+	ErrIngestClosed = errors.New("ingest writer closed")
+)
+
+// IngestConfig sizes the ingest writer. Zero fields take the defaults
+// below.
+type IngestConfig struct {
+	Queue      int           // positions waiting to be written; default 10000
+	MaxBatch   int           // positions per transaction; default 500
+	FlushEvery time.Duration // longest a position waits for its batch; default 1s
+	Policy     IngestPolicy  // default block
+	MaxWait    time.Duration // how long block waits for room; default 5s
+}
+
+func (c IngestConfig) withDefaults() (IngestConfig, error) {
+	if c.Queue <= 0 {
+		c.Queue = 10000
+	}
+	if c.MaxBatch <= 0 {
+		c.MaxBatch = 500
+	}
+	if c.FlushEvery <= 0 {
+		c.FlushEvery = time.Second
+	}
+	if c.MaxWait <= 0 {
+		c.MaxWait = 5 * time.Second
+	}
+	c.MaxBatch = min(c.MaxBatch, c.Queue)
+	switch c.Policy {
+	case "":
+		c.Policy = IngestBlock
+	case IngestBlock, IngestDropNewest, IngestDropOldest:
+	default:
+		return c, fmt.Errorf("ingest policy %q: want block, drop-newest or drop-oldest", c.Policy)
+	}
+	return c, nil
+}
+
+// IngestStats is a snapshot of the writer's counters. Counts are
+// positions except Batches; latencies are per committed transaction.
+type IngestStats struct {
+	Policy    IngestPolicy
+	Depth     int // queued now
+	Peak      int // deepest the queue has been
+	Capacity  int
+	Queued    int64 // accepted into the queue
+	Committed int64
+	Dropped   int64 // evicted under drop-oldest
+	Rejected  int64 // refused because the queue was full
+	Failed    int64 // lost to a failed transaction
+	Batches   int64
+	LastBatch int
+
+	LastCommit time.Duration
+	MaxCommit  time.Duration
+	AvgCommit  time.Duration
+}
+
+// ingestSub is one Submit call's positions. It is queued, evicted and
+// written whole, so a request is stored entirely or not at all.
+type ingestSub struct {
+	trackID int64
+	ps      []db.Position
+	done    chan error
+}
+
+// IngestWriter funnels live positions through one goroutine that writes
+// them in batches: every position in a batch shares one transaction and
+// one prepared insert, so the R*Tree triggers and the fsync are paid per
+// batch rather than per fix. A batch is written once it reaches MaxBatch
+// or its oldest position has waited FlushEvery.
+type IngestWriter struct {
+	s      *Store
+	cfg    IngestConfig
+	insert *sql.Stmt
+
+	mu      sync.Mutex
+	q       []*ingestSub
+	depth   int           // positions in q
+	space   chan struct{} // closed and replaced whenever room is made
+	blocked int           // submitters waiting for room
+	closed  bool
+	stats   IngestStats
+	total   time.Duration // summed commit latency, for AvgCommit
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewIngestWriter prepares the insert and starts the writer goroutine.
+// Close it to write out what is queued.
+func (s *Store) NewIngestWriter(cfg IngestConfig) (*IngestWriter, error) {
+	cfg, err := cfg.withDefaults()
+	if err != nil {
+		return nil, err
+	}
+	insert, err := s.DB.Prepare(insertPositionSQL)
+	if err != nil {
+		return nil, err
+	}
+	w := &IngestWriter{
+		s:      s,
+		cfg:    cfg,
+		insert: insert,
+		space:  make(chan struct{}),
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	w.stats.Policy, w.stats.Capacity = cfg.Policy, cfg.Queue
+	go w.run()
+	return w, nil
+}
+
+// Submit queues positions for a track and returns a channel that yields
+// one result once all of them are written, dropped or failed. When the
+// queue is full it blocks, refuses or evicts according to the policy.
+func (w *IngestWriter) Submit(ctx context.Context, trackID int64, ps []db.Position) (<-chan error, error) {
+	done := make(chan error, 1)
+	if len(ps) == 0 {
+		done <- nil
+		return done, nil
+	}
+	if len(ps) > w.cfg.Queue {
+		return nil, ErrIngestTooLarge
+	}
+
+	var deadline <-chan time.Time
+	w.mu.Lock()
+	for !w.closed && w.depth+len(ps) > w.cfg.Queue {
+		switch w.cfg.Policy {
+		case IngestDropNewest:
+			w.stats.Rejected += int64(len(ps))
+			w.mu.Unlock()
+			return nil, ErrIngestFull
+		case IngestDropOldest:
+			old := w.q[0]
+			w.q = w.q[1:]
+			w.depth -= len(old.ps)
+			w.stats.Dropped += int64(len(old.ps))
+			old.done <- ErrIngestDropped
+			continue
+		}
+		if deadline == nil {
+			t := time.NewTimer(w.cfg.MaxWait)
+			defer t.Stop()
+			deadline = t.C
+		}
+		space := w.space
+		w.blocked++
+		w.mu.Unlock()
+		w.wake() // don't make the blocked wait out FlushEvery
+		var err error
+		select {
+		case <-space:
+		case <-deadline:
+			err = ErrIngestFull
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		w.mu.Lock()
+		w.blocked--
+		if err != nil {
+			if errors.Is(err, ErrIngestFull) {
+				w.stats.Rejected += int64(len(ps))
+			}
+			w.mu.Unlock()
+			return nil, err
+		}
+	}
+	if w.closed {
+		w.mu.Unlock()
+		return nil, ErrIngestClosed
+	}
+	w.q = append(w.q, &ingestSub{trackID: trackID, ps: ps, done: done})
+	w.depth += len(ps)
+	w.stats.Queued += int64(len(ps))
+	w.stats.Peak = max(w.stats.Peak, w.depth)
+	w.mu.Unlock()
+	w.wake()
+	return done, nil
+}
+
+func (w *IngestWriter) wake() {
+	select {
+	case w.kick <- struct{}{}:
+	default:
+	}
+}
+
+// Stats returns the writer's counters.
+func (w *IngestWriter) Stats() IngestStats {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	st := w.stats
+	st.Depth = w.depth
+	if st.Batches > 0 {
+		st.AvgCommit = w.total / time.Duration(st.Batches)
+	}
+	return st
+}
+
+// Close refuses new positions, writes out the queue and releases the
+// prepared insert.
+func (w *IngestWriter) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		<-w.done
+		return nil
+	}
+	w.closed = true
+	close(w.space) // wake blocked submitters to see closed
+	w.mu.Unlock()
+	close(w.stop)
+	<-w.done
+	return w.insert.Close()
+}
+
+func (w *IngestWriter) run() {
+	defer close(w.done)
+	for {
+		select {
+		case <-w.kick:
+		case <-w.stop:
+			w.flush()
+			return
+		}
+		// The first position of a batch is here; give the rest FlushEvery
+		// to arrive unless a full batch, or a sender waiting for room,
+		// turns up sooner.
+		timer := time.NewTimer(w.cfg.FlushEvery)
+	collect:
+		for !w.ready() {
+			select {
+			case <-w.kick:
+			case <-timer.C:
+				break collect
+			case <-w.stop:
+				break collect
+			}
+		}
+		timer.Stop()
+		w.flush()
+	}
+}
+
+func (w *IngestWriter) ready() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.depth >= w.cfg.MaxBatch || w.blocked > 0
+}
+
+// flush writes everything queued, up to MaxBatch positions per
+// transaction. Batches break between submissions, never inside one, so a
+// submission bigger than MaxBatch gets a transaction to itself.
+func (w *IngestWriter) flush() {
+	for {
+		w.mu.Lock()
+		n, size := 0, 0
+		for n < len(w.q) && (n == 0 || size+len(w.q[n].ps) <= w.cfg.MaxBatch) {
+			size += len(w.q[n].ps)
+			n++
+		}
+		batch := w.q[:n:n]
+		w.q = w.q[n:]
+		w.depth -= size
+		if n > 0 && !w.closed {
+			close(w.space)
+			w.space = make(chan struct{})
+		}
+		w.mu.Unlock()
+		if n == 0 {
+			return
+		}
+		w.write(batch)
+	}
+}
+
+// write commits one batch. If the transaction fails and the batch spans
+// several tracks, each track is retried alone so one bad track (deleted
+// meanwhile, say) doesn't lose the others' positions.
+func (w *IngestWriter) write(batch []*ingestSub) {
+	w.s.ingestGate.RLock()
+	defer w.s.ingestGate.RUnlock()
+	groups := groupByTrack(batch)
+	start := time.Now()
+	err := w.writeTx(groups)
+	if err == nil {
+		w.committed(batch, time.Since(start))
+		return
+	}
+	if len(groups) == 1 {
+		w.failed(batch, err)
+		return
+	}
+	for _, g := range groups {
+		start := time.Now()
+		if err := w.writeTx([][]*ingestSub{g}); err != nil {
+			w.failed(g, err)
+		} else {
+			w.committed(g, time.Since(start))
+		}
+	}
+}
+
+func (w *IngestWriter) writeTx(groups [][]*ingestSub) error {
+	ctx := context.Background()
+	tx, err := w.s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	stmt := tx.StmtContext(ctx, w.insert)
+	defer stmt.Close()
+	for _, g := range groups {
+		var ps []db.Position
+		for _, sub := range g {
+			ps = append(ps, sub.ps...)
+		}
+		if err := appendPositionsStmt(ctx, tx, stmt, g[0].trackID, ps); err != nil {
+			return fmt.Errorf("track %d: %w", g[0].trackID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, g := range groups {
+		w.s.InvalidateTrack(g[0].trackID)
+	}
+	return nil
+}
+
+func (w *IngestWriter) committed(subs []*ingestSub, took time.Duration) {
+	n := positions(subs)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Committed += int64(n)
+	w.stats.Batches++
+	w.stats.LastBatch = n
+	w.stats.LastCommit = took
+	w.stats.MaxCommit = max(w.stats.MaxCommit, took)
+	w.total += took
+	for _, sub := range subs {
+		sub.done <- nil
+	}
+}
+
+func (w *IngestWriter) failed(subs []*ingestSub, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.stats.Failed += int64(positions(subs))
+	for _, sub := range subs {
+		sub.done <- err
+	}
+}
+
+func positions(subs []*ingestSub) int {
+	n := 0
+	for _, sub := range subs {
+		n += len(sub.ps)
+	}
+	return n
+}
+
+// groupByTrack splits a batch into runs per track, keeping each track's
+// submissions in arrival order.
+func groupByTrack(batch []*ingestSub) [][]*ingestSub {
+	idx := map[int64]int{}
+	var out [][]*ingestSub
+	for _, sub := range batch {
+		i, ok := idx[sub.trackID]
+		if !ok {
+			i = len(out)
+			idx[sub.trackID] = i
+			out = append(out, nil)
+		}
+		out[i] = append(out[i], sub)
+	}
+	return out
+}
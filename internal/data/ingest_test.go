@@ -0,0 +1,268 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+func openTestStore(t *testing.T) *Store {
+	t.Helper()
+	s, err := Open(filepath.Join(t.TempDir(), "wakemap.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = s.Close() })
+	return s
+}
+
+func newTestTrack(t *testing.T, s *Store) int64 {
+	t.Helper()
+	res, err := s.DB.Exec(`INSERT INTO tracks (name, started_at) VALUES ('test', 0)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := res.LastInsertId()
+	return id
+}
+
+// testFixes returns n positions one second apart, starting at from.
+func testFixes(n int, from int64) []db.Position {
+	ps := make([]db.Position, n)
+	for i := range ps {
+		ps[i] = db.Position{TMs: (from + int64(i)) * 1000, Lon: 151.3, Lat: -33.6 + float64(i)*1e-4}
+	}
+	return ps
+}
+
+func countFixes(t *testing.T, s *Store, trackID int64) int {
+	t.Helper()
+	var n int
+	if err := s.DB.QueryRow(`SELECT count(*) FROM positions WHERE track_id = ?`, trackID).Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+// lockDB holds the write lock from another connection, so the writer's
+// next transaction waits in the busy handler until release is called.
+func lockDB(t *testing.T, s *Store) (release func()) {
+	t.Helper()
+	conn, err := s.DB.Conn(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := conn.ExecContext(context.Background(), `BEGIN IMMEDIATE`); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		_, _ = conn.ExecContext(context.Background(), `COMMIT`)
+		_ = conn.Close()
+	}
+}
+
+// stallWriter submits ps and waits until the writer has taken it off the
+// queue, where it sits behind the lock from lockDB.
+func stallWriter(t *testing.T, w *IngestWriter, trackID int64, ps []db.Position) <-chan error {
+	t.Helper()
+	done, err := w.Submit(context.Background(), trackID, ps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for w.Stats().Depth > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	return done
+}
+
+func newTestWriter(t *testing.T, s *Store, cfg IngestConfig) *IngestWriter {
+	t.Helper()
+	w, err := s.NewIngestWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+func wantResult(t *testing.T, done <-chan error, want error) {
+	t.Helper()
+	select {
+	case err := <-done:
+		if !errors.Is(err, want) {
+			t.Fatalf("result %v, want %v", err, want)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+	}
+}
+
+func TestIngestBlockTimesOut(t *testing.T) {
+	s := openTestStore(t)
+	id := newTestTrack(t, s)
+	w := newTestWriter(t, s, IngestConfig{Queue: 2, FlushEvery: time.Millisecond, MaxWait: 50 * time.Millisecond})
+
+	release := lockDB(t, s)
+	a := stallWriter(t, w, id, testFixes(2, 0))
+	b, err := w.Submit(context.Background(), id, testFixes(2, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := w.Submit(context.Background(), id, testFixes(2, 20)); !errors.Is(err, ErrIngestFull) {
+		t.Fatalf("Submit to a full queue: %v, want ErrIngestFull", err)
+	}
+	if took := time.Since(start); took < 50*time.Millisecond {
+		t.Errorf("gave up after %v, before MaxWait", took)
+	}
+	release()
+
+	wantResult(t, a, nil)
+	wantResult(t, b, nil)
+	if n := countFixes(t, s, id); n != 4 {
+		t.Errorf("%d fixes stored, want 4", n)
+	}
+	if st := w.Stats(); st.Rejected != 2 {
+		t.Errorf("Rejected = %d, want 2", st.Rejected)
+	}
+}
+
+func TestIngestDropNewest(t *testing.T) {
+	s := openTestStore(t)
+	id := newTestTrack(t, s)
+	w := newTestWriter(t, s, IngestConfig{Queue: 2, FlushEvery: time.Millisecond, Policy: IngestDropNewest})
+
+	release := lockDB(t, s)
+	a := stallWriter(t, w, id, testFixes(2, 0))
+	b, err := w.Submit(context.Background(), id, testFixes(2, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Submit(context.Background(), id, testFixes(1, 20)); !errors.Is(err, ErrIngestFull) {
+		t.Fatalf("Submit to a full queue: %v, want ErrIngestFull", err)
+	}
+	release()
+
+	wantResult(t, a, nil)
+	wantResult(t, b, nil)
+	if n := countFixes(t, s, id); n != 4 {
+		t.Errorf("%d fixes stored, want 4", n)
+	}
+	if st := w.Stats(); st.Rejected != 1 || st.Dropped != 0 {
+		t.Errorf("Rejected, Dropped = %d, %d; want 1, 0", st.Rejected, st.Dropped)
+	}
+}
+
+func TestIngestDropOldestEvictsWholeSubmissions(t *testing.T) {
+	s := openTestStore(t)
+	id := newTestTrack(t, s)
+	w := newTestWriter(t, s, IngestConfig{Queue: 4, FlushEvery: time.Millisecond, Policy: IngestDropOldest})
+
+	release := lockDB(t, s)
+	a := stallWriter(t, w, id, testFixes(2, 0))
+	b, err := w.Submit(context.Background(), id, testFixes(3, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := w.Submit(context.Background(), id, testFixes(1, 20))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Two more need one slot beyond the four queued: all of b goes, not
+	// just one of its fixes.
+	d, err := w.Submit(context.Background(), id, testFixes(2, 30))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult(t, b, ErrIngestDropped)
+	release()
+
+	wantResult(t, a, nil)
+	wantResult(t, c, nil)
+	wantResult(t, d, nil)
+	if n := countFixes(t, s, id); n != 5 {
+		t.Errorf("%d fixes stored, want 5", n)
+	}
+	if st := w.Stats(); st.Dropped != 3 {
+		t.Errorf("Dropped = %d, want 3", st.Dropped)
+	}
+}
+
+func TestIngestCloseReleasesBlockedSubmitters(t *testing.T) {
+	s := openTestStore(t)
+	id := newTestTrack(t, s)
+	w := newTestWriter(t, s, IngestConfig{Queue: 2, FlushEvery: time.Millisecond, MaxWait: time.Minute})
+
+	release := lockDB(t, s)
+	a := stallWriter(t, w, id, testFixes(2, 0))
+	b, err := w.Submit(context.Background(), id, testFixes(2, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocked := make(chan error, 1)
+	go func() {
+		_, err := w.Submit(context.Background(), id, testFixes(2, 20))
+		blocked <- err
+	}()
+	for {
+		w.mu.Lock()
+		n := w.blocked
+		w.mu.Unlock()
+		if n > 0 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- w.Close() }()
+	wantResult(t, blocked, ErrIngestClosed)
+	release()
+
+	wantResult(t, closed, nil)
+	wantResult(t, a, nil)
+	wantResult(t, b, nil)
+	if n := countFixes(t, s, id); n != 4 {
+		t.Errorf("%d fixes stored, want 4", n)
+	}
+	if _, err := w.Submit(context.Background(), id, testFixes(1, 30)); !errors.Is(err, ErrIngestClosed) {
+		t.Errorf("Submit after Close: %v, want ErrIngestClosed", err)
+	}
+}
+
+func TestIngestFailedTrackSparesTheBatch(t *testing.T) {
+	s := openTestStore(t)
+	good := newTestTrack(t, s)
+	w := newTestWriter(t, s, IngestConfig{Queue: 10, MaxBatch: 4, FlushEvery: time.Minute})
+
+	// Both submissions fill one batch; the missing track's fail the
+	// shared transaction and the retry per track.
+	ok, err := w.Submit(context.Background(), good, testFixes(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := w.Submit(context.Background(), good+100, testFixes(2, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantResult(t, ok, nil)
+	select {
+	case err := <-bad:
+		if err == nil {
+			t.Fatal("positions for a missing track were accepted")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+	}
+
+	if n := countFixes(t, s, good); n != 2 {
+		t.Errorf("%d fixes stored, want 2", n)
+	}
+	if st := w.Stats(); st.Committed != 2 || st.Failed != 2 {
+		t.Errorf("Committed, Failed = %d, %d; want 2, 2", st.Committed, st.Failed)
+	}
+}
@@ -0,0 +1,365 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+// Log entry kinds.
+const (
+	LogDeparture = "departure"
+	LogArrival   = "arrival"
+	LogPosition  = "position"
+	LogStop      = "stop"
+)
+
+// ManualLogKinds are the kinds crew may write; the rest are generated.
+var ManualLogKinds = map[string]bool{
+	"crew":      true,
+	"sail_plan": true,
+	"event":     true,
+	"weather":   true,
+	"engine":    true,
+	"note":      true,
+}
+
+// Automatic position entries are written on the hour while underway.
+const logIntervalS = 3600
+
+// LogQuery selects a passage (TrackID) or a time range (From/To, 0 = open),
+// optionally for one vessel. Automatic entries belong to their track's
+// vessel, manual ones to the vessel they were written for.
+type LogQuery struct {
+	TrackID  int64
+	From, To int64
+	VesselID int64
+}
+
+// Automatic entries take their vessel from the track, so a reassigned
+// track's log follows it.
+const logEntryCols = `e.id, e.track_id, e.t, e.auto, e.kind, e.text, e.lon, e.lat, e.sog_ms, e.cog_rad, e.created_at,
+	CASE WHEN e.auto = 1 THEN (SELECT vessel_id FROM tracks WHERE id = e.track_id) ELSE e.vessel_id END`
+
+func scanLogEntry(sc interface{ Scan(...any) error }) (db.LogEntry, error) {
+	var e db.LogEntry
+	err := sc.Scan(&e.ID, &e.TrackID, &e.T, &e.Auto, &e.Kind, &e.Text, &e.Lon, &e.Lat, &e.SogMs, &e.CogRad, &e.CreatedAt, &e.VesselID)
+	return e, err
+}
+
+// Logbook returns log entries in time order. For a passage that is its
+// automatic entries plus any manual entries written while it was under
+// way on that boat, so manual entries survive splits and merges.
+func (s *Store) Logbook(ctx context.Context, q LogQuery) ([]db.LogEntry, error) {
+	if err := s.refreshLogbook(ctx); err != nil {
+		return nil, err
+	}
+
+	where := []string{"(e.auto = 0 OR tr.deleted_at IS NULL)"}
+	var args []any
+	if q.TrackID != 0 {
+		tr, err := s.Track(ctx, q.TrackID)
+		if err != nil {
+			return nil, err
+		}
+		sum, err := s.TrackSummary(ctx, q.TrackID)
+		if err != nil {
+			return nil, err
+		}
+		where = append(where, "((e.auto = 1 AND e.track_id = ?) OR (e.auto = 0 AND e.t BETWEEN ? AND ? AND e.vessel_id IS ?))")
+		args = append(args, q.TrackID, sum.StartedAt.Int64, sum.EndedAt.Int64, tr.VesselID)
+	}
+	if q.VesselID != 0 {
+		where = append(where, "CASE WHEN e.auto = 1 THEN tr.vessel_id ELSE e.vessel_id END = ?")
+		args = append(args, q.VesselID)
+	}
+	if q.From > 0 {
+		where = append(where, "e.t >= ?")
+		args = append(args, q.From)
+	}
+	if q.To > 0 {
+		where = append(where, "e.t <= ?")
+		args = append(args, q.To)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT `+logEntryCols+`
+		FROM log_entries e LEFT JOIN tracks tr ON tr.id = e.track_id
+		WHERE `+strings.Join(where, " AND ")+`
+		ORDER BY e.t, e.auto DESC, e.id
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []db.LogEntry
+	for rows.Next() {
+		e, err := scanLogEntry(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// AddLogEntry writes a manual entry for a vessel (0 = whichever boat was
+// under way, else the only one), tagging it with the boat's position, SOG
+// and COG at time t when a track covers it.
+func (s *Store) AddLogEntry(ctx context.Context, t, vesselID int64, kind, text string) (db.LogEntry, error) {
+	e := db.LogEntry{T: t, Kind: kind, Text: text, CreatedAt: time.Now().Unix()}
+	if err := s.tagLogEntry(ctx, &e, vesselID); err != nil {
+		return e, err
+	}
+	res, err := s.DB.ExecContext(ctx, `
+		INSERT INTO log_entries (track_id, t, auto, kind, text, lon, lat, sog_ms, cog_rad, created_at, vessel_id)
+		VALUES (?, ?, 0, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.TrackID, e.T, e.Kind, e.Text, e.Lon, e.Lat, e.SogMs, e.CogRad, e.CreatedAt, e.VesselID)
+	if err != nil {
+		return e, err
+	}
+	e.ID, err = res.LastInsertId()
+	return e, err
+}
+
+// UpdateLogEntry rewrites a manual entry's time, vessel, kind and text,
+// re-tagging its position if the time or vessel changed. vesselID 0 keeps
+// the entry's vessel. Automatic entries can't be edited.
+func (s *Store) UpdateLogEntry(ctx context.Context, id, t, vesselID int64, kind, text string) (db.LogEntry, error) {
+	e, err := scanLogEntry(s.DB.QueryRowContext(ctx, `SELECT `+logEntryCols+` FROM log_entries e WHERE e.id = ? AND e.auto = 0`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return e, ErrNotFound
+	}
+	if err != nil {
+		return e, err
+	}
+	if vesselID == 0 {
+		vesselID = e.VesselID.Int64
+	}
+	if t != e.T || vesselID != e.VesselID.Int64 {
+		e.T = t
+		if err := s.tagLogEntry(ctx, &e, vesselID); err != nil {
+			return e, err
+		}
+	}
+	e.Kind, e.Text = kind, text
+	_, err = s.DB.ExecContext(ctx, `
+		UPDATE log_entries SET track_id = ?, t = ?, kind = ?, text = ?, lon = ?, lat = ?, sog_ms = ?, cog_rad = ?, vessel_id = ?
+		WHERE id = ?
+	`, e.TrackID, e.T, e.Kind, e.Text, e.Lon, e.Lat, e.SogMs, e.CogRad, e.VesselID, e.ID)
+	return e, err
+}
+
+// DeleteLogEntry removes a manual entry.
+func (s *Store) DeleteLogEntry(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM log_entries WHERE id = ? AND auto = 0`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// tagLogEntry sets e's track, position and vessel from the track covering
+// e.T on vesselID's boat (0 = any boat).
+func (s *Store) tagLogEntry(ctx context.Context, e *db.LogEntry, vesselID int64) error {
+	e.TrackID, e.Lon, e.Lat, e.SogMs, e.CogRad = sql.NullInt64{}, sql.NullFloat64{}, sql.NullFloat64{}, sql.NullFloat64{}, sql.NullFloat64{}
+	e.VesselID = sql.NullInt64{Int64: vesselID, Valid: vesselID != 0}
+	if _, err := s.RefreshStaleSummaries(ctx); err != nil {
+		return err
+	}
+	fix, err := s.PositionAt(ctx, e.T*1000, vesselID)
+	if errors.Is(err, ErrNotFound) {
+		if !e.VesselID.Valid {
+			id, err := s.DefaultVesselID(ctx)
+			e.VesselID = sql.NullInt64{Int64: id, Valid: id != 0}
+			return err
+		}
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !e.VesselID.Valid && fix.VesselID != 0 {
+		e.VesselID = sql.NullInt64{Int64: fix.VesselID, Valid: true}
+	}
+	e.TrackID = sql.NullInt64{Int64: fix.TrackID, Valid: true}
+	e.Lon = sql.NullFloat64{Float64: fix.Lon, Valid: true}
+	e.Lat = sql.NullFloat64{Float64: fix.Lat, Valid: true}
+	e.SogMs, e.CogRad = fix.SogMs, fix.CogRad
+	return nil
+}
+
+// refreshLogbook re-derives automatic entries for live tracks whose
+// summary has moved on since they were written.
+func (s *Store) refreshLogbook(ctx context.Context) error {
+	work, err := s.staleDerived(ctx, "log_version")
+	if err != nil {
+		return err
+	}
+	for _, w := range work {
+		if err := s.buildLogEntries(ctx, w.id, w.version); err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+	}
+	return nil
+}
+
+// buildLogEntries writes a track's automatic entries: departure, arrival,
+// the start and end of each stop, and an hourly position while underway.
+func (s *Store) buildLogEntries(ctx context.Context, trackID, version int64) error {
+	ps, err := s.TrackPositions(ctx, trackID)
+	if err != nil {
+		return err
+	}
+	ts, err := s.ComputeTrackStats(ctx, trackID)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	var entries []db.LogEntry
+	fixEntry := func(kind, text string, p db.Position) {
+		entries = append(entries, db.LogEntry{
+			T: p.T, Kind: kind, Text: text,
+			Lon: sql.NullFloat64{Float64: p.Lon, Valid: true}, Lat: sql.NullFloat64{Float64: p.Lat, Valid: true},
+			SogMs: p.SogMs, CogRad: p.CogRad,
+		})
+	}
+	// "Departed Port Stephens" when the gazetteer knows the place.
+	endText := func(kind string, p db.Position) (string, error) {
+		verb := logEndVerbs[kind]
+		name, err := s.placeName(ctx, p.Lon, p.Lat)
+		if err != nil || name == "" {
+			return verb, err
+		}
+		return verb + " " + name, nil
+	}
+	if len(ps) > 0 {
+		text, err := endText(LogDeparture, ps[0])
+		if err != nil {
+			return err
+		}
+		fixEntry(LogDeparture, text, ps[0])
+	}
+
+	var segs []Segment
+	if ts != nil {
+		segs = ts.Segments
+	}
+	for _, sg := range segs {
+		if !sg.Stationary() {
+			continue
+		}
+		fixEntry(LogStop, stopText(sg, true), ps[positionIndex(ps, sg.StartedAt)])
+		if sg.EndedAt < ps[len(ps)-1].T {
+			fixEntry(LogStop, stopText(sg, false), ps[positionIndex(ps, sg.EndedAt)])
+		}
+	}
+
+	if len(ps) > 1 {
+		i := 0
+		for h := (ps[0].T/logIntervalS + 1) * logIntervalS; h < ps[len(ps)-1].T; h += logIntervalS {
+			if stoppedDuring(segs, h) {
+				continue
+			}
+			for ps[i+1].T < h {
+				i++
+			}
+			e := db.LogEntry{T: h, Kind: LogPosition}
+			lon, lat, sog, cog := interpolateFix(ps[i], ps[i+1], h)
+			e.Lon = sql.NullFloat64{Float64: lon, Valid: true}
+			e.Lat = sql.NullFloat64{Float64: lat, Valid: true}
+			e.SogMs, e.CogRad = sog, cog
+			entries = append(entries, e)
+		}
+		text, err := endText(LogArrival, ps[len(ps)-1])
+		if err != nil {
+			return err
+		}
+		fixEntry(LogArrival, text, ps[len(ps)-1])
+	}
+
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM log_entries WHERE track_id = ? AND auto = 1`, trackID); err != nil {
+		return err
+	}
+	stmt, err := tx.PrepareContext(ctx, `
+		INSERT INTO log_entries (track_id, t, auto, kind, text, lon, lat, sog_ms, cog_rad, created_at)
+		VALUES (?, ?, 1, ?, ?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	now := time.Now().Unix()
+	for _, e := range entries {
+		if _, err := stmt.ExecContext(ctx, trackID, e.T, e.Kind, e.Text, e.Lon, e.Lat, e.SogMs, e.CogRad, now); err != nil {
+			return err
+		}
+	}
+	if err := markDerivedTx(ctx, tx, "log_version", trackID, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// logEndVerbs start the generated departure and arrival entries, which
+// go on to name the place.
+var logEndVerbs = map[string]string{LogDeparture: "Departed", LogArrival: "Arrived"}
+
+// UnnamePlace drops the place from a generated departure or arrival
+// entry, for views that don't show where it was written. Other entries
+// name no place, or are the crew's own words, and are left alone.
+func UnnamePlace(e *db.LogEntry) {
+	if verb, ok := logEndVerbs[e.Kind]; ok && e.Auto == 1 {
+		e.Text = verb
+	}
+}
+
+func stopText(sg Segment, start bool) string {
+	switch {
+	case sg.Kind == SegAnchored && start:
+		return fmt.Sprintf("Anchored (swing radius %.0f m)", sg.RadiusM)
+	case sg.Kind == SegAnchored:
+		return "Weighed anchor"
+	case sg.Kind == SegMoored && start:
+		return "Moored"
+	case sg.Kind == SegMoored:
+		return "Cast off"
+	case start:
+		return "Drifting"
+	default:
+		return "Under way"
+	}
+}
+
+// positionIndex is the index of the first fix at or after t.
+func positionIndex(ps []db.Position, t int64) int {
+	for i, p := range ps {
+		if p.T >= t {
+			return i
+		}
+	}
+	return len(ps) - 1
+}
+
+func stoppedDuring(segs []Segment, t int64) bool {
+	for _, sg := range segs {
+		if sg.Kind != SegUnderway && sg.Kind != SegDrifting && sg.StartedAt <= t && t <= sg.EndedAt {
+			return true
+		}
+	}
+	return false
+}
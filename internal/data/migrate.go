@@ -1,18 +1,270 @@
 package data
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
 	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 )
 
-//go:embed migrations/001_init.sql
+//go:embed migrations/*.sql
 var migrationsFS embed.FS
 
-func ensureSchema(db *sql.DB) error {
-	ddlBytes, err := migrationsFS.ReadFile("migrations/001_init.sql")
+var (
+	// ErrSchemaTooNew means the database has migrations this binary
+	// doesn't know, i.e. a newer wakemap has used it.
+	ErrSchemaTooNew = errors.New("database schema is newer than this wakemap")
+	// ErrMigrationModified means an applied migration's file has changed
+	// since; the schema may not be what the file now says.
+	ErrMigrationModified = errors.New("applied migration has been modified")
+)
+
+// Migration is one embedded schema step, migrations/NNN_name.sql.
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string // sha256 of the file, hex
+	sql      string
+}
+
+// MigrationState is a migration as the database sees it. Known is false
+// for one applied by a newer binary; AppliedAt is 0 for one applied before
+// the database tracked migrations.
+type MigrationState struct {
+	Migration
+	Known     bool
+	Applied   bool
+	AppliedAt int64
+	Modified  bool // applied, but the file's checksum has changed since
+}
+
+const migrationsTableSQL = `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+	  version    INTEGER PRIMARY KEY,
+	  name       TEXT NOT NULL,
+	  checksum   TEXT NOT NULL,
+	  applied_at INTEGER            -- epoch seconds; NULL = before tracking
+	)`
+
+// embeddedMigrations lists the migrations built into the binary, in order.
+func embeddedMigrations() ([]Migration, error) {
+	names, err := fs.Glob(migrationsFS, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	out := make([]Migration, 0, len(names))
+	for _, name := range names {
+		prefix, rest, _ := strings.Cut(path.Base(name), "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: bad version prefix", name)
+		}
+		if n := len(out); n > 0 && out[n-1].Version == v {
+			return nil, fmt.Errorf("migration %s: version %d used twice", name, v)
+		}
+		b, err := migrationsFS.ReadFile(name)
+		if err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(b)
+		out = append(out, Migration{
+			Version:  v,
+			Name:     strings.TrimSuffix(rest, ".sql"),
+			Checksum: hex.EncodeToString(sum[:]),
+			sql:      string(b),
+		})
+	}
+	return out, nil
+}
+
+// Migrations reports every embedded migration and any unknown applied
+// one, by version. It only reads: a database migrated before
+// schema_migrations existed is reported from PRAGMA user_version.
+func Migrations(ctx context.Context, db *sql.DB) ([]MigrationState, error) {
+	all, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(ctx, db, all)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]MigrationState, 0, len(all))
+	for _, m := range all {
+		st := MigrationState{Migration: m, Known: true}
+		if a, ok := applied[m.Version]; ok {
+			st.Applied, st.AppliedAt = true, a.AppliedAt
+			st.Modified = a.Checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		out = append(out, st)
+	}
+	for _, a := range applied {
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// MigrateUp applies pending migrations in order, each in its own
+// transaction with its schema_migrations row, and returns the ones
+// applied. It refuses a database with unknown or modified migrations.
+func MigrateUp(ctx context.Context, db *sql.DB) ([]Migration, error) {
+	all, err := embeddedMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := trackMigrations(ctx, db, all); err != nil {
+		return nil, err
+	}
+	states, err := Migrations(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrations(states); err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, st := range states {
+		if st.Applied {
+			continue
+		}
+		if err := applyMigration(ctx, db, st.Migration); err != nil {
+			return done, err
+		}
+		done = append(done, st.Migration)
+	}
+	return done, nil
+}
+
+// checkMigrations fails on migrations a newer binary applied or whose
+// files have changed since they ran.
+func checkMigrations(states []MigrationState) error {
+	for _, st := range states {
+		switch {
+		case !st.Known:
+			return fmt.Errorf("%w: migration %03d_%s", ErrSchemaTooNew, st.Version, st.Name)
+		case st.Modified:
+			return fmt.Errorf("%w: %03d_%s.sql", ErrMigrationModified, st.Version, st.Name)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *sql.DB, m Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, m.sql); err != nil {
+		return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	// A second process applying the same step fails here, on the primary
+	// key, and its copy of the step rolls back with it.
+	if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum, applied_at) VALUES (?, ?, ?, ?)`,
+		m.Version, m.Name, m.Checksum, time.Now().Unix()); err != nil {
+		return fmt.Errorf("migration %03d_%s: %w", m.Version, m.Name, err)
+	}
+	// Kept for the sqlite3 shell and binaries from before schema_migrations.
+	if _, err := tx.ExecContext(ctx, fmt.Sprintf(`PRAGMA user_version = %d`, m.Version)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// appliedMigrations reads schema_migrations by version. Without it, or
+// before anything is recorded there, the embedded migrations up to PRAGMA
+// user_version count as applied before tracking. A user_version past
+// every known migration shows up as one unknown entry.
+func appliedMigrations(ctx context.Context, db *sql.DB, all []Migration) (map[int]MigrationState, error) {
+	applied := map[int]MigrationState{}
+	var tracked int
+	if err := db.QueryRowContext(ctx, `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`).Scan(&tracked); err != nil {
+		return nil, err
+	}
+	if tracked > 0 {
+		rows, err := db.QueryContext(ctx, `SELECT version, name, checksum, COALESCE(applied_at, 0) FROM schema_migrations`)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var st MigrationState
+			if err := rows.Scan(&st.Version, &st.Name, &st.Checksum, &st.AppliedAt); err != nil {
+				return nil, err
+			}
+			st.Applied = true
+			applied[st.Version] = st
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	var have int
+	if err := db.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&have); err != nil {
+		return nil, err
+	}
+	if len(applied) == 0 {
+		for _, m := range all {
+			if m.Version > have {
+				break
+			}
+			applied[m.Version] = MigrationState{Migration: m, Applied: true}
+		}
+	}
+	if _, ok := applied[have]; have > 0 && !ok {
+		applied[have] = MigrationState{Migration: Migration{Version: have, Name: "user_version"}, Applied: true}
+	}
+	return applied, nil
+}
+
+// trackMigrations creates schema_migrations and, for a database migrated
+// before it existed, records what PRAGMA user_version says was applied.
+// Those rows take today's checksums; there is nothing older to compare.
+// A user_version this binary doesn't know is left for checkMigrations.
+func trackMigrations(ctx context.Context, db *sql.DB, all []Migration) error {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(string(ddlBytes))
-	return err
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, migrationsTableSQL); err != nil {
+		return err
+	}
+	var n, have int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM schema_migrations`).Scan(&n); err != nil {
+		return err
+	}
+	if err := tx.QueryRowContext(ctx, `PRAGMA user_version`).Scan(&have); err != nil {
+		return err
+	}
+	if n == 0 && have > 0 && len(all) > 0 && have <= all[len(all)-1].Version {
+		for _, m := range all {
+			if m.Version > have {
+				break
+			}
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)`,
+				m.Version, m.Name, m.Checksum); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
 }
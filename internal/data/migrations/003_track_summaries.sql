@@ -0,0 +1,37 @@
+-- Per-track aggregates maintained as positions are written, so listing and
+-- detail views don't rescan positions. tracks.started_at/ended_at/distance_m
+-- are kept in step with the summary.
+CREATE TABLE IF NOT EXISTS track_summaries (
+  track_id   INTEGER PRIMARY KEY REFERENCES tracks(id) ON DELETE CASCADE,
+  points     INTEGER NOT NULL DEFAULT 0,
+  distance_m REAL NOT NULL DEFAULT 0,
+  started_at INTEGER,                -- epoch seconds
+  ended_at   INTEGER,
+  min_x      REAL,
+  min_y      REAL,
+  max_x      REAL,
+  max_y      REAL,
+  last_lon   REAL,                   -- last fix, for incremental distance
+  last_lat   REAL,
+  stale      INTEGER NOT NULL DEFAULT 1,
+  updated_at INTEGER NOT NULL
+);
+
+-- Any write to positions marks the summary stale. The app's write path
+-- folds its own inserts in and clears the flag in the same transaction;
+-- writers that bypass it (seed scripts, the sqlite3 shell) leave it set
+-- and the summary is rebuilt on next read.
+CREATE TRIGGER IF NOT EXISTS positions_summary_ins
+AFTER INSERT ON positions BEGIN
+  UPDATE track_summaries SET stale = 1 WHERE track_id = new.track_id AND stale = 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS positions_summary_upd
+AFTER UPDATE OF track_id, t, lon, lat ON positions BEGIN
+  UPDATE track_summaries SET stale = 1 WHERE track_id IN (old.track_id, new.track_id) AND stale = 0;
+END;
+
+CREATE TRIGGER IF NOT EXISTS positions_summary_del
+AFTER DELETE ON positions BEGIN
+  UPDATE track_summaries SET stale = 1 WHERE track_id = old.track_id AND stale = 0;
+END;
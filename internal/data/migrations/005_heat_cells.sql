@@ -0,0 +1,18 @@
+-- Dwell-time heatmap grid. Each fix contributes the time until the next fix
+-- (capped) to the Web Mercator cell containing it, binned per UTC day so
+-- date filters work. Stored at a few levels (a level-L grid is 2^L cells
+-- square) and summed further on read. Rows are re-derived whenever the
+-- track summary version moves past heat_version.
+ALTER TABLE track_summaries ADD COLUMN heat_version INTEGER;
+
+CREATE TABLE IF NOT EXISTS heat_cells (
+  track_id INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  level    INTEGER NOT NULL,
+  cx       INTEGER NOT NULL,
+  cy       INTEGER NOT NULL,
+  day      INTEGER NOT NULL,       -- epoch seconds / 86400, UTC
+  dwell_s  INTEGER NOT NULL,
+  PRIMARY KEY (track_id, level, cx, cy, day)
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS idx_heat_cells_cell ON heat_cells(level, cx, cy);
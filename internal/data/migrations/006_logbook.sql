@@ -0,0 +1,24 @@
+-- Ship's log. Automatic entries (auto = 1) are derived from each track's
+-- positions and re-derived whenever the track summary version moves past
+-- log_version; manual entries are written by the crew and never touched.
+ALTER TABLE track_summaries ADD COLUMN log_version INTEGER;
+
+CREATE TABLE IF NOT EXISTS log_entries (
+  id         INTEGER PRIMARY KEY,
+  track_id   INTEGER REFERENCES tracks(id) ON DELETE SET NULL,
+  t          INTEGER NOT NULL,     -- epoch seconds
+  auto       INTEGER NOT NULL DEFAULT 0,
+  kind       TEXT NOT NULL CHECK (kind IN (
+               'departure','arrival','position','stop',           -- automatic
+               'crew','sail_plan','event','weather','engine','note' -- manual
+             )),
+  text       TEXT NOT NULL DEFAULT '',
+  lon        REAL,
+  lat        REAL,
+  sog_ms     REAL,
+  cog_rad    REAL,
+  created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_log_entries_t ON log_entries(t);
+CREATE INDEX IF NOT EXISTS idx_log_entries_track ON log_entries(track_id, auto);
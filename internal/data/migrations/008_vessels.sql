@@ -0,0 +1,32 @@
+-- Boats. One server can log several; every track (and manual log entry)
+-- belongs to at most one. Polar and engine details are free-form JSON.
+CREATE TABLE IF NOT EXISTS vessels (
+  id          INTEGER PRIMARY KEY,
+  name        TEXT NOT NULL,
+  mmsi        TEXT UNIQUE,         -- 9 digits
+  callsign    TEXT,
+  loa_m       REAL,
+  beam_m      REAL,
+  draft_m     REAL,
+  air_draft_m REAL,
+  polar       TEXT,                -- JSON
+  engine      TEXT,                -- JSON
+  created_at  INTEGER NOT NULL,
+  updated_at  INTEGER NOT NULL
+);
+
+ALTER TABLE tracks ADD COLUMN vessel_id INTEGER REFERENCES vessels(id) ON DELETE SET NULL;
+ALTER TABLE log_entries ADD COLUMN vessel_id INTEGER REFERENCES vessels(id) ON DELETE SET NULL;
+
+CREATE INDEX IF NOT EXISTS idx_tracks_vessel ON tracks(vessel_id, started_at);
+
+-- Everything logged so far was one boat; give it a row to rename later.
+INSERT INTO vessels (name, created_at, updated_at)
+SELECT 'My boat', CAST(strftime('%s', 'now') AS INTEGER), CAST(strftime('%s', 'now') AS INTEGER)
+WHERE EXISTS (SELECT 1 FROM tracks) AND NOT EXISTS (SELECT 1 FROM vessels);
+
+UPDATE tracks SET vessel_id = (SELECT MIN(id) FROM vessels) WHERE vessel_id IS NULL;
+UPDATE log_entries SET vessel_id = COALESCE(
+  (SELECT vessel_id FROM tracks WHERE tracks.id = log_entries.track_id),
+  (SELECT MIN(id) FROM vessels)
+) WHERE vessel_id IS NULL AND auto = 0;
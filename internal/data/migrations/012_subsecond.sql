@@ -0,0 +1,34 @@
+-- Millisecond timestamps. At 5-10 Hz many fixes share a second, so t_ms
+-- (epoch milliseconds) orders and spaces them; t stays as whole seconds,
+-- floor(t_ms / 1000), for anything that only needs the second.
+ALTER TABLE positions ADD COLUMN t_ms INTEGER NOT NULL DEFAULT 0;
+UPDATE positions SET t_ms = t * 1000;
+
+-- Writers that bypass the app (seed scripts, the sqlite3 shell) only set
+-- or change t; carry it over to t_ms.
+CREATE TRIGGER IF NOT EXISTS positions_t_ms_ins
+AFTER INSERT ON positions WHEN new.t_ms = 0 BEGIN
+  UPDATE positions SET t_ms = new.t * 1000 WHERE id = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS positions_t_ms_upd
+AFTER UPDATE OF t ON positions WHEN new.t <> old.t AND new.t_ms = old.t_ms BEGIN
+  UPDATE positions SET t_ms = new.t * 1000 WHERE id = new.id;
+END;
+
+CREATE TRIGGER IF NOT EXISTS positions_summary_upd_ms
+AFTER UPDATE OF t_ms ON positions BEGIN
+  UPDATE track_summaries SET stale = 1 WHERE track_id = new.track_id AND stale = 0;
+END;
+
+DROP INDEX IF EXISTS idx_positions_track_time;
+CREATE INDEX IF NOT EXISTS idx_positions_track_time_ms ON positions(track_id, t_ms);
+
+-- started_at/ended_at stay in seconds alongside.
+ALTER TABLE tracks ADD COLUMN started_at_ms INTEGER;
+ALTER TABLE tracks ADD COLUMN ended_at_ms INTEGER;
+UPDATE tracks SET started_at_ms = started_at * 1000, ended_at_ms = ended_at * 1000;
+
+ALTER TABLE track_summaries ADD COLUMN started_at_ms INTEGER;
+ALTER TABLE track_summaries ADD COLUMN ended_at_ms INTEGER;
+UPDATE track_summaries SET started_at_ms = started_at * 1000, ended_at_ms = ended_at * 1000;
@@ -0,0 +1,8 @@
+-- Where each route end is, beside the place name resolved for it, so a
+-- view that hides private zones can tell when a name gives one away.
+-- Clearing place_version re-resolves every route to fill them in.
+ALTER TABLE track_summaries ADD COLUMN from_lon REAL;
+ALTER TABLE track_summaries ADD COLUMN from_lat REAL;
+ALTER TABLE track_summaries ADD COLUMN to_lon REAL;
+ALTER TABLE track_summaries ADD COLUMN to_lat REAL;
+UPDATE track_summaries SET place_version = NULL;
@@ -0,0 +1,214 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+
+	"wakemap/internal/db"
+)
+
+// Stops (and between-track stays) closer than this are the same place.
+const placeRadiusM = 300.0
+
+// Place is an anchorage or berth aggregated across the archive.
+type Place struct {
+	Lon       float64        `json:"lon"`
+	Lat       float64        `json:"lat"`
+	Visits    int            `json:"visits"`
+	Nights    int            `json:"nights"`
+	TotalS    int64          `json:"total_s"`
+	FirstAt   int64          `json:"first_at"`
+	LastAt    int64          `json:"last_at"`
+	Kinds     map[string]int `json:"kinds"`
+	TrackIDs  []int64        `json:"track_ids"`
+	weightSum float64
+}
+
+// refreshTrackStops re-derives track_stops for live tracks whose summary
+// has moved on since stops were last computed.
+func (s *Store) refreshTrackStops(ctx context.Context) error {
+	work, err := s.staleDerived(ctx, "stops_version")
+	if err != nil {
+		return err
+	}
+	for _, w := range work {
+		ts, err := s.ComputeTrackStats(ctx, w.id)
+		if errors.Is(err, sql.ErrNoRows) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+		if err := s.saveTrackStops(ctx, w.id, w.version, ts.Segments); err != nil {
+			return fmt.Errorf("track %d: %w", w.id, err)
+		}
+	}
+	return nil
+}
+
+func (s *Store) saveTrackStops(ctx context.Context, trackID, version int64, segs []Segment) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM track_stops WHERE track_id = ?`, trackID); err != nil {
+		return err
+	}
+	for _, sg := range segs {
+		if !sg.Stationary() {
+			continue
+		}
+		if _, err := tx.ExecContext(ctx, `
+			INSERT INTO track_stops (track_id, kind, started_at, ended_at, lon, lat, radius_m, points)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, trackID, sg.Kind, sg.StartedAt, sg.EndedAt, sg.Centroid[0], sg.Centroid[1], sg.RadiusM, sg.Points); err != nil {
+			return err
+		}
+	}
+	if err := markDerivedTx(ctx, tx, "stops_version", trackID, version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// Places aggregates anchored and moored stops, plus overnight gaps between
+// consecutive tracks that end and start in the same spot, into places with
+// visit counts and nights spent. vesselID limits it to one boat (0 = all).
+func (s *Store) Places(ctx context.Context, vesselID int64) ([]*Place, error) {
+	stays, err := s.stays(ctx, vesselID)
+	if err != nil {
+		return nil, err
+	}
+
+	var places []*Place
+	for _, st := range stays {
+		var best *Place
+		bestD := placeRadiusM
+		for _, p := range places {
+			if d := haversineCoords([2]float64{p.Lon, p.Lat}, [2]float64{st.Lon, st.Lat}); d <= bestD {
+				best, bestD = p, d
+			}
+		}
+		if best == nil {
+			best = &Place{FirstAt: st.StartedAt, Kinds: map[string]int{}}
+			places = append(places, best)
+		}
+
+		// Centroid weighted by time spent.
+		dur := float64(max(st.EndedAt-st.StartedAt, 1))
+		best.Lon = (best.Lon*best.weightSum + st.Lon*dur) / (best.weightSum + dur)
+		best.Lat = (best.Lat*best.weightSum + st.Lat*dur) / (best.weightSum + dur)
+		best.weightSum += dur
+
+		best.Visits++
+		best.Nights += NightsAt(st.StartedAt, st.EndedAt, st.Lon)
+		best.TotalS += st.EndedAt - st.StartedAt
+		best.LastAt = max(best.LastAt, st.EndedAt)
+		best.Kinds[st.Kind]++
+		if n := len(best.TrackIDs); n == 0 || best.TrackIDs[n-1] != st.TrackID {
+			best.TrackIDs = append(best.TrackIDs, st.TrackID)
+		}
+	}
+
+	sort.Slice(places, func(i, j int) bool {
+		if places[i].Nights != places[j].Nights {
+			return places[i].Nights > places[j].Nights
+		}
+		return places[i].Visits > places[j].Visits
+	})
+	return places, nil
+}
+
+// stays lists anchored and moored stops plus between-track stays, in time
+// order. vesselID limits it to one boat (0 = all).
+func (s *Store) stays(ctx context.Context, vesselID int64) ([]db.TrackStop, error) {
+	if err := s.refreshTrackStops(ctx); err != nil {
+		return nil, err
+	}
+
+	var stays []db.TrackStop
+	vf, vargs := vesselFilter("t.vessel_id", vesselID)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT st.id, st.track_id, st.kind, st.started_at, st.ended_at, st.lon, st.lat, st.radius_m, st.points
+		FROM track_stops st JOIN tracks t ON t.id = st.track_id
+		WHERE t.deleted_at IS NULL AND st.kind IN ('anchored', 'moored')`+vf, vargs...)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var st db.TrackStop
+		if err := rows.Scan(&st.ID, &st.TrackID, &st.Kind, &st.StartedAt, &st.EndedAt, &st.Lon, &st.Lat, &st.RadiusM, &st.Points); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stays = append(stays, st)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	between, err := s.betweenTrackStays(ctx, vesselID)
+	if err != nil {
+		return nil, err
+	}
+	stays = append(stays, between...)
+	sort.Slice(stays, func(i, j int) bool { return stays[i].StartedAt < stays[j].StartedAt })
+	return stays, nil
+}
+
+// betweenTrackStays finds gaps where one track ends and the same boat's
+// next track starts within placeRadiusM — usually a night at anchor with
+// the logger off.
+func (s *Store) betweenTrackStays(ctx context.Context, vesselID int64) ([]db.TrackStop, error) {
+	vf, vargs := vesselFilter("t.vessel_id", vesselID)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.id, COALESCE(t.vessel_id, 0), s.started_at, s.ended_at, s.last_lon, s.last_lat,
+		       (SELECT lon FROM positions WHERE track_id = t.id ORDER BY t_ms ASC LIMIT 1),
+		       (SELECT lat FROM positions WHERE track_id = t.id ORDER BY t_ms ASC LIMIT 1)
+		FROM tracks t JOIN track_summaries s ON s.track_id = t.id
+		WHERE t.deleted_at IS NULL AND s.points > 0`+vf+`
+		ORDER BY COALESCE(t.vessel_id, 0), s.started_at
+	`, vargs...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []db.TrackStop
+	var prev struct {
+		id, vesselID     int64
+		ended            int64
+		lastLon, lastLat float64
+		ok               bool
+	}
+	for rows.Next() {
+		var id, vesselID, started, ended int64
+		var lastLon, lastLat, firstLon, firstLat float64
+		if err := rows.Scan(&id, &vesselID, &started, &ended, &lastLon, &lastLat, &firstLon, &firstLat); err != nil {
+			return nil, err
+		}
+		if prev.ok && prev.vesselID == vesselID && started-prev.ended >= minStayS {
+			a := [2]float64{prev.lastLon, prev.lastLat}
+			b := [2]float64{firstLon, firstLat}
+			if d := haversineCoords(a, b); d <= placeRadiusM {
+				out = append(out, db.TrackStop{
+					TrackID:   prev.id,
+					Kind:      SegAnchored,
+					StartedAt: prev.ended,
+					EndedAt:   started,
+					Lon:       (a[0] + b[0]) / 2,
+					Lat:       (a[1] + b[1]) / 2,
+					RadiusM:   d / 2,
+					Points:    2,
+				})
+			}
+		}
+		prev.id, prev.vesselID, prev.ended, prev.lastLon, prev.lastLat, prev.ok = id, vesselID, ended, lastLon, lastLat, true
+	}
+	return out, rows.Err()
+}
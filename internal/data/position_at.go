@@ -0,0 +1,122 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"wakemap/internal/db"
+	"wakemap/internal/geo"
+)
+
+// PositionFix is where a track was at a given moment, interpolated between
+// the fixes either side. Before and After are equal for an exact hit.
+type PositionFix struct {
+	TrackID   int64
+	TrackName string
+	VesselID  int64 // 0 = none
+	T         int64 // epoch seconds
+	TMs       int64 // epoch milliseconds
+	Lon, Lat  float64
+	SogMs     sql.NullFloat64
+	CogRad    sql.NullFloat64
+	Before    db.Position
+	After     db.Position
+}
+
+// GapS is the time between the bracketing fixes, in seconds.
+func (p *PositionFix) GapS() float64 { return float64(p.After.TMs-p.Before.TMs) / 1000 }
+
+// GapM is the distance between the bracketing fixes.
+func (p *PositionFix) GapM() float64 {
+	return geo.HaversineM(p.Before.Lon, p.Before.Lat, p.After.Lon, p.After.Lat)
+}
+
+// PositionAt finds the live track covering atMs (epoch milliseconds) and
+// interpolates along the great circle between the fixes bracketing it.
+// vesselID limits it to one boat (0 = any). When tracks overlap the one
+// with the tightest bracket wins. Returns ErrNotFound if no track covers
+// atMs.
+func (s *Store) PositionAt(ctx context.Context, atMs, vesselID int64) (*PositionFix, error) {
+	vf, vargs := vesselFilter("t.vessel_id", vesselID)
+	ids, err := s.trackIDs(ctx, `
+		SELECT t.id
+		FROM tracks t JOIN track_summaries s ON s.track_id = t.id
+		WHERE t.deleted_at IS NULL AND s.started_at_ms <= ? AND s.ended_at_ms >= ?`+vf,
+		append([]any{atMs, atMs}, vargs...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	var best *PositionFix
+	for _, id := range ids {
+		before, err := s.fixNear(ctx, id, atMs, `t_ms <= ? ORDER BY t_ms DESC, id DESC`)
+		if err != nil {
+			return nil, err
+		}
+		after, err := s.fixNear(ctx, id, atMs, `t_ms >= ? ORDER BY t_ms ASC, id ASC`)
+		if err != nil {
+			return nil, err
+		}
+		if best == nil || after.TMs-before.TMs < best.After.TMs-best.Before.TMs {
+			best = &PositionFix{TrackID: id, T: floorDiv(atMs, 1000), TMs: atMs, Before: before, After: after}
+		}
+	}
+	if best == nil {
+		return nil, ErrNotFound
+	}
+
+	t, err := s.Track(ctx, best.TrackID)
+	if err != nil {
+		return nil, err
+	}
+	best.TrackName = t.Name
+	best.VesselID = t.VesselID.Int64
+
+	best.Lon, best.Lat, best.SogMs, best.CogRad = interpolateFix(best.Before, best.After, atMs)
+	return best, nil
+}
+
+// interpolateFix estimates position, SOG and COG at atMs between fixes a
+// and b: along the great circle, linearly for SOG and the short way round
+// for COG. A value missing on either side takes the nearer fix's value.
+func interpolateFix(a, b db.Position, atMs int64) (lon, lat float64, sog, cog sql.NullFloat64) {
+	f := 0.0
+	if b.TMs > a.TMs {
+		f = float64(atMs-a.TMs) / float64(b.TMs-a.TMs)
+	}
+	lon, lat = geo.Interpolate(a.Lon, a.Lat, b.Lon, b.Lat, f)
+	switch {
+	case a.SogMs.Valid && b.SogMs.Valid:
+		sog = sql.NullFloat64{Float64: a.SogMs.Float64 + f*(b.SogMs.Float64-a.SogMs.Float64), Valid: true}
+	case f < 0.5:
+		sog = a.SogMs
+	default:
+		sog = b.SogMs
+	}
+	switch {
+	case a.CogRad.Valid && b.CogRad.Valid:
+		cog = sql.NullFloat64{Float64: geo.InterpolateAngle(a.CogRad.Float64, b.CogRad.Float64, f), Valid: true}
+	case f < 0.5:
+		cog = a.CogRad
+	default:
+		cog = b.CogRad
+	}
+	return lon, lat, sog, cog
+}
+
+// fixNear loads the first fix of a track matching cond (which binds atMs).
+func (s *Store) fixNear(ctx context.Context, trackID, atMs int64, cond string) (db.Position, error) {
+	var p db.Position
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, track_id, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual
+		FROM positions
+		WHERE track_id = ? AND `+cond+`
+		LIMIT 1
+	`, trackID, atMs).Scan(&p.ID, &p.TrackID, &p.T, &p.TMs, &p.Lon, &p.Lat, &p.SogMs, &p.CogRad, &p.Src, &p.Qual)
+	if errors.Is(err, sql.ErrNoRows) {
+		// The summary says the track covers at, so it changed under us.
+		return p, ErrNotFound
+	}
+	return p, err
+}
@@ -0,0 +1,304 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"wakemap/internal/geo"
+)
+
+const (
+	// Inside a stop one fix is kept per this long (or per EveryS if
+	// longer), enough to show the stay without the swinging, and well
+	// inside the gap at which track lines break.
+	retainStopEveryS = 300
+	// Without RetentionPolicy.Vacuum the file is only rebuilt once this
+	// much of it is free pages; below that SQLite just reuses them.
+	vacuumFreeFrac = 0.25
+	// Deletes go in batches this big to stay under SQLite's variable limit.
+	retainDeleteBatch = 500
+)
+
+// RetentionPolicy says which tracks to thin and how far. Tracks that ended
+// within FullDays keep every fix. Older ones keep a fix at least every
+// EveryS seconds and EveryM metres travelled (0 turns either off), plus
+// any fix more than TurnM off the line between its neighbours, and the
+// edges of every stop.
+type RetentionPolicy struct {
+	FullDays int
+	EveryS   int64
+	EveryM   float64
+	TurnM    float64
+
+	DryRun bool // count what would go, change nothing
+	Vacuum bool // always rebuild the file afterwards
+}
+
+func (p RetentionPolicy) String() string {
+	return fmt.Sprintf("%ds/%gm/%gm", p.EveryS, p.EveryM, p.TurnM)
+}
+
+// RetentionReport is what one retention run did.
+type RetentionReport struct {
+	Tracks          int   // tracks thinned
+	Skipped         int   // tracks written to mid-run, left for the next run
+	PositionsBefore int64 // in those tracks
+	PositionsAfter  int64
+	RTreeRepaired   int64 // positions_rtree rows added or removed to match positions
+	BytesBefore     int64 // database plus WAL
+	BytesAfter      int64
+	Vacuumed        bool
+	Duration        time.Duration
+}
+
+// Reclaimed is how much smaller the database and WAL got.
+func (r *RetentionReport) Reclaimed() int64 { return r.BytesBefore - r.BytesAfter }
+
+// Retain thins old tracks by policy, then checkpoints the WAL and, when
+// enough space was freed (or p.Vacuum), rebuilds the file with VACUUM.
+// Each track is thinned in its own transaction, so a cancelled run leaves
+// every track either whole or done; one written to meanwhile is skipped
+// until the next run. Ingest pauses during VACUUM, which needs free disk
+// space of about twice the database; if it runs out, SQLite leaves the
+// file as it was.
+func (s *Store) Retain(ctx context.Context, p RetentionPolicy) (*RetentionReport, error) {
+	if p.EveryS <= 0 && p.EveryM <= 0 {
+		return nil, errors.New("retention: need a time or distance resolution")
+	}
+	start := time.Now()
+	rep := &RetentionReport{}
+	var err error
+	if rep.BytesBefore, err = s.fileBytes(ctx); err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -p.FullDays).Unix()
+	ids, err := s.trackIDs(ctx, `
+		SELECT id FROM tracks
+		WHERE deleted_at IS NULL AND ended_at IS NOT NULL AND ended_at < ?
+		  AND (downsample_policy IS NULL OR downsample_policy <> ?)
+		ORDER BY ended_at
+	`, cutoff, p.String())
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		before, after, err := s.downsampleTrack(ctx, id, p)
+		if errors.Is(err, errTrackChanged) {
+			rep.Skipped++
+			continue
+		}
+		if err != nil {
+			return rep, fmt.Errorf("track %d: %w", id, err)
+		}
+		if before == 0 {
+			continue
+		}
+		rep.Tracks++
+		rep.PositionsBefore += before
+		rep.PositionsAfter += after
+	}
+	if p.DryRun {
+		rep.BytesAfter = rep.BytesBefore
+		rep.Duration = time.Since(start)
+		return rep, nil
+	}
+
+	if rep.RTreeRepaired, err = s.repairPositionsRTree(ctx); err != nil {
+		return rep, err
+	}
+	if _, err := s.DB.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return rep, err
+	}
+	var pages, free int64
+	if err := s.DB.QueryRowContext(ctx, `SELECT page_count, freelist_count FROM pragma_page_count, pragma_freelist_count`).Scan(&pages, &free); err != nil {
+		return rep, err
+	}
+	if free > 0 && (p.Vacuum || float64(free) >= vacuumFreeFrac*float64(pages)) {
+		if err := s.vacuum(ctx); err != nil {
+			return rep, err
+		}
+		rep.Vacuumed = true
+	}
+	if rep.BytesAfter, err = s.fileBytes(ctx); err != nil {
+		return rep, err
+	}
+	rep.Duration = time.Since(start)
+	return rep, nil
+}
+
+// vacuum rebuilds the file with ingest paused: VACUUM holds the write lock
+// throughout, and queued batches would otherwise give up waiting for it.
+func (s *Store) vacuum(ctx context.Context) error {
+	s.ingestGate.Lock()
+	defer s.ingestGate.Unlock()
+	if _, err := s.DB.ExecContext(ctx, `VACUUM`); err != nil {
+		return fmt.Errorf("vacuum: %w", err)
+	}
+	// VACUUM writes the new file through the WAL.
+	_, err := s.DB.ExecContext(ctx, `PRAGMA wal_checkpoint(TRUNCATE)`)
+	return err
+}
+
+// errTrackChanged means fixes were written to a track while it was being
+// thinned; it is left for the next run.
+var errTrackChanged = errors.New("positions changed while thinning")
+
+// downsampleTrack thins one track, returning its fix count before and
+// after. A track that would lose nothing is only marked done.
+func (s *Store) downsampleTrack(ctx context.Context, trackID int64, p RetentionPolicy) (before, after int64, err error) {
+	ts, err := s.ComputeTrackStats(ctx, trackID)
+	if err != nil {
+		return 0, 0, err
+	}
+	keep := downsampleKeep(ts, p)
+
+	// The ids are read under the write lock, so they are the rows the
+	// deletes see; if the track grew since ts was computed, keep is stale.
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() { _ = tx.Rollback() }()
+	ids, err := trackIDsTx(ctx, tx, `SELECT id FROM positions WHERE track_id = ? ORDER BY t_ms ASC, id ASC`, trackID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(ids) != len(keep) {
+		return 0, 0, errTrackChanged
+	}
+	var drop []any
+	for i, k := range keep {
+		if !k {
+			drop = append(drop, ids[i])
+		}
+	}
+	before, after = int64(len(ids)), int64(len(ids)-len(drop))
+	if p.DryRun {
+		return before, after, nil
+	}
+
+	for len(drop) > 0 {
+		n := min(len(drop), retainDeleteBatch)
+		q := `DELETE FROM positions WHERE id IN (?` + strings.Repeat(",?", n-1) + `)`
+		if _, err := tx.ExecContext(ctx, q, drop[:n]...); err != nil {
+			return 0, 0, err
+		}
+		drop = drop[n:]
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE tracks SET downsampled_at = ?, downsample_policy = ? WHERE id = ?`,
+		time.Now().Unix(), p.String(), trackID); err != nil {
+		return 0, 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+	s.InvalidateTrack(trackID)
+	return before, after, nil
+}
+
+// downsampleKeep picks the fixes of ts to keep under p.
+func downsampleKeep(ts *TrackStats, p RetentionPolicy) []bool {
+	n := len(ts.Coords)
+	keep := make([]bool, n)
+	if n == 0 {
+		return keep
+	}
+	keep[0], keep[n-1] = true, true
+
+	// Stops: both edges, and a fix now and then while there.
+	inStop := make([]bool, n)
+	stopEvery := max(p.EveryS, retainStopEveryS)
+	for _, sg := range ts.Segments {
+		if !sg.Stationary() {
+			continue
+		}
+		keep[sg.start], keep[sg.end] = true, true
+		last := ts.Times[sg.start]
+		for k := sg.start + 1; k < sg.end; k++ {
+			inStop[k] = true
+			if ts.Times[k]-last >= stopEvery {
+				keep[k], last = true, ts.Times[k]
+			}
+		}
+	}
+	for i := 1; i < n; i++ {
+		if stoppedAt(ts.SOGms[i-1]) != stoppedAt(ts.SOGms[i]) {
+			keep[i-1], keep[i] = true, true
+		}
+	}
+
+	// Underway: the time and distance resolution.
+	last := 0
+	for i := 1; i < n; i++ {
+		if inStop[i] {
+			continue
+		}
+		if keep[i] ||
+			(p.EveryS > 0 && ts.Times[i]-ts.Times[last] >= p.EveryS) ||
+			(p.EveryM > 0 && haversineCoords(ts.Coords[last], ts.Coords[i]) >= p.EveryM) {
+			keep[i], last = true, i
+		}
+	}
+
+	// Turns: whatever Douglas-Peucker needs to hold the shape between
+	// those, outside stops where it would only chase the swinging.
+	if p.TurnM > 0 {
+		for _, i := range geo.Simplify(ts.Coords, p.TurnM, keep) {
+			if !inStop[i] {
+				keep[i] = true
+			}
+		}
+	}
+	return keep
+}
+
+// repairPositionsRTree makes positions_rtree match positions again, for
+// rows written or deleted with the triggers off, and returns how many
+// entries it fixed.
+func (s *Store) repairPositionsRTree(ctx context.Context) (int64, error) {
+	var n int64
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM positions_rtree WHERE id NOT IN (SELECT id FROM positions)`)
+	if err != nil {
+		return 0, err
+	}
+	k, _ := res.RowsAffected()
+	n += k
+	res, err = s.DB.ExecContext(ctx, `
+		INSERT INTO positions_rtree (id, minX, maxX, minY, maxY)
+		SELECT id, lon, lon, lat, lat FROM positions WHERE id NOT IN (SELECT id FROM positions_rtree)
+	`)
+	if err != nil {
+		return n, err
+	}
+	k, _ = res.RowsAffected()
+	return n + k, nil
+}
+
+// fileBytes is the size of the main database file and its WAL.
+func (s *Store) fileBytes(ctx context.Context) (int64, error) {
+	var seq int
+	var name, path string
+	if err := s.DB.QueryRowContext(ctx, `SELECT seq, name, file FROM pragma_database_list WHERE name = 'main'`).Scan(&seq, &name, &path); err != nil {
+		return 0, err
+	}
+	if path == "" {
+		return 0, nil // in-memory
+	}
+	var total int64
+	for _, f := range []string{path, path + "-wal"} {
+		fi, err := os.Stat(f)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		total += fi.Size()
+	}
+	return total, nil
+}
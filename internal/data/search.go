@@ -0,0 +1,178 @@
+package data
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"wakemap/internal/geo"
+)
+
+// AreaQuery is a bounding box, or a circle when RadiusM is set.
+type AreaQuery struct {
+	BBox     [4]float64 // minLon, minLat, maxLon, maxLat
+	Center   [2]float64 // lon, lat
+	RadiusM  float64
+	Limit    int
+	VesselID int64 // 0 = any vessel
+
+	// Exclude, if set, treats fixes it reports true for as outside the
+	// area, so private spots can't be found by searching around them.
+	Exclude func(lon, lat float64) bool
+}
+
+// NearArea builds a circular AreaQuery with a bounding box wide enough
+// for the R*Tree prefilter.
+func NearArea(lon, lat, radiusM float64) AreaQuery {
+	dLat := radiusM / (geo.EarthRadiusM * math.Pi / 180)
+	dLon := dLat / math.Max(math.Cos(lat*math.Pi/180), 1e-6)
+	return AreaQuery{
+		BBox:    [4]float64{lon - dLon, lat - dLat, lon + dLon, lat + dLat},
+		Center:  [2]float64{lon, lat},
+		RadiusM: radiusM,
+	}
+}
+
+// BBoxArea builds an AreaQuery for a bounding box; distances are measured
+// from its centre.
+func BBoxArea(bb [4]float64) AreaQuery {
+	return AreaQuery{BBox: bb, Center: [2]float64{(bb[0] + bb[2]) / 2, (bb[1] + bb[3]) / 2}}
+}
+
+func (q AreaQuery) contains(lon, lat float64) bool {
+	if lon < q.BBox[0] || lon > q.BBox[2] || lat < q.BBox[1] || lat > q.BBox[3] {
+		return false
+	}
+	if q.RadiusM > 0 && geo.HaversineM(q.Center[0], q.Center[1], lon, lat) > q.RadiusM {
+		return false
+	}
+	return q.Exclude == nil || !q.Exclude(lon, lat)
+}
+
+// Pass is one continuous spell inside the area.
+type Pass struct {
+	EnteredAt int64 `json:"entered_at"`
+	ExitedAt  int64 `json:"exited_at"`
+	Points    int   `json:"points"`
+}
+
+// TrackHit is a track that had at least one fix inside a search area.
+type TrackHit struct {
+	TrackID    int64
+	Name       string
+	EnteredAt  int64 // first fix inside
+	ExitedAt   int64 // last fix inside
+	Points     int
+	Passes     []Pass
+	ClosestT   int64
+	ClosestLon float64
+	ClosestLat float64
+	ClosestM   float64 // from the area centre
+}
+
+// SearchTracks finds live tracks with fixes inside the area, most recent
+// visit first. Candidate fixes come from positions_rtree; each hit track
+// is then walked over its in-area time window to split it into passes.
+func (s *Store) SearchTracks(ctx context.Context, q AreaQuery) ([]*TrackHit, error) {
+	if q.Limit <= 0 {
+		q.Limit = DefaultTrackPage
+	}
+	q.Limit = min(q.Limit, MaxTrackPage)
+
+	vf, vargs := vesselFilter("t.vessel_id", q.VesselID)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.track_id, t.name, p.t, p.lon, p.lat
+		FROM positions_rtree r
+		JOIN positions p ON p.id = r.id
+		JOIN tracks t ON t.id = p.track_id
+		WHERE r.minX >= ? AND r.maxX <= ? AND r.minY >= ? AND r.maxY <= ?
+		  AND t.deleted_at IS NULL`+vf,
+		append([]any{q.BBox[0], q.BBox[2], q.BBox[1], q.BBox[3]}, vargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	hits := map[int64]*TrackHit{}
+	for rows.Next() {
+		var id, t int64
+		var name string
+		var lon, lat float64
+		if err := rows.Scan(&id, &name, &t, &lon, &lat); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if !q.contains(lon, lat) {
+			continue
+		}
+		d := geo.HaversineM(q.Center[0], q.Center[1], lon, lat)
+		h := hits[id]
+		if h == nil {
+			h = &TrackHit{TrackID: id, Name: name, EnteredAt: t, ExitedAt: t, ClosestM: math.Inf(1)}
+			hits[id] = h
+		}
+		h.EnteredAt = min(h.EnteredAt, t)
+		h.ExitedAt = max(h.ExitedAt, t)
+		h.Points++
+		if d < h.ClosestM {
+			h.ClosestM, h.ClosestT, h.ClosestLon, h.ClosestLat = d, t, lon, lat
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	out := make([]*TrackHit, 0, len(hits))
+	for _, h := range hits {
+		out = append(out, h)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].ExitedAt != out[j].ExitedAt {
+			return out[i].ExitedAt > out[j].ExitedAt
+		}
+		return out[i].TrackID > out[j].TrackID
+	})
+	if len(out) > q.Limit {
+		out = out[:q.Limit]
+	}
+
+	for _, h := range out {
+		if h.Passes, err = s.passes(ctx, h, q); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// passes walks a hit track between its first and last in-area fix and
+// splits it wherever it left the area.
+func (s *Store) passes(ctx context.Context, h *TrackHit, q AreaQuery) ([]Pass, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t, lon, lat FROM positions
+		WHERE track_id = ? AND t_ms >= ? AND t_ms < ?
+		ORDER BY t_ms ASC, id ASC
+	`, h.TrackID, h.EnteredAt*1000, (h.ExitedAt+1)*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Pass
+	var cur *Pass
+	for rows.Next() {
+		var t int64
+		var lon, lat float64
+		if err := rows.Scan(&t, &lon, &lat); err != nil {
+			return nil, err
+		}
+		if !q.contains(lon, lat) {
+			cur = nil
+			continue
+		}
+		if cur == nil {
+			out = append(out, Pass{EnteredAt: t})
+			cur = &out[len(out)-1]
+		}
+		cur.ExitedAt = t
+		cur.Points++
+	}
+	return out, rows.Err()
+}
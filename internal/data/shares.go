@@ -0,0 +1,210 @@
+package data
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+// ErrShareGone is returned for a genuine share link that was revoked or
+// has expired.
+var ErrShareGone = errors.New("share revoked or expired")
+
+// Share is a public link and the tracks it shows.
+type Share struct {
+	db.Share
+	TrackIDs []int64
+}
+
+// Live reports whether the share still works at now.
+func (sh *Share) Live(now int64) bool {
+	return !sh.RevokedAt.Valid && (!sh.ExpiresAt.Valid || sh.ExpiresAt.Int64 > now)
+}
+
+const shareCols = `id, label, created_at, expires_at, revoked_at, delay_s, hide`
+
+func scanShare(sc interface{ Scan(...any) error }) (Share, error) {
+	var sh Share
+	err := sc.Scan(&sh.ID, &sh.Label, &sh.CreatedAt, &sh.ExpiresAt, &sh.RevokedAt, &sh.DelayS, &sh.Hide)
+	return sh, err
+}
+
+// CreateShare stores a share of the given live tracks.
+func (s *Store) CreateShare(ctx context.Context, sh db.Share, trackIDs []int64) (Share, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return Share{}, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	sh.CreatedAt = time.Now().Unix()
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO shares (label, created_at, expires_at, delay_s, hide) VALUES (?, ?, ?, ?, ?)
+	`, sh.Label, sh.CreatedAt, sh.ExpiresAt, sh.DelayS, sh.Hide)
+	if err != nil {
+		return Share{}, err
+	}
+	if sh.ID, err = res.LastInsertId(); err != nil {
+		return Share{}, err
+	}
+	out := Share{Share: sh}
+	for _, id := range trackIDs {
+		var one int
+		err := tx.QueryRowContext(ctx, `SELECT 1 FROM tracks WHERE id = ? AND deleted_at IS NULL`, id).Scan(&one)
+		if errors.Is(err, sql.ErrNoRows) {
+			return Share{}, fmt.Errorf("track %d: %w", id, ErrNotFound)
+		}
+		if err != nil {
+			return Share{}, err
+		}
+		if _, err := tx.ExecContext(ctx, `INSERT OR IGNORE INTO share_tracks (share_id, track_id) VALUES (?, ?)`, sh.ID, id); err != nil {
+			return Share{}, err
+		}
+		out.TrackIDs = append(out.TrackIDs, id)
+	}
+	return out, tx.Commit()
+}
+
+// Shares lists every share, newest first, revoked and expired included.
+func (s *Store) Shares(ctx context.Context) ([]Share, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+shareCols+` FROM shares ORDER BY id DESC`)
+	if err != nil {
+		return nil, err
+	}
+	var out []Share
+	for rows.Next() {
+		sh, err := scanShare(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		out = append(out, sh)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	for i := range out {
+		if out[i].TrackIDs, err = s.shareTrackIDs(ctx, out[i].ID); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Share loads one share.
+func (s *Store) Share(ctx context.Context, id int64) (Share, error) {
+	sh, err := scanShare(s.DB.QueryRowContext(ctx, `SELECT `+shareCols+` FROM shares WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return sh, ErrNotFound
+	}
+	if err != nil {
+		return sh, err
+	}
+	sh.TrackIDs, err = s.shareTrackIDs(ctx, id)
+	return sh, err
+}
+
+// shareTrackIDs lists a share's tracks that still exist.
+func (s *Store) shareTrackIDs(ctx context.Context, shareID int64) ([]int64, error) {
+	return s.trackIDs(ctx, `
+		SELECT st.track_id FROM share_tracks st JOIN tracks t ON t.id = st.track_id
+		WHERE st.share_id = ? AND t.deleted_at IS NULL
+		ORDER BY t.started_at, t.id
+	`, shareID)
+}
+
+// RevokeShare turns a share's link off for good.
+func (s *Store) RevokeShare(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, `UPDATE shares SET revoked_at = COALESCE(revoked_at, ?) WHERE id = ?`, time.Now().Unix(), id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ShareToken is the link token for a share: "<id>.<expires>.<signature>",
+// expires being epoch seconds or 0 for never.
+func (s *Store) ShareToken(ctx context.Context, sh db.Share) (string, error) {
+	key, err := s.shareKey(ctx)
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d.%d", sh.ID, sh.ExpiresAt.Int64)
+	return payload + "." + shareSig(key, payload), nil
+}
+
+// ShareByToken checks a link token and loads its share. A forged or
+// mangled token is ErrNotFound; a revoked or expired one ErrShareGone.
+func (s *Store) ShareByToken(ctx context.Context, token string) (Share, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return Share{}, ErrNotFound
+	}
+	key, err := s.shareKey(ctx)
+	if err != nil {
+		return Share{}, err
+	}
+	payload := parts[0] + "." + parts[1]
+	if !hmac.Equal([]byte(parts[2]), []byte(shareSig(key, payload))) {
+		return Share{}, ErrNotFound
+	}
+	id, err1 := strconv.ParseInt(parts[0], 10, 64)
+	exp, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return Share{}, ErrNotFound
+	}
+	now := time.Now().Unix()
+	if exp != 0 && exp <= now {
+		return Share{}, ErrShareGone
+	}
+	sh, err := s.Share(ctx, id)
+	if err != nil {
+		return sh, err
+	}
+	if !sh.Live(now) || sh.ExpiresAt.Int64 != exp {
+		return sh, ErrShareGone
+	}
+	return sh, nil
+}
+
+func shareSig(key []byte, payload string) string {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(m.Sum(nil)[:18])
+}
+
+// shareKey is the server's link-signing key, made on first use. Deleting
+// the settings row invalidates every link ever handed out.
+func (s *Store) shareKey(ctx context.Context) ([]byte, error) {
+	var key []byte
+	err := s.DB.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = 'share_key'`).Scan(&key)
+	if err == nil {
+		return key, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return nil, err
+	}
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	// Two first uses may race; whichever key landed first wins.
+	if _, err := s.DB.ExecContext(ctx, `INSERT OR IGNORE INTO settings (key, value) VALUES ('share_key', ?)`, key); err != nil {
+		return nil, err
+	}
+	err = s.DB.QueryRowContext(ctx, `SELECT value FROM settings WHERE key = 'share_key'`).Scan(&key)
+	return key, err
+}
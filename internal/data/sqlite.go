@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	_ "github.com/mattn/go-sqlite3"
+	"sync"
 	"time"
 	"wakemap/internal/db" // your sqlc models.go package
 )
@@ -12,22 +13,41 @@ import (
 type Store struct {
 	DB *sql.DB
 	Q  *db.Queries
+
+	simplified *simplifyCache
+	backupMu   sync.Mutex   // one snapshot at a time
+	ingestGate sync.RWMutex // held shared by ingest batches, exclusively by VACUUM
 }
 
+// Open opens the database at path and applies any pending migrations. It
+// refuses one migrated by a newer wakemap (ErrSchemaTooNew) or with an
+// applied migration that has since changed (ErrMigrationModified).
 func Open(path string) (*Store, error) {
-	d, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_fk=1")
+	d, err := OpenDB(path)
 	if err != nil {
 		return nil, err
 	}
-	if err := d.Ping(); err != nil {
+	if _, err := MigrateUp(context.Background(), d); err != nil {
 		_ = d.Close()
 		return nil, err
 	}
-	if err := ensureSchema(d); err != nil {
+	return &Store{DB: d, Q: db.New(d), simplified: newSimplifyCache()}, nil
+}
+
+// OpenDB opens the database at path without touching its schema, for
+// `wakemap migrate`. Transactions begin IMMEDIATE: every one here writes,
+// and a deferred one that has already read gets "database is locked" at
+// its first write instead of waiting out the busy timeout.
+func OpenDB(path string) (*sql.DB, error) {
+	d, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL&_fk=1&_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+	if err := d.Ping(); err != nil {
 		_ = d.Close()
 		return nil, err
 	}
-	return &Store{DB: d, Q: db.New(d)}, nil
+	return d, nil
 }
 
 func (s *Store) Close() error { return s.DB.Close() }
@@ -40,6 +60,20 @@ func (s *Store) ListTracks(ctx context.Context, limit int) ([]db.Track, error) {
 	return s.Q.ListTracks(ctx, int64(limit))
 }
 
+// Track loads a live track row.
+func (s *Store) Track(ctx context.Context, id int64) (db.Track, error) {
+	var t db.Track
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT id, name, started_at, ended_at, distance_m, notes, deleted_at, name_auto, vessel_id
+		FROM tracks
+		WHERE id = ? AND deleted_at IS NULL
+	`, id).Scan(&t.ID, &t.Name, &t.StartedAt, &t.EndedAt, &t.DistanceM, &t.Notes, &t.DeletedAt, &t.NameAuto, &t.VesselID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, ErrNotFound
+	}
+	return t, err
+}
+
 func (s *Store) TrackBBox(ctx context.Context, trackID int64) (db.TrackBBoxRow, error) {
 	return s.Q.TrackBBox(ctx, trackID)
 }
@@ -56,4 +90,22 @@ func UnixToTime(ts int64) time.Time {
 	return time.Unix(ts, 0).UTC()
 }
 
+// UnixMilliToTime is UnixToTime for epoch milliseconds.
+func UnixMilliToTime(ms int64) time.Time {
+	if ms <= 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
+
+// FixTimes fills whichever of p.T and p.TMs is unset from the other. When
+// both are set TMs wins and T is made to agree with it.
+func FixTimes(p *db.Position) {
+	if p.TMs == 0 {
+		p.TMs = p.T * 1000
+		return
+	}
+	p.T = floorDiv(p.TMs, 1000)
+}
+
 var ErrNotFound = errors.New("not found")
@@ -0,0 +1,163 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+
+	"wakemap/internal/geo"
+)
+
+// TileLine is one track's geometry for a map tile, simplified for the
+// tile's zoom.
+type TileLine struct {
+	TrackID   int64
+	Name      string
+	StartedAt int64
+	EndedAt   int64
+	DistanceM float64
+	VesselID  int64 // 0 = none
+	Coords    [][2]float64
+}
+
+type tileCandidate struct {
+	id, version    int64
+	vesselID       int64
+	name           string
+	started, ended int64
+	distanceM      float64
+}
+
+// tileCandidates lists live tracks whose summary bbox overlaps bb,
+// optionally only one vessel's.
+func (s *Store) tileCandidates(ctx context.Context, bb [4]float64, vesselID int64) ([]tileCandidate, error) {
+	vf, vargs := vesselFilter("t.vessel_id", vesselID)
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.id, s.version, COALESCE(t.vessel_id, 0), t.name,
+		       COALESCE(s.started_at, t.started_at), COALESCE(s.ended_at, t.started_at), s.distance_m
+		FROM tracks t JOIN track_summaries s ON s.track_id = t.id
+		WHERE t.deleted_at IS NULL AND s.points > 0
+		  AND s.max_x >= ? AND s.min_x <= ? AND s.max_y >= ? AND s.min_y <= ?`+vf+`
+		ORDER BY t.id
+	`, append([]any{bb[0], bb[2], bb[1], bb[3]}, vargs...)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []tileCandidate
+	for rows.Next() {
+		var c tileCandidate
+		if err := rows.Scan(&c.id, &c.version, &c.vesselID, &c.name, &c.started, &c.ended, &c.distanceM); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// TileFingerprint identifies the data behind a tile: which tracks touch it
+// and their summary versions, names and vessels. It changes whenever any of
+// those tracks is edited, deleted, appended to or reassigned, so it makes a
+// cache key.
+func (s *Store) TileFingerprint(ctx context.Context, bb [4]float64, vesselID int64) (string, error) {
+	cands, err := s.tileCandidates(ctx, bb, vesselID)
+	if err != nil {
+		return "", err
+	}
+	h := fnv.New64a()
+	for _, c := range cands {
+		fmt.Fprintf(h, "%d:%d:%d:%s\n", c.id, c.version, c.vesselID, c.name)
+	}
+	return fmt.Sprintf("%016x", h.Sum64()), nil
+}
+
+// TileTracks returns each live track crossing bb, simplified at tolM.
+// positions_rtree finds the time window each track spends inside bb; only
+// that window (plus one fix either side, so clipped lines reach the edge)
+// is loaded. Tracks whose bbox overlaps but have no fix inside may still
+// pass straight through, so those are loaded whole. vesselID 0 means all.
+func (s *Store) TileTracks(ctx context.Context, bb [4]float64, tolM float64, vesselID int64) ([]TileLine, error) {
+	cands, err := s.tileCandidates(ctx, bb, vesselID)
+	if err != nil || len(cands) == 0 {
+		return nil, err
+	}
+
+	type window struct{ from, to int64 }
+	inside := map[int64]window{}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.track_id, MIN(p.t), MAX(p.t)
+		FROM positions_rtree r JOIN positions p ON p.id = r.id
+		WHERE r.minX >= ? AND r.maxX <= ? AND r.minY >= ? AND r.maxY <= ?
+		GROUP BY p.track_id
+	`, bb[0], bb[2], bb[1], bb[3])
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var w window
+		if err := rows.Scan(&id, &w.from, &w.to); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		inside[id] = w
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	out := make([]TileLine, 0, len(cands))
+	for _, c := range cands {
+		w, ok := inside[c.id]
+		if !ok {
+			w = window{c.started, c.ended}
+		}
+		coords, err := s.tileCoords(ctx, c.id, w.from, w.to)
+		if err != nil {
+			return nil, err
+		}
+		if len(coords) < 2 {
+			continue
+		}
+		idx := geo.Simplify(coords, tolM, nil)
+		simple := make([][2]float64, len(idx))
+		for j, i := range idx {
+			simple[j] = coords[i]
+		}
+		out = append(out, TileLine{
+			TrackID:   c.id,
+			Name:      c.name,
+			StartedAt: c.started,
+			EndedAt:   c.ended,
+			DistanceM: c.distanceM,
+			VesselID:  c.vesselID,
+			Coords:    simple,
+		})
+	}
+	return out, nil
+}
+
+// tileCoords loads a track's fixes in [from, to] (epoch seconds) plus the
+// neighbours just outside it.
+func (s *Store) tileCoords(ctx context.Context, id, from, to int64) ([][2]float64, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT lon, lat FROM positions
+		WHERE track_id = ?1
+		  AND t_ms >= COALESCE((SELECT MAX(t_ms) FROM positions WHERE track_id = ?1 AND t_ms < ?2), ?2)
+		  AND t_ms <= COALESCE((SELECT MIN(t_ms) FROM positions WHERE track_id = ?1 AND t_ms >= ?3), ?3 - 1)
+		ORDER BY t_ms ASC, id ASC
+	`, id, from*1000, (to+1)*1000)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out [][2]float64
+	for rows.Next() {
+		var c [2]float64
+		if err := rows.Scan(&c[0], &c[1]); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,317 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"wakemap/internal/db"
+	"wakemap/internal/geo"
+)
+
+// summaryBuilder folds positions, in time order, into a track summary.
+type summaryBuilder struct {
+	s       db.TrackSummary
+	ordered bool // false once a fix arrives earlier than the last one
+}
+
+func newSummaryBuilder(trackID int64) *summaryBuilder {
+	return &summaryBuilder{s: db.TrackSummary{TrackID: trackID}, ordered: true}
+}
+
+// resumeSummaryBuilder continues from a stored, non-stale summary.
+func resumeSummaryBuilder(s db.TrackSummary) *summaryBuilder {
+	if !s.EndedAtMs.Valid && s.EndedAt.Valid {
+		s.EndedAtMs = sql.NullInt64{Int64: s.EndedAt.Int64 * 1000, Valid: true}
+	}
+	return &summaryBuilder{s: s, ordered: true}
+}
+
+// add folds in a fix at tMs, epoch milliseconds.
+func (b *summaryBuilder) add(tMs int64, lon, lat float64) {
+	s := &b.s
+	t := floorDiv(tMs, 1000)
+	if s.Points == 0 {
+		s.StartedAt = sql.NullInt64{Int64: t, Valid: true}
+		s.StartedAtMs = sql.NullInt64{Int64: tMs, Valid: true}
+		s.MinX = sql.NullFloat64{Float64: lon, Valid: true}
+		s.MaxX = s.MinX
+		s.MinY = sql.NullFloat64{Float64: lat, Valid: true}
+		s.MaxY = s.MinY
+	} else {
+		if tMs < s.EndedAtMs.Int64 {
+			b.ordered = false
+		}
+		s.DistanceM += geo.HaversineM(s.LastLon.Float64, s.LastLat.Float64, lon, lat)
+		s.MinX.Float64 = min(s.MinX.Float64, lon)
+		s.MaxX.Float64 = max(s.MaxX.Float64, lon)
+		s.MinY.Float64 = min(s.MinY.Float64, lat)
+		s.MaxY.Float64 = max(s.MaxY.Float64, lat)
+	}
+	s.Points++
+	s.EndedAt = sql.NullInt64{Int64: t, Valid: true}
+	s.EndedAtMs = sql.NullInt64{Int64: tMs, Valid: true}
+	s.LastLon = sql.NullFloat64{Float64: lon, Valid: true}
+	s.LastLat = sql.NullFloat64{Float64: lat, Valid: true}
+}
+
+// saveTx upserts the summary (clearing stale) and mirrors start, end and
+// distance onto the tracks row.
+func (b *summaryBuilder) saveTx(ctx context.Context, tx *sql.Tx) error {
+	s := &b.s
+	s.Stale = 0
+	s.UpdatedAt = time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, `
+		INSERT INTO track_summaries (track_id, points, distance_m, started_at, ended_at, started_at_ms, ended_at_ms,
+		                             min_x, min_y, max_x, max_y, last_lon, last_lat, stale, updated_at, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, 0, ?, 1)
+		ON CONFLICT(track_id) DO UPDATE SET
+		  points = excluded.points, distance_m = excluded.distance_m,
+		  started_at = excluded.started_at, ended_at = excluded.ended_at,
+		  started_at_ms = excluded.started_at_ms, ended_at_ms = excluded.ended_at_ms,
+		  min_x = excluded.min_x, min_y = excluded.min_y, max_x = excluded.max_x, max_y = excluded.max_y,
+		  last_lon = excluded.last_lon, last_lat = excluded.last_lat,
+		  stale = 0, updated_at = excluded.updated_at, version = track_summaries.version + 1
+	`, s.TrackID, s.Points, s.DistanceM, s.StartedAt, s.EndedAt, s.StartedAtMs, s.EndedAtMs,
+		s.MinX, s.MinY, s.MaxX, s.MaxY, s.LastLon, s.LastLat, s.UpdatedAt); err != nil {
+		return err
+	}
+	if s.Points == 0 {
+		_, err := tx.ExecContext(ctx, `UPDATE tracks SET distance_m = 0 WHERE id = ?`, s.TrackID)
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `UPDATE tracks SET started_at = ?, ended_at = ?, started_at_ms = ?, ended_at_ms = ?, distance_m = ? WHERE id = ?`,
+		s.StartedAt.Int64, s.EndedAt.Int64, s.StartedAtMs.Int64, s.EndedAtMs.Int64, s.DistanceM, s.TrackID)
+	return err
+}
+
+// rebuildSummaryTx recomputes a track's summary from all its positions in
+// the caller's transaction, so derived fields never disagree with the data.
+func rebuildSummaryTx(ctx context.Context, tx *sql.Tx, id int64) error {
+	rows, err := tx.QueryContext(ctx, `SELECT t_ms, lon, lat FROM positions WHERE track_id = ? ORDER BY t_ms ASC`, id)
+	if err != nil {
+		return err
+	}
+	b := newSummaryBuilder(id)
+	for rows.Next() {
+		var t int64
+		var lon, lat float64
+		if err := rows.Scan(&t, &lon, &lat); err != nil {
+			rows.Close()
+			return err
+		}
+		b.add(t, lon, lat)
+	}
+	if err := rows.Close(); err != nil {
+		return err
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	return b.saveTx(ctx, tx)
+}
+
+func loadSummary(ctx context.Context, q db.DBTX, id int64) (db.TrackSummary, error) {
+	var s db.TrackSummary
+	err := q.QueryRowContext(ctx, `
+		SELECT track_id, points, distance_m, started_at, ended_at,
+		       min_x, min_y, max_x, max_y, last_lon, last_lat, stale, updated_at,
+		       version, stops_version, heat_version, log_version,
+		       place_version, from_place, to_place, started_at_ms, ended_at_ms,
+		       from_lon, from_lat, to_lon, to_lat
+		FROM track_summaries
+		WHERE track_id = ?
+	`, id).Scan(&s.TrackID, &s.Points, &s.DistanceM, &s.StartedAt, &s.EndedAt,
+		&s.MinX, &s.MinY, &s.MaxX, &s.MaxY, &s.LastLon, &s.LastLat, &s.Stale, &s.UpdatedAt,
+		&s.Version, &s.StopsVersion, &s.HeatVersion, &s.LogVersion,
+		&s.PlaceVersion, &s.FromPlace, &s.ToPlace, &s.StartedAtMs, &s.EndedAtMs,
+		&s.FromLon, &s.FromLat, &s.ToLon, &s.ToLat)
+	return s, err
+}
+
+// AppendPositions inserts positions into a track and folds them into its
+// summary in one transaction. Fixes that extend the track in time are
+// applied incrementally; anything else (backfill, a stale or missing
+// summary) triggers a full rebuild.
+func (s *Store) AppendPositions(ctx context.Context, trackID int64, ps []db.Position) error {
+	if len(ps) == 0 {
+		return nil
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if err := appendPositionsTx(ctx, tx, trackID, ps); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	s.InvalidateTrack(trackID)
+	return nil
+}
+
+func appendPositionsTx(ctx context.Context, tx *sql.Tx, trackID int64, ps []db.Position) error {
+	stmt, err := tx.PrepareContext(ctx, insertPositionSQL)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	return appendPositionsStmt(ctx, tx, stmt, trackID, ps)
+}
+
+// appendPositionsStmt is appendPositionsTx with the insert already
+// prepared, for the ingest writer, which reuses one across batches.
+func appendPositionsStmt(ctx context.Context, tx *sql.Tx, stmt *sql.Stmt, trackID int64, ps []db.Position) error {
+	var b *summaryBuilder
+	sum, err := loadSummary(ctx, tx, trackID)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+	case err != nil:
+		return err
+	case sum.Stale == 0:
+		b = resumeSummaryBuilder(sum)
+	}
+
+	for _, p := range ps {
+		FixTimes(&p)
+		if _, err := stmt.ExecContext(ctx, trackID, p.T, p.TMs, p.Lon, p.Lat, p.SogMs, p.CogRad, p.Src, p.Qual); err != nil {
+			return err
+		}
+		if b != nil {
+			b.add(p.TMs, p.Lon, p.Lat)
+		}
+	}
+
+	if b == nil || !b.ordered {
+		return rebuildSummaryTx(ctx, tx, trackID)
+	}
+	return b.saveTx(ctx, tx)
+}
+
+// TrackSummary returns a track's precomputed aggregates, rebuilding them
+// first if they are missing or stale.
+func (s *Store) TrackSummary(ctx context.Context, id int64) (db.TrackSummary, error) {
+	sum, err := loadSummary(ctx, s.DB, id)
+	if err == nil && sum.Stale == 0 {
+		return sum, nil
+	}
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return sum, err
+	}
+	if ok, err := s.TrackExists(ctx, id); err != nil || !ok {
+		if err == nil {
+			err = ErrNotFound
+		}
+		return sum, err
+	}
+	if err := s.rebuildSummaries(ctx, []int64{id}); err != nil {
+		return sum, err
+	}
+	return loadSummary(ctx, s.DB, id)
+}
+
+// RefreshStaleSummaries rebuilds summaries that are missing or were marked
+// stale by writes outside the app. It is cheap when nothing is stale.
+func (s *Store) RefreshStaleSummaries(ctx context.Context) (int, error) {
+	ids, err := s.trackIDs(ctx, `
+		SELECT t.id
+		FROM tracks t LEFT JOIN track_summaries s ON s.track_id = t.id
+		WHERE t.deleted_at IS NULL AND (s.track_id IS NULL OR s.stale = 1)
+	`)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), s.rebuildSummaries(ctx, ids)
+}
+
+// RebuildAllSummaries recomputes every track's summary from scratch.
+func (s *Store) RebuildAllSummaries(ctx context.Context) (int, error) {
+	ids, err := s.trackIDs(ctx, `SELECT id FROM tracks ORDER BY id`)
+	if err != nil {
+		return 0, err
+	}
+	return len(ids), s.rebuildSummaries(ctx, ids)
+}
+
+// derivedTodo is a live track whose derived rows are older than its summary.
+type derivedTodo struct{ id, version int64 }
+
+// staleDerived lists live tracks whose versionCol (stops_version,
+// heat_version, ...) lags the summary version, after bringing summaries
+// themselves up to date.
+func (s *Store) staleDerived(ctx context.Context, versionCol string) ([]derivedTodo, error) {
+	if _, err := s.RefreshStaleSummaries(ctx); err != nil {
+		return nil, err
+	}
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT s.track_id, s.version
+		FROM track_summaries s JOIN tracks t ON t.id = s.track_id
+		WHERE t.deleted_at IS NULL AND (s.%[1]s IS NULL OR s.%[1]s <> s.version)
+	`, versionCol))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var work []derivedTodo
+	for rows.Next() {
+		var w derivedTodo
+		if err := rows.Scan(&w.id, &w.version); err != nil {
+			return nil, err
+		}
+		work = append(work, w)
+	}
+	return work, rows.Err()
+}
+
+// markDerivedTx records that versionCol is current as of version, unless
+// the track was written to meanwhile.
+func markDerivedTx(ctx context.Context, tx *sql.Tx, versionCol string, trackID, version int64) error {
+	_, err := tx.ExecContext(ctx, fmt.Sprintf(`UPDATE track_summaries SET %s = ?1 WHERE track_id = ?2 AND version = ?1`, versionCol),
+		version, trackID)
+	return err
+}
+
+func (s *Store) trackIDs(ctx context.Context, query string, args ...any) ([]int64, error) {
+	return trackIDsTx(ctx, s.DB, query, args...)
+}
+
+func trackIDsTx(ctx context.Context, q db.DBTX, query string, args ...any) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// rebuildSummaries rebuilds each track in its own short transaction so a
+// big archive doesn't hold the write lock for the whole run.
+func (s *Store) rebuildSummaries(ctx context.Context, ids []int64) error {
+	for _, id := range ids {
+		tx, err := s.DB.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if err := rebuildSummaryTx(ctx, tx, id); err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("track %d: %w", id, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,358 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+// ErrEditConflict is returned when an edit's preconditions no longer hold
+// (e.g. undoing an edit whose outputs were edited again).
+var ErrEditConflict = errors.New("edit conflict")
+
+// EditResult describes a completed (or undone) track edit.
+type EditResult struct {
+	EditID  int64   `json:"edit_id"`
+	Op      string  `json:"op"`
+	Inputs  []int64 `json:"inputs"`
+	Outputs []int64 `json:"outputs"`
+}
+
+// liveTrackTx loads a track that hasn't been soft-deleted.
+func liveTrackTx(ctx context.Context, tx *sql.Tx, id int64) (db.Track, error) {
+	var t db.Track
+	err := tx.QueryRowContext(ctx, `
+		SELECT id, name, started_at, ended_at, distance_m, notes, deleted_at, name_auto, vessel_id
+		FROM tracks
+		WHERE id = ? AND deleted_at IS NULL
+	`, id).Scan(&t.ID, &t.Name, &t.StartedAt, &t.EndedAt, &t.DistanceM, &t.Notes, &t.DeletedAt, &t.NameAuto, &t.VesselID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, fmt.Errorf("track %d: %w", id, ErrNotFound)
+	}
+	return t, err
+}
+
+// copyPositionsTx copies src positions with fromMs <= t_ms < toMs into dst.
+func copyPositionsTx(ctx context.Context, tx *sql.Tx, dst, src, fromMs, toMs int64) (int64, error) {
+	res, err := tx.ExecContext(ctx, `
+		INSERT INTO positions (track_id, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual)
+		SELECT ?, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual
+		FROM positions
+		WHERE track_id = ? AND t_ms >= ? AND t_ms < ?
+		ORDER BY t_ms ASC, id ASC
+	`, dst, src, fromMs, toMs)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// newTrackTx creates an empty track with from's name, notes and vessel.
+// NameAuto carries over a generated name, so an edit's output is renamed
+// after its own route.
+func newTrackTx(ctx context.Context, tx *sql.Tx, from db.Track) (int64, error) {
+	res, err := tx.ExecContext(ctx, `INSERT INTO tracks (name, started_at, notes, name_auto, vessel_id) VALUES (?, 0, ?, ?, ?)`,
+		from.Name, from.Notes, from.NameAuto, from.VesselID)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// runEdit wraps an edit in a transaction: fn creates the outputs, then the
+// inputs are soft-deleted, outputs recomputed and the edit logged.
+func (s *Store) runEdit(ctx context.Context, op string, params map[string]any, inputs []int64,
+	fn func(tx *sql.Tx) ([]int64, error)) (*EditResult, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	outputs, err := fn(tx)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().Unix()
+
+	for _, id := range outputs {
+		if err := rebuildSummaryTx(ctx, tx, id); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range inputs {
+		if _, err := tx.ExecContext(ctx, `UPDATE tracks SET deleted_at = ? WHERE id = ?`, now, id); err != nil {
+			return nil, err
+		}
+	}
+
+	pj, _ := json.Marshal(params)
+	res, err := tx.ExecContext(ctx, `INSERT INTO track_edits (op, params, created_at) VALUES (?, ?, ?)`, op, string(pj), now)
+	if err != nil {
+		return nil, err
+	}
+	editID, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range []struct {
+		role string
+		ids  []int64
+	}{{"input", inputs}, {"output", outputs}} {
+		for _, id := range m.ids {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO track_edit_members (edit_id, track_id, role) VALUES (?, ?, ?)`,
+				editID, id, m.role); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, id := range inputs {
+		s.InvalidateTrack(id)
+	}
+	return &EditResult{EditID: editID, Op: op, Inputs: inputs, Outputs: outputs}, nil
+}
+
+// SplitTrack splits a track at atMs (epoch milliseconds) into two new
+// tracks: positions before atMs, and positions from atMs onwards.
+func (s *Store) SplitTrack(ctx context.Context, id, atMs int64) (*EditResult, error) {
+	params := map[string]any{"track_id": id, "at_ms": atMs}
+	return s.runEdit(ctx, "split", params, []int64{id}, func(tx *sql.Tx) ([]int64, error) {
+		t, err := liveTrackTx(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		var outs []int64
+		for i, r := range [][2]int64{{minTime, atMs}, {atMs, maxTime}} {
+			part := t
+			part.Name = fmt.Sprintf("%s (%d)", t.Name, i+1)
+			out, err := newTrackTx(ctx, tx, part)
+			if err != nil {
+				return nil, err
+			}
+			n, err := copyPositionsTx(ctx, tx, out, id, r[0], r[1])
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				return nil, fmt.Errorf("split at %d ms leaves an empty track: %w", atMs, ErrEditConflict)
+			}
+			outs = append(outs, out)
+		}
+		return outs, nil
+	})
+}
+
+// TrimTrack keeps only positions with fromMs <= t_ms <= toMs in a new
+// track.
+func (s *Store) TrimTrack(ctx context.Context, id, fromMs, toMs int64) (*EditResult, error) {
+	if toMs < fromMs {
+		return nil, fmt.Errorf("trim range is empty: %w", ErrEditConflict)
+	}
+	params := map[string]any{"track_id": id, "from_ms": fromMs, "to_ms": toMs}
+	return s.runEdit(ctx, "trim", params, []int64{id}, func(tx *sql.Tx) ([]int64, error) {
+		t, err := liveTrackTx(ctx, tx, id)
+		if err != nil {
+			return nil, err
+		}
+		out, err := newTrackTx(ctx, tx, t)
+		if err != nil {
+			return nil, err
+		}
+		n, err := copyPositionsTx(ctx, tx, out, id, fromMs, toMs+1)
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			return nil, fmt.Errorf("trim range contains no positions: %w", ErrEditConflict)
+		}
+		return []int64{out}, nil
+	})
+}
+
+// MergeTracks joins tracks into one, in start-time order. The tracks must
+// not overlap in time. The merged track takes the earliest track's name
+// unless name is set.
+func (s *Store) MergeTracks(ctx context.Context, ids []int64, name string) (*EditResult, error) {
+	if len(ids) < 2 {
+		return nil, fmt.Errorf("merge needs at least two tracks: %w", ErrEditConflict)
+	}
+	params := map[string]any{"track_ids": ids, "name": name}
+	return s.runEdit(ctx, "merge", params, ids, func(tx *sql.Tx) ([]int64, error) {
+		tracks := make([]db.Track, 0, len(ids))
+		spans := make(map[int64][2]int64, len(ids))
+		seen := make(map[int64]bool, len(ids))
+		for _, id := range ids {
+			if seen[id] {
+				return nil, fmt.Errorf("track %d listed twice: %w", id, ErrEditConflict)
+			}
+			seen[id] = true
+			t, err := liveTrackTx(ctx, tx, id)
+			if err != nil {
+				return nil, err
+			}
+			if spans[id], err = trackSpanTx(ctx, tx, t); err != nil {
+				return nil, err
+			}
+			tracks = append(tracks, t)
+		}
+		sort.Slice(tracks, func(i, j int) bool { return spans[tracks[i].ID][0] < spans[tracks[j].ID][0] })
+		for i := 1; i < len(tracks); i++ {
+			if tracks[i].VesselID != tracks[0].VesselID {
+				return nil, fmt.Errorf("tracks %d and %d are from different vessels: %w", tracks[0].ID, tracks[i].ID, ErrEditConflict)
+			}
+			if spans[tracks[i].ID][0] < spans[tracks[i-1].ID][1] {
+				return nil, fmt.Errorf("tracks %d and %d overlap in time: %w", tracks[i-1].ID, tracks[i].ID, ErrEditConflict)
+			}
+		}
+
+		merged := tracks[0]
+		if name != "" {
+			merged.Name, merged.NameAuto = name, 0
+		}
+		out, err := newTrackTx(ctx, tx, merged)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tracks {
+			if _, err := copyPositionsTx(ctx, tx, out, t.ID, minTime, maxTime); err != nil {
+				return nil, err
+			}
+		}
+		return []int64{out}, nil
+	})
+}
+
+// trackSpanTx is the first and last fix time of t in epoch milliseconds,
+// or its recorded start and end for a track with no fixes.
+func trackSpanTx(ctx context.Context, tx *sql.Tx, t db.Track) ([2]int64, error) {
+	var first, last sql.NullInt64
+	if err := tx.QueryRowContext(ctx, `SELECT MIN(t_ms), MAX(t_ms) FROM positions WHERE track_id = ?`, t.ID).Scan(&first, &last); err != nil {
+		return [2]int64{}, err
+	}
+	if first.Valid {
+		return [2]int64{first.Int64, last.Int64}, nil
+	}
+	end := t.StartedAt
+	if t.EndedAt.Valid {
+		end = t.EndedAt.Int64
+	}
+	return [2]int64{t.StartedAt * 1000, end * 1000}, nil
+}
+
+// UndoEdit reverses an edit: outputs are soft-deleted and inputs restored.
+// It fails with ErrEditConflict if any output has since been edited or
+// deleted, or an input has been revived elsewhere.
+func (s *Store) UndoEdit(ctx context.Context, editID int64) (*EditResult, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	var op string
+	var undone sql.NullInt64
+	err = tx.QueryRowContext(ctx, `SELECT op, undone_at FROM track_edits WHERE id = ?`, editID).Scan(&op, &undone)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("edit %d: %w", editID, ErrNotFound)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if undone.Valid {
+		return nil, fmt.Errorf("edit %d already undone: %w", editID, ErrEditConflict)
+	}
+
+	res := &EditResult{EditID: editID, Op: op}
+	rows, err := tx.QueryContext(ctx, `
+		SELECT m.track_id, m.role, t.deleted_at
+		FROM track_edit_members m JOIN tracks t ON t.id = m.track_id
+		WHERE m.edit_id = ?
+		ORDER BY m.track_id
+	`, editID)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int64
+		var role string
+		var deleted sql.NullInt64
+		if err := rows.Scan(&id, &role, &deleted); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if role == "output" && deleted.Valid || role == "input" && !deleted.Valid {
+			rows.Close()
+			return nil, fmt.Errorf("track %d changed since edit %d: %w", id, editID, ErrEditConflict)
+		}
+		if role == "input" {
+			res.Inputs = append(res.Inputs, id)
+		} else {
+			res.Outputs = append(res.Outputs, id)
+		}
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().Unix()
+	if _, err := tx.ExecContext(ctx, `
+		UPDATE tracks SET deleted_at = CASE
+			WHEN id IN (SELECT track_id FROM track_edit_members WHERE edit_id = ?1 AND role = 'output') THEN ?2
+			ELSE NULL END
+		WHERE id IN (SELECT track_id FROM track_edit_members WHERE edit_id = ?1)
+	`, editID, now); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE track_edits SET undone_at = ? WHERE id = ?`, now, editID); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	for _, id := range res.Outputs {
+		s.InvalidateTrack(id)
+	}
+	return res, nil
+}
+
+// ListTrackEdits returns the most recent edits, newest first.
+func (s *Store) ListTrackEdits(ctx context.Context, limit int) ([]db.TrackEdit, error) {
+	if limit <= 0 || limit > 200 {
+		limit = 50
+	}
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, op, params, created_at, undone_at
+		FROM track_edits
+		ORDER BY id DESC
+		LIMIT ?
+	`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []db.TrackEdit
+	for rows.Next() {
+		var e db.TrackEdit
+		if err := rows.Scan(&e.ID, &e.Op, &e.Params, &e.CreatedAt, &e.UndoneAt); err != nil {
+			return nil, err
+		}
+		items = append(items, e)
+	}
+	return items, rows.Err()
+}
+
+// Open ends of a time range in epoch milliseconds.
+const (
+	minTime int64 = -1 << 52
+	maxTime int64 = 1 << 52
+)
@@ -0,0 +1,308 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"wakemap/internal/db"
+)
+
+const (
+	DefaultTrackPage = 50
+	MaxTrackPage     = 200
+)
+
+var (
+	// ErrBadCursor is returned for a cursor that doesn't decode or was
+	// issued for a different sort.
+	ErrBadCursor = errors.New("invalid cursor")
+	ErrBadSort   = errors.New("unknown sort")
+)
+
+// Sort keys for QueryTracks. Each maps to a non-null SQL expression; ties
+// are broken by id so keyset pagination is stable.
+var trackSortExprs = map[string]string{
+	"started":  "t.started_at",
+	"ended":    "COALESCE(t.ended_at, t.started_at)",
+	"distance": "COALESCE(t.distance_m, 0)",
+	"name":     "t.name COLLATE NOCASE",
+}
+
+// TrackQuery filters and pages the track list. Zero values mean "no filter".
+type TrackQuery struct {
+	From, To     int64       // track overlaps [From, To], epoch seconds
+	MinDistanceM float64     // inclusive
+	MaxDistanceM float64     // inclusive; 0 = unbounded
+	Text         string      // substring of name, notes or route places, case-insensitive
+	BBox         *[4]float64 // minLon, minLat, maxLon, maxLat; any fix inside
+	VesselID     int64       // 0 = any vessel
+	Sort         string      // started (default), ended, distance, name
+	Asc          bool
+	Limit        int
+	Cursor       string // from a previous TrackPage.NextCursor
+
+	// Exclude, if set, treats fixes it reports true for as outside BBox,
+	// so private spots can't be found by shrinking a box around them.
+	Exclude func(lon, lat float64) bool
+	// ShowsPlace, if set, lets Text match a route place, or a name
+	// generated from the route, only where it reports true for the end
+	// the place was resolved for, so hidden places can't be searched for.
+	ShowsPlace func(lon, lat float64) bool
+}
+
+// TrackPage is one page of QueryTracks results. Total counts every match,
+// not just this page.
+type TrackPage struct {
+	Tracks     []db.Track
+	Routes     map[int64]TrackRoute // by track id
+	Total      int
+	NextCursor string
+}
+
+// trackCursor is the keyset position after the last row of a page.
+type trackCursor struct {
+	Sort string `json:"s"`
+	Asc  bool   `json:"a,omitempty"`
+	Key  any    `json:"k"`
+	ID   int64  `json:"id"`
+}
+
+func (c trackCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeTrackCursor(s string) (trackCursor, error) {
+	var c trackCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrBadCursor
+	}
+	if err := json.Unmarshal(b, &c); err != nil || c.Key == nil {
+		return c, ErrBadCursor
+	}
+	return c, nil
+}
+
+// QueryTracks lists live tracks matching q, one page at a time.
+func (s *Store) QueryTracks(ctx context.Context, q TrackQuery) (TrackPage, error) {
+	page := TrackPage{Routes: map[int64]TrackRoute{}}
+
+	if q.Sort == "" {
+		q.Sort = "started"
+	}
+	sortExpr, ok := trackSortExprs[q.Sort]
+	if !ok {
+		return page, fmt.Errorf("%w %q", ErrBadSort, q.Sort)
+	}
+	if q.Limit <= 0 {
+		q.Limit = DefaultTrackPage
+	}
+	q.Limit = min(q.Limit, MaxTrackPage)
+
+	// Auto-named tracks take their names from their routes, which
+	// RefreshTrackRoutes brings up to date in the background; until it
+	// catches up a changed track lists under its previous name.
+	where := []string{"t.deleted_at IS NULL"}
+	var args []any
+	if q.From > 0 {
+		where = append(where, "COALESCE(t.ended_at, t.started_at) >= ?")
+		args = append(args, q.From)
+	}
+	if q.To > 0 {
+		where = append(where, "t.started_at <= ?")
+		args = append(args, q.To)
+	}
+	if q.VesselID != 0 {
+		where = append(where, "t.vessel_id = ?")
+		args = append(args, q.VesselID)
+	}
+	if q.MinDistanceM > 0 {
+		where = append(where, "COALESCE(t.distance_m, 0) >= ?")
+		args = append(args, q.MinDistanceM)
+	}
+	if q.MaxDistanceM > 0 {
+		where = append(where, "COALESCE(t.distance_m, 0) <= ?")
+		args = append(args, q.MaxDistanceM)
+	}
+	if text := strings.TrimSpace(q.Text); text != "" && q.ShowsPlace != nil {
+		like := "%" + escapeLike(text) + "%"
+		ids, err := s.tracksNamedShowing(ctx, text, q.ShowsPlace)
+		if err != nil {
+			return page, err
+		}
+		idsJSON, _ := json.Marshal(ids)
+		where = append(where, `((t.name_auto = 0 AND t.name LIKE ? ESCAPE '\') OR COALESCE(t.notes, '') LIKE ? ESCAPE '\'
+			OR t.id IN (SELECT value FROM json_each(?)))`)
+		args = append(args, like, like, string(idsJSON))
+	} else if text != "" {
+		like := "%" + escapeLike(text) + "%"
+		where = append(where, `(t.name LIKE ? ESCAPE '\' OR COALESCE(t.notes, '') LIKE ? ESCAPE '\'
+			OR COALESCE(ts.from_place, '') LIKE ? ESCAPE '\' OR COALESCE(ts.to_place, '') LIKE ? ESCAPE '\')`)
+		args = append(args, like, like, like, like)
+	}
+	if b := q.BBox; b != nil && q.Exclude != nil {
+		ids, err := s.tracksShowingIn(ctx, *b, q.Exclude)
+		if err != nil {
+			return page, err
+		}
+		idsJSON, _ := json.Marshal(ids)
+		where = append(where, `t.id IN (SELECT value FROM json_each(?))`)
+		args = append(args, string(idsJSON))
+	} else if b != nil {
+		// The summary bbox rules most tracks out cheaply; the R*Tree
+		// confirms at least one fix actually falls inside.
+		where = append(where, `EXISTS (
+			SELECT 1 FROM track_summaries s
+			WHERE s.track_id = t.id AND s.max_x >= ? AND s.min_x <= ? AND s.max_y >= ? AND s.min_y <= ?
+		)`, `EXISTS (
+			SELECT 1 FROM positions_rtree r JOIN positions p ON p.id = r.id
+			WHERE r.minX >= ? AND r.maxX <= ? AND r.minY >= ? AND r.maxY <= ? AND p.track_id = t.id
+		)`)
+		args = append(args, b[0], b[2], b[1], b[3], b[0], b[2], b[1], b[3])
+	}
+
+	filter := strings.Join(where, " AND ")
+	if err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM tracks t LEFT JOIN track_summaries ts ON ts.track_id = t.id WHERE `+filter, args...).Scan(&page.Total); err != nil {
+		return page, err
+	}
+
+	dir, cmp := "DESC", "<"
+	if q.Asc {
+		dir, cmp = "ASC", ">"
+	}
+	if q.Cursor != "" {
+		c, err := decodeTrackCursor(q.Cursor)
+		if err != nil {
+			return page, err
+		}
+		if c.Sort != q.Sort || c.Asc != q.Asc {
+			return page, ErrBadCursor
+		}
+		filter += fmt.Sprintf(" AND (%[1]s %[2]s ? OR (%[1]s = ? AND t.id %[2]s ?))", sortExpr, cmp)
+		args = append(args, c.Key, c.Key, c.ID)
+	}
+
+	rows, err := s.DB.QueryContext(ctx, fmt.Sprintf(`
+		SELECT t.id, t.name, t.started_at, t.ended_at, t.distance_m, t.notes, t.deleted_at, t.name_auto, t.vessel_id,
+		       ts.from_place, ts.to_place, ts.from_lon, ts.from_lat, ts.to_lon, ts.to_lat, %[1]s
+		FROM tracks t LEFT JOIN track_summaries ts ON ts.track_id = t.id
+		WHERE %[2]s
+		ORDER BY %[1]s %[3]s, t.id %[3]s
+		LIMIT ?
+	`, sortExpr, filter, dir), append(args, q.Limit+1)...)
+	if err != nil {
+		return page, err
+	}
+	defer rows.Close()
+
+	var lastKey any
+	for rows.Next() {
+		var t db.Track
+		var from, to sql.NullString
+		var fromLon, fromLat, toLon, toLat sql.NullFloat64
+		var key any
+		if err := rows.Scan(&t.ID, &t.Name, &t.StartedAt, &t.EndedAt, &t.DistanceM, &t.Notes, &t.DeletedAt, &t.NameAuto, &t.VesselID,
+			&from, &to, &fromLon, &fromLat, &toLon, &toLat, &key); err != nil {
+			return page, err
+		}
+		if len(page.Tracks) == q.Limit {
+			last := page.Tracks[len(page.Tracks)-1]
+			page.NextCursor = trackCursor{Sort: q.Sort, Asc: q.Asc, Key: lastKey, ID: last.ID}.encode()
+			break
+		}
+		if b, ok := key.([]byte); ok {
+			key = string(b)
+		}
+		page.Tracks = append(page.Tracks, t)
+		page.Routes[t.ID] = routeFromSummary(from, to, fromLon, fromLat, toLon, toLat)
+		lastKey = key
+	}
+	return page, rows.Err()
+}
+
+// tracksShowingIn returns the tracks with a fix inside bb that exclude
+// lets through. Every fix in bb is read, as SearchTracks does, since the
+// zones can't be put to SQLite.
+func (s *Store) tracksShowingIn(ctx context.Context, bb [4]float64, exclude func(lon, lat float64) bool) ([]int64, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT p.track_id, p.lon, p.lat
+		FROM positions_rtree r JOIN positions p ON p.id = r.id
+		WHERE r.minX >= ? AND r.maxX <= ? AND r.minY >= ? AND r.maxY <= ?
+	`, bb[0], bb[2], bb[1], bb[3])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := map[int64]bool{}
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		var lon, lat float64
+		if err := rows.Scan(&id, &lon, &lat); err != nil {
+			return nil, err
+		}
+		if !seen[id] && !exclude(lon, lat) {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	return ids, rows.Err()
+}
+
+// tracksNamedShowing returns the tracks whose route places, or name
+// generated from them, contain text once the places shows rejects are
+// left out. Other auto names (placeholders before the route resolves) are
+// matched as they are.
+func (s *Store) tracksNamedShowing(ctx context.Context, text string, shows func(lon, lat float64) bool) ([]int64, error) {
+	like := "%" + escapeLike(text) + "%"
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT t.id, t.name, t.name_auto, ts.from_place, ts.to_place, ts.from_lon, ts.from_lat, ts.to_lon, ts.to_lat
+		FROM tracks t JOIN track_summaries ts ON ts.track_id = t.id
+		WHERE t.deleted_at IS NULL
+		  AND (ts.from_place LIKE ?1 ESCAPE '\' OR ts.to_place LIKE ?1 ESCAPE '\' OR (t.name_auto = 1 AND t.name LIKE ?1 ESCAPE '\'))
+	`, like)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	text = strings.ToLower(text)
+	contains := func(s string) bool { return s != "" && strings.Contains(strings.ToLower(s), text) }
+	ids := []int64{}
+	for rows.Next() {
+		var id, auto int64
+		var name string
+		var from, to sql.NullString
+		var fromLon, fromLat, toLon, toLat sql.NullFloat64
+		if err := rows.Scan(&id, &name, &auto, &from, &to, &fromLon, &fromLat, &toLon, &toLat); err != nil {
+			return nil, err
+		}
+		route := routeFromSummary(from, to, fromLon, fromLat, toLon, toLat)
+		generated := auto == 1 && name == route.Name()
+		if route.From != "" && !shows(route.FromLon, route.FromLat) {
+			route.From = ""
+		}
+		if route.To != "" && !shows(route.ToLon, route.ToLat) {
+			route.To = ""
+		}
+		switch {
+		case contains(route.From), contains(route.To):
+		case generated && contains(route.Name()):
+		case auto == 1 && !generated && contains(name):
+		default:
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
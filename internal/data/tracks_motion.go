@@ -0,0 +1,209 @@
+package data
+
+import (
+	"math"
+	"sort"
+
+	"wakemap/internal/geo"
+)
+
+const (
+	// Anything faster than this (~50 kn) is a GPS spike for a yacht.
+	maxPlausibleSOGms = 25.0
+	// Histogram bin width in knots.
+	histBinKn = 1.0
+)
+
+// SpeedBin is one distance-weighted histogram bucket.
+type SpeedBin struct {
+	FromKn    float64 `json:"from_kn"`
+	ToKn      float64 `json:"to_kn"`
+	DistanceM float64 `json:"distance_m"`
+	Seconds   int64   `json:"seconds"`
+}
+
+// Leg is a stretch underway between two stationary segments.
+type Leg struct {
+	StartedAt int64   `json:"started_at"`
+	EndedAt   int64   `json:"ended_at"`
+	DistanceM float64 `json:"distance_m"`
+}
+
+// MotionStats summarises how a track was sailed rather than where.
+type MotionStats struct {
+	MovingS        int64      `json:"moving_s"`
+	StoppedS       int64      `json:"stopped_s"`
+	MovingM        float64    `json:"moving_m"`
+	AvgMovingKnots float64    `json:"avg_moving_knots"`
+	MaxKnots       float64    `json:"max_knots"` // spike-filtered
+	P50Knots       float64    `json:"p50_knots"` // over moving fixes
+	P95Knots       float64    `json:"p95_knots"`
+	Stops          int        `json:"stops"`   // stationary segments
+	StopsS         int64      `json:"stops_s"` // total time in them
+	LongestLeg     *Leg       `json:"longest_leg,omitempty"`
+	SpeedHistogram []SpeedBin `json:"speed_histogram"`
+}
+
+// computeMotion derives MotionStats from the per-point arrays and the
+// track's segments. It only looks at Coords/TimesMs/SOGms, so it must run
+// before any simplification. Time is summed in milliseconds, so fixes a
+// fraction of a second apart still count.
+//
+// Stops are the stationary segments, so they agree with /segments; the
+// StoppedS figure is all time below stopSpeedMS, short pauses included.
+func computeMotion(ts *TrackStats, segs []Segment) MotionStats {
+	var m MotionStats
+	n := len(ts.Coords)
+	if n < 2 {
+		m.SpeedHistogram = []SpeedBin{}
+		return m
+	}
+	for _, sg := range segs {
+		if sg.Stationary() {
+			m.Stops++
+			m.StopsS += sg.EndedAt - sg.StartedAt
+		}
+	}
+
+	sog := filterSpikes(ts.SOGms)
+
+	var hist []SpeedBin
+	var histMs []int64
+	var movingMs, stoppedMs int64
+	var moving []float64
+	seg := 0 // index into segs of the segment holding the current interval
+	var leg Leg
+	legOpen := false
+
+	closeLeg := func() {
+		if legOpen && (m.LongestLeg == nil || leg.DistanceM > m.LongestLeg.DistanceM) {
+			l := leg
+			m.LongestLeg = &l
+		}
+		legOpen = false
+	}
+
+	for i := 1; i < n; i++ {
+		// A stationary segment ends the leg before it.
+		for seg < len(segs)-1 && segs[seg].end <= i-1 {
+			seg++
+		}
+		inStop := seg < len(segs) && segs[seg].Stationary()
+		if inStop {
+			closeLeg()
+		}
+
+		dtMs := ts.TimesMs[i] - ts.TimesMs[i-1]
+		if dtMs <= 0 {
+			continue
+		}
+		d := haversineCoords(ts.Coords[i-1], ts.Coords[i])
+		v := d / (float64(dtMs) / 1000)
+		if !math.IsNaN(sog[i]) {
+			v = sog[i]
+		} else if v > maxPlausibleSOGms {
+			// Position jump: keep the time, drop it from speed and distance.
+			movingMs += dtMs
+			continue
+		}
+
+		if v < stopSpeedMS {
+			stoppedMs += dtMs
+			continue
+		}
+
+		if !inStop {
+			if !legOpen {
+				leg = Leg{StartedAt: ts.Times[i-1]}
+				legOpen = true
+			}
+			leg.EndedAt = ts.Times[i]
+			leg.DistanceM += d
+		}
+
+		movingMs += dtMs
+		m.MovingM += d
+		moving = append(moving, v)
+
+		kn := v * 1.943844492
+		b := int(kn / histBinKn)
+		for len(hist) <= b {
+			k := float64(len(hist)) * histBinKn
+			hist = append(hist, SpeedBin{FromKn: k, ToKn: k + histBinKn})
+			histMs = append(histMs, 0)
+		}
+		hist[b].DistanceM += d
+		histMs[b] += dtMs
+	}
+	closeLeg()
+
+	m.MovingS, m.StoppedS = msToS(movingMs), msToS(stoppedMs)
+	for b := range hist {
+		hist[b].Seconds = msToS(histMs[b])
+	}
+	if movingMs > 0 {
+		m.AvgMovingKnots = m.MovingM / (float64(movingMs) / 1000) * 1.943844492
+	}
+	if len(moving) > 0 {
+		sort.Float64s(moving)
+		m.MaxKnots = moving[len(moving)-1] * 1.943844492
+		m.P50Knots = percentile(moving, 0.50) * 1.943844492
+		m.P95Knots = percentile(moving, 0.95) * 1.943844492
+	}
+	if hist == nil {
+		hist = []SpeedBin{}
+	}
+	m.SpeedHistogram = hist
+	return m
+}
+
+// filterSpikes replaces implausible SOG values with NaN: anything above
+// maxPlausibleSOGms, or more than double (+1 m/s) the median of its
+// five-point neighbourhood.
+func filterSpikes(sog []float64) []float64 {
+	out := make([]float64, len(sog))
+	win := make([]float64, 0, 5)
+	for i, v := range sog {
+		out[i] = v
+		if math.IsNaN(v) {
+			continue
+		}
+		if v > maxPlausibleSOGms {
+			out[i] = math.NaN()
+			continue
+		}
+		win = win[:0]
+		for j := max(0, i-2); j <= min(len(sog)-1, i+2); j++ {
+			if j != i && !math.IsNaN(sog[j]) {
+				win = append(win, sog[j])
+			}
+		}
+		if len(win) >= 2 {
+			sort.Float64s(win)
+			if med := percentile(win, 0.5); v > 2*med+1 {
+				out[i] = math.NaN()
+			}
+		}
+	}
+	return out
+}
+
+// percentile of an ascending slice, linearly interpolated.
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := p * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	hi := int(math.Ceil(pos))
+	return sorted[lo] + (sorted[hi]-sorted[lo])*(pos-float64(lo))
+}
+
+func haversineCoords(a, b [2]float64) float64 {
+	return geo.HaversineM(a[0], a[1], b[0], b[1])
+}
+
+// msToS rounds a duration in milliseconds to whole seconds.
+func msToS(ms int64) int64 {
+	return (ms + 500) / 1000
+}
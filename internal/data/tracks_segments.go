@@ -0,0 +1,174 @@
+package data
+
+import "math"
+
+// Segment kinds.
+const (
+	SegUnderway = "underway"
+	SegAnchored = "anchored"
+	SegMoored   = "moored"
+	SegDrifting = "drifting"
+)
+
+const (
+	// Fixes within stayRadiusM of a stay's first fix, for at least
+	// minStayS, make a stationary period.
+	stayRadiusM = 150.0
+	minStayS    = 600
+	// A stay this tight is a dock or mooring rather than swinging at anchor.
+	mooredRadiusM = 20.0
+	// Underway below this (~1.5 kn) for minStayS is drifting.
+	driftMaxMS = 0.75
+)
+
+// Segment is an underway leg or a stationary period within a track.
+type Segment struct {
+	Kind      string     `json:"kind"`
+	StartedAt int64      `json:"started_at"`
+	EndedAt   int64      `json:"ended_at"`
+	DistanceM float64    `json:"distance_m"`
+	Centroid  [2]float64 `json:"centroid"` // lon, lat
+	RadiusM   float64    `json:"radius_m"` // max distance from centroid
+	Points    int        `json:"points"`
+
+	start, end int // inclusive indices into TrackStats arrays
+}
+
+// Stationary reports whether the segment is a stop of any kind.
+func (sg *Segment) Stationary() bool { return sg.Kind != SegUnderway }
+
+// computeSegments splits a track into underway legs and stationary periods.
+// Stays are found first by distance from an anchor fix; what remains is
+// underway, with sustained slow runs carved out as drifting.
+func computeSegments(ts *TrackStats) []Segment {
+	n := len(ts.Coords)
+	if n < 2 {
+		return nil
+	}
+
+	var stays [][2]int
+	for i := 0; i < n-1; {
+		j := i + 1
+		for j < n && haversineCoords(ts.Coords[i], ts.Coords[j]) <= stayRadiusM {
+			j++
+		}
+		if ts.Times[j-1]-ts.Times[i] >= minStayS {
+			stays = append(stays, [2]int{i, j - 1})
+			i = j - 1
+			if i == n-1 {
+				break
+			}
+			continue
+		}
+		i++
+	}
+
+	sog := filterSpikes(ts.SOGms)
+	var segs []Segment
+	prev := 0
+	for _, st := range stays {
+		if st[0] > prev {
+			segs = append(segs, splitDrift(ts, sog, prev, st[0])...)
+		}
+		sg := newSegment(ts, SegAnchored, st[0], st[1])
+		if sg.RadiusM <= mooredRadiusM {
+			sg.Kind = SegMoored
+		}
+		segs = append(segs, sg)
+		prev = st[1]
+	}
+	if prev < n-1 {
+		segs = append(segs, splitDrift(ts, sog, prev, n-1)...)
+	}
+	return segs
+}
+
+// splitDrift turns the underway span [a, b] into underway and drifting
+// segments.
+func splitDrift(ts *TrackStats, sog []float64, a, b int) []Segment {
+	var out []Segment
+	emit := func(kind string, i, j int) {
+		if j > i {
+			out = append(out, newSegment(ts, kind, i, j))
+		}
+	}
+
+	cur := a
+	slowFrom := -1
+	for i := a + 1; i <= b; i++ {
+		slow := segSpeed(ts, sog, i) < driftMaxMS
+		switch {
+		case slow && slowFrom < 0:
+			slowFrom = i - 1
+		case !slow && slowFrom >= 0:
+			if ts.Times[i-1]-ts.Times[slowFrom] >= minStayS {
+				emit(SegUnderway, cur, slowFrom)
+				emit(SegDrifting, slowFrom, i-1)
+				cur = i - 1
+			}
+			slowFrom = -1
+		}
+	}
+	if slowFrom >= 0 && ts.Times[b]-ts.Times[slowFrom] >= minStayS {
+		emit(SegUnderway, cur, slowFrom)
+		emit(SegDrifting, slowFrom, b)
+	} else {
+		emit(SegUnderway, cur, b)
+	}
+	return out
+}
+
+// segSpeed is the speed into fix i: reported (spike-filtered) when known,
+// otherwise distance over time.
+func segSpeed(ts *TrackStats, sog []float64, i int) float64 {
+	if !math.IsNaN(sog[i]) {
+		return sog[i]
+	}
+	dtMs := ts.TimesMs[i] - ts.TimesMs[i-1]
+	if dtMs <= 0 {
+		return 0
+	}
+	return haversineCoords(ts.Coords[i-1], ts.Coords[i]) / (float64(dtMs) / 1000)
+}
+
+func newSegment(ts *TrackStats, kind string, i, j int) Segment {
+	sg := Segment{
+		Kind:      kind,
+		StartedAt: ts.Times[i],
+		EndedAt:   ts.Times[j],
+		Points:    j - i + 1,
+		start:     i,
+		end:       j,
+	}
+	var sx, sy float64
+	for k := i; k <= j; k++ {
+		sx += ts.Coords[k][0]
+		sy += ts.Coords[k][1]
+		if k > i {
+			sg.DistanceM += haversineCoords(ts.Coords[k-1], ts.Coords[k])
+		}
+	}
+	sg.Centroid = [2]float64{sx / float64(sg.Points), sy / float64(sg.Points)}
+	for k := i; k <= j; k++ {
+		sg.RadiusM = max(sg.RadiusM, haversineCoords(sg.Centroid, ts.Coords[k]))
+	}
+	return sg
+}
+
+// NightsAt counts local midnights spent between from and to at longitude
+// lon, using solar time so no time zone database is needed.
+func NightsAt(from, to int64, lon float64) int {
+	off := int64(lon / 15 * 3600)
+	const day = 86400
+	a := floorDiv(from+off, day)
+	b := floorDiv(to+off, day)
+	return int(b - a)
+}
+
+func floorDiv(a, b int64) int64 {
+	q := a / b
+	if (a%b != 0) && (a < 0) {
+		q--
+	}
+	return q
+}
@@ -0,0 +1,181 @@
+package data
+
+import (
+	"context"
+	"math"
+	"sync"
+
+	"wakemap/internal/geo"
+)
+
+// Below this SOG a fix counts as stopped; the first and last fix of every
+// stop survive simplification so stops don't get smoothed away.
+const stopSpeedMS = 0.25
+
+const simplifyCacheMax = 64
+
+type simplifyKey struct {
+	trackID int64
+	tolCM   int64 // tolerance rounded to centimetres
+	gaps    GapRule
+}
+
+// trackFingerprint changes whenever positions are added to or removed from
+// a track, which covers writers outside this process (seed scripts, sim).
+type trackFingerprint struct {
+	n, maxID, maxT int64
+}
+
+type simplifyEntry struct {
+	fp   trackFingerprint
+	ts   *TrackStats
+	raw  int
+	used int64
+}
+
+type simplifyCache struct {
+	mu    sync.Mutex
+	tick  int64
+	items map[simplifyKey]*simplifyEntry
+}
+
+func newSimplifyCache() *simplifyCache {
+	return &simplifyCache{items: make(map[simplifyKey]*simplifyEntry)}
+}
+
+func (c *simplifyCache) get(k simplifyKey, fp trackFingerprint) (*simplifyEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.items[k]
+	if !ok || e.fp != fp {
+		return nil, false
+	}
+	c.tick++
+	e.used = c.tick
+	return e, true
+}
+
+func (c *simplifyCache) put(k simplifyKey, e *simplifyEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.items) >= simplifyCacheMax {
+		var oldK simplifyKey
+		oldUsed := int64(math.MaxInt64)
+		for k, v := range c.items {
+			if v.used < oldUsed {
+				oldK, oldUsed = k, v.used
+			}
+		}
+		delete(c.items, oldK)
+	}
+	c.tick++
+	e.used = c.tick
+	c.items[k] = e
+}
+
+func (c *simplifyCache) invalidate(trackID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.items {
+		if k.trackID == trackID {
+			delete(c.items, k)
+		}
+	}
+}
+
+// InvalidateTrack drops cached derived geometry for a track. Store methods
+// that edit positions call it; the fingerprint check catches the rest.
+func (s *Store) InvalidateTrack(trackID int64) {
+	s.simplified.invalidate(trackID)
+}
+
+func (s *Store) trackFingerprint(ctx context.Context, trackID int64) (trackFingerprint, error) {
+	var fp trackFingerprint
+	err := s.DB.QueryRowContext(ctx, `
+		SELECT COUNT(*), COALESCE(MAX(id), 0), COALESCE(MAX(t_ms), 0)
+		FROM positions
+		WHERE track_id = ?
+	`, trackID).Scan(&fp.n, &fp.maxID, &fp.maxT)
+	return fp, err
+}
+
+// ToleranceForZoom picks a tolerance of roughly half a screen pixel at the
+// given web-map zoom and latitude.
+func ToleranceForZoom(zoom, lat float64) float64 {
+	return geo.MetersPerPixel(zoom, lat) / 2
+}
+
+// SimplifiedTrackStats is ComputeTrackStats with the per-point arrays
+// thinned by Douglas-Peucker at tolM metres, and broken at gaps by gaps.
+// Distance, bbox and times still come from the full-resolution track. raw
+// is the unsimplified point count. Results are cached per track, tolerance
+// and gap rule.
+func (s *Store) SimplifiedTrackStats(ctx context.Context, id int64, tolM float64, gaps GapRule) (ts *TrackStats, raw int, err error) {
+	fp, err := s.trackFingerprint(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	key := simplifyKey{trackID: id, tolCM: int64(math.Round(tolM * 100)), gaps: gaps}
+	if e, ok := s.simplified.get(key, fp); ok {
+		return e.ts, e.raw, nil
+	}
+
+	full, err := s.ComputeTrackStats(ctx, id)
+	if err != nil {
+		return nil, 0, err
+	}
+	full.MarkGaps(gaps)
+	ts = SimplifyTrackStats(full, tolM)
+
+	s.simplified.put(key, &simplifyEntry{fp: fp, ts: ts, raw: len(full.Coords)})
+	return ts, len(full.Coords), nil
+}
+
+// SimplifyTrackStats returns a copy of full keeping only the vertices that
+// survive simplification, with the other per-point arrays filtered in
+// step. Speed transitions, segment boundaries and both sides of every
+// break are pinned.
+func SimplifyTrackStats(full *TrackStats, tolM float64) *TrackStats {
+	keep := make([]bool, len(full.Coords))
+	for i := 1; i < len(full.SOGms); i++ {
+		if stoppedAt(full.SOGms[i-1]) != stoppedAt(full.SOGms[i]) {
+			keep[i-1], keep[i] = true, true
+		}
+	}
+	for _, sg := range full.Segments {
+		keep[sg.start], keep[sg.end] = true, true
+	}
+	for i, b := range full.Breaks {
+		if b {
+			keep[i-1], keep[i] = true, true
+		}
+	}
+	idx := geo.Simplify(full.Coords, tolM, keep)
+
+	ts := *full
+	ts.Coords = make([][2]float64, len(idx))
+	ts.Times = make([]int64, len(idx))
+	ts.TimesMs = make([]int64, len(idx))
+	ts.SOGms = make([]float64, len(idx))
+	ts.COGrad = make([]float64, len(idx))
+	ts.Src = make([]string, len(idx))
+	if full.Breaks != nil {
+		ts.Breaks = make([]bool, len(idx))
+	}
+	for j, i := range idx {
+		ts.Coords[j] = full.Coords[i]
+		ts.Times[j] = full.Times[i]
+		ts.TimesMs[j] = full.TimesMs[i]
+		ts.SOGms[j] = full.SOGms[i]
+		ts.COGrad[j] = full.COGrad[i]
+		ts.Src[j] = full.Src[i]
+		if full.Breaks != nil {
+			ts.Breaks[j] = full.Breaks[i]
+		}
+	}
+	return &ts
+}
+
+func stoppedAt(sog float64) bool {
+	return !math.IsNaN(sog) && sog < stopSpeedMS
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"math"
+
+	"wakemap/internal/geo"
 )
 
 type TrackStats struct {
@@ -14,61 +16,127 @@ type TrackStats struct {
 	MinX, MinY float64
 	MaxX, MaxY float64
 	Coords     [][2]float64
+	Times      []int64   // one per coordinate; epoch seconds
+	TimesMs    []int64   // one per coordinate; epoch milliseconds
 	SOGms      []float64 // one per coordinate; NaN when unknown
+	COGrad     []float64 // one per coordinate; NaN when not reported
+	Src        []string  // one per coordinate; "" when unknown
+	// Breaks, when set, has one flag per coordinate: the line breaks
+	// before it, across a gap in the record. See MarkGaps.
+	Breaks   []bool
+	Motion   MotionStats
+	Segments []Segment
+}
+
+// GapRule says when consecutive fixes are too far apart, in time (S
+// seconds) or distance (M metres), to join with a line. Zero disables a
+// limit.
+type GapRule struct {
+	S int64
+	M float64
+}
+
+// MarkGaps sets ts.Breaks from the full-resolution fixes. Call it before
+// simplifying, which keeps both fixes either side of every break.
+func (ts *TrackStats) MarkGaps(g GapRule) {
+	ts.Breaks = make([]bool, len(ts.Coords))
+	for i := 1; i < len(ts.Coords); i++ {
+		ts.Breaks[i] = (g.S > 0 && ts.Times[i]-ts.Times[i-1] > g.S) ||
+			(g.M > 0 && haversineCoords(ts.Coords[i-1], ts.Coords[i]) > g.M)
+	}
+}
+
+// Parts splits the fixes at Breaks into [start, end] index ranges, both
+// inclusive. Without Breaks the whole track is one part.
+func (ts *TrackStats) Parts() [][2]int {
+	n := len(ts.Coords)
+	if n == 0 {
+		return nil
+	}
+	var parts [][2]int
+	start := 0
+	for i := 1; i < n; i++ {
+		if i < len(ts.Breaks) && ts.Breaks[i] {
+			parts = append(parts, [2]int{start, i - 1})
+			start = i
+		}
+	}
+	return append(parts, [2]int{start, n - 1})
 }
 
-func haversineMeters(aLon, aLat, bLon, bLat float64) float64 {
-	const R = 6371000.0
-	toRad := func(d float64) float64 { return d * math.Pi / 180 }
-	dLat := toRad(bLat - aLat)
-	dLon := toRad(bLon - aLon)
-	la1 := toRad(aLat)
-	la2 := toRad(bLat)
-	sin1 := math.Sin(dLat / 2)
-	sin2 := math.Sin(dLon / 2)
-	h := sin1*sin1 + math.Cos(la1)*math.Cos(la2)*sin2*sin2
-	return 2 * R * math.Asin(math.Min(1, math.Sqrt(h)))
+// DurationS is the elapsed time between the first and last fix in seconds.
+func (ts *TrackStats) DurationS() int64 {
+	if ts.EndedAt > ts.StartedAt {
+		return ts.EndedAt - ts.StartedAt
+	}
+	return 0
+}
+
+// AvgKnots is the mean speed over the whole track (distance / duration),
+// timed to the millisecond.
+func (ts *TrackStats) AvgKnots() float64 {
+	n := len(ts.TimesMs)
+	if n < 2 || ts.TimesMs[n-1] <= ts.TimesMs[0] {
+		return 0
+	}
+	return ts.DistanceM / (float64(ts.TimesMs[n-1]-ts.TimesMs[0]) / 1000) * 1.943844492
 }
 
 func (s *Store) ComputeTrackStats(ctx context.Context, id int64) (*TrackStats, error) {
+	return s.computeTrackStats(ctx, id, 0)
+}
+
+// ComputeTrackStatsUntil is ComputeTrackStats over the fixes up to and
+// within until (epoch seconds), as if the track had ended there.
+func (s *Store) ComputeTrackStatsUntil(ctx context.Context, id, until int64) (*TrackStats, error) {
+	return s.computeTrackStats(ctx, id, until)
+}
+
+func (s *Store) computeTrackStats(ctx context.Context, id, until int64) (*TrackStats, error) {
 	ts := &TrackStats{
 		MinX: math.Inf(1), MinY: math.Inf(1),
 		MaxX: math.Inf(-1), MaxY: math.Inf(-1),
 	}
 
 	// Track name
-	if err := s.DB.QueryRowContext(ctx, `SELECT name FROM tracks WHERE id = ?`, id).Scan(&ts.Name); err != nil {
+	if err := s.DB.QueryRowContext(ctx, `SELECT name FROM tracks WHERE id = ? AND deleted_at IS NULL`, id).Scan(&ts.Name); err != nil {
 		return nil, err
 	}
 
+	var endMs int64 // exclusive; 0 = no limit
+	if until > 0 {
+		endMs = (until + 1) * 1000
+	}
 	rows, err := s.DB.QueryContext(ctx, `
-		SELECT lon, lat, t, sog_ms
+		SELECT lon, lat, t_ms, sog_ms, cog_rad, src
 		FROM positions
-		WHERE track_id = ?
-		ORDER BY t ASC
-	`, id)
+		WHERE track_id = ?1 AND (?2 = 0 OR t_ms < ?2)
+		ORDER BY t_ms ASC, id ASC
+	`, id, endMs)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	var prevLon, prevLat float64
-	var prevT int64
+	var prevMs int64
 	var hasPrev bool
 
 	for rows.Next() {
 		var lon, lat float64
-		var t int64
-		var sog sql.NullFloat64
-		if err := rows.Scan(&lon, &lat, &t, &sog); err != nil {
+		var ms int64
+		var sog, cog sql.NullFloat64
+		var src sql.NullString
+		if err := rows.Scan(&lon, &lat, &ms, &sog, &cog, &src); err != nil {
 			return nil, err
 		}
+		t := floorDiv(ms, 1000)
 
 		// set start/end
 		if !hasPrev {
 			ts.StartedAt = t
 			hasPrev = true
-			prevLon, prevLat, prevT = lon, lat, t
+			prevLon, prevLat, prevMs = lon, lat, ms
 			// first point SOG unknown if not provided
 			if sog.Valid {
 				ts.SOGms = append(ts.SOGms, sog.Float64)
@@ -77,14 +145,14 @@ func (s *Store) ComputeTrackStats(ctx context.Context, id int64) (*TrackStats, e
 			}
 		} else {
 			// accumulate distance
-			seg := haversineMeters(prevLon, prevLat, lon, lat)
+			seg := geo.HaversineM(prevLon, prevLat, lon, lat)
 			ts.DistanceM += seg
 
 			// compute SOG if missing
 			if sog.Valid {
 				ts.SOGms = append(ts.SOGms, sog.Float64)
 			} else {
-				dt := float64(t - prevT)
+				dt := float64(ms-prevMs) / 1000
 				if dt > 0 {
 					ts.SOGms = append(ts.SOGms, seg/dt) // m/s
 				} else {
@@ -92,7 +160,7 @@ func (s *Store) ComputeTrackStats(ctx context.Context, id int64) (*TrackStats, e
 				}
 			}
 
-			prevLon, prevLat, prevT = lon, lat, t
+			prevLon, prevLat, prevMs = lon, lat, ms
 		}
 		ts.EndedAt = t
 
@@ -111,6 +179,14 @@ func (s *Store) ComputeTrackStats(ctx context.Context, id int64) (*TrackStats, e
 		}
 
 		ts.Coords = append(ts.Coords, [2]float64{lon, lat})
+		ts.Times = append(ts.Times, t)
+		ts.TimesMs = append(ts.TimesMs, ms)
+		if cog.Valid {
+			ts.COGrad = append(ts.COGrad, cog.Float64)
+		} else {
+			ts.COGrad = append(ts.COGrad, math.NaN())
+		}
+		ts.Src = append(ts.Src, src.String)
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -120,5 +196,7 @@ func (s *Store) ComputeTrackStats(ctx context.Context, id int64) (*TrackStats, e
 		ts.MinX, ts.MaxX = 0, 0
 		ts.MinY, ts.MaxY = 0, 0
 	}
+	ts.Segments = computeSegments(ts)
+	ts.Motion = computeMotion(ts, ts.Segments)
 	return ts, nil
 }
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+
+	"wakemap/internal/db"
+)
+
+const insertPositionSQL = `
+	INSERT INTO positions (track_id, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual)
+	VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+// EachTrackPosition streams a track's positions in time order without
+// buffering the whole track in memory.
+func (s *Store) EachTrackPosition(ctx context.Context, trackID int64, fn func(p *db.Position) error) error {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, track_id, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual
+		FROM positions
+		WHERE track_id = ?
+		ORDER BY t_ms ASC, id ASC
+	`, trackID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var p db.Position
+	for rows.Next() {
+		if err := rows.Scan(&p.ID, &p.TrackID, &p.T, &p.TMs, &p.Lon, &p.Lat, &p.SogMs, &p.CogRad, &p.Src, &p.Qual); err != nil {
+			return err
+		}
+		if err := fn(&p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// TrackPositionsAfter returns up to limit of a track's positions after
+// the fix (afterMs, afterID) in time order. With afterID 0 it starts at
+// afterMs itself.
+func (s *Store) TrackPositionsAfter(ctx context.Context, trackID, afterMs, afterID int64, limit int) ([]db.Position, error) {
+	return s.positionsPage(ctx, trackID, `t_ms >= ?2 AND (t_ms > ?2 OR id > ?3) ORDER BY t_ms ASC, id ASC`, afterMs, afterID, limit)
+}
+
+// TrackPositionsBefore is TrackPositionsAfter backwards: up to limit
+// positions before the fix (beforeMs, beforeID), latest first. With
+// beforeID math.MaxInt64 it starts at beforeMs itself.
+func (s *Store) TrackPositionsBefore(ctx context.Context, trackID, beforeMs, beforeID int64, limit int) ([]db.Position, error) {
+	return s.positionsPage(ctx, trackID, `t_ms <= ?2 AND (t_ms < ?2 OR id < ?3) ORDER BY t_ms DESC, id DESC`, beforeMs, beforeID, limit)
+}
+
+func (s *Store) positionsPage(ctx context.Context, trackID int64, cond string, ms, id int64, limit int) ([]db.Position, error) {
+	rows, err := s.DB.QueryContext(ctx, `
+		SELECT id, track_id, t, t_ms, lon, lat, sog_ms, cog_rad, src, qual
+		FROM positions
+		WHERE track_id = ?1 AND `+cond+`
+		LIMIT ?4
+	`, trackID, ms, id, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ps := make([]db.Position, 0, limit)
+	for rows.Next() {
+		var p db.Position
+		if err := rows.Scan(&p.ID, &p.TrackID, &p.T, &p.TMs, &p.Lon, &p.Lat, &p.SogMs, &p.CogRad, &p.Src, &p.Qual); err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	}
+	return ps, rows.Err()
+}
+
+// TrackExists reports whether a live (not soft-deleted) track exists.
+func (s *Store) TrackExists(ctx context.Context, trackID int64) (bool, error) {
+	var one int
+	err := s.DB.QueryRowContext(ctx, `SELECT 1 FROM tracks WHERE id = ? AND deleted_at IS NULL`, trackID).Scan(&one)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ImportTrack creates a track and fills it from next until next returns
+// io.EOF. Everything happens in one transaction, so a bad row leaves no
+// partial track behind. Derived fields are computed from the data; meta
+// supplies the name, notes, vessel and whether the name is a placeholder
+// until the route resolves to places.
+func (s *Store) ImportTrack(ctx context.Context, meta db.Track, next func() (db.Position, error)) (id int64, n int, err error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	if id, err = newTrackTx(ctx, tx, meta); err != nil {
+		return 0, 0, err
+	}
+
+	stmt, err := tx.PrepareContext(ctx, insertPositionSQL)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer stmt.Close()
+
+	b := newSummaryBuilder(id)
+	for {
+		p, nerr := next()
+		if errors.Is(nerr, io.EOF) {
+			break
+		}
+		if nerr != nil {
+			return 0, n, nerr
+		}
+		FixTimes(&p)
+		if _, err = stmt.ExecContext(ctx, id, p.T, p.TMs, p.Lon, p.Lat, p.SogMs, p.CogRad, p.Src, p.Qual); err != nil {
+			return 0, n, err
+		}
+		b.add(p.TMs, p.Lon, p.Lat)
+		n++
+	}
+	if n == 0 {
+		return 0, 0, errors.New("no positions to import")
+	}
+
+	if b.ordered {
+		err = b.saveTx(ctx, tx)
+	} else {
+		err = rebuildSummaryTx(ctx, tx, id)
+	}
+	if err != nil {
+		return 0, n, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, n, err
+	}
+	return id, n, nil
+}
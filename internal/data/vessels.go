@@ -0,0 +1,177 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"wakemap/internal/db"
+)
+
+// ErrDuplicateMMSI is returned when another vessel already has the MMSI.
+var ErrDuplicateMMSI = errors.New("mmsi already belongs to another vessel")
+
+const vesselCols = `id, name, mmsi, callsign, loa_m, beam_m, draft_m, air_draft_m, polar, engine, created_at, updated_at`
+
+func scanVessel(sc interface{ Scan(...any) error }) (db.Vessel, error) {
+	var v db.Vessel
+	err := sc.Scan(&v.ID, &v.Name, &v.Mmsi, &v.Callsign, &v.LoaM, &v.BeamM, &v.DraftM, &v.AirDraftM,
+		&v.Polar, &v.Engine, &v.CreatedAt, &v.UpdatedAt)
+	return v, err
+}
+
+// Vessels lists every vessel by name, with how many live tracks each has.
+func (s *Store) Vessels(ctx context.Context) ([]db.Vessel, map[int64]int, error) {
+	rows, err := s.DB.QueryContext(ctx, `SELECT `+vesselCols+` FROM vessels ORDER BY name COLLATE NOCASE, id`)
+	if err != nil {
+		return nil, nil, err
+	}
+	var out []db.Vessel
+	for rows.Next() {
+		v, err := scanVessel(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		out = append(out, v)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	counts := map[int64]int{}
+	rows, err = s.DB.QueryContext(ctx, `
+		SELECT vessel_id, COUNT(*) FROM tracks
+		WHERE deleted_at IS NULL AND vessel_id IS NOT NULL
+		GROUP BY vessel_id
+	`)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var n int
+		if err := rows.Scan(&id, &n); err != nil {
+			return nil, nil, err
+		}
+		counts[id] = n
+	}
+	return out, counts, rows.Err()
+}
+
+// Vessel loads one vessel.
+func (s *Store) Vessel(ctx context.Context, id int64) (db.Vessel, error) {
+	v, err := scanVessel(s.DB.QueryRowContext(ctx, `SELECT `+vesselCols+` FROM vessels WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return v, ErrNotFound
+	}
+	return v, err
+}
+
+// SaveVessel creates a vessel (v.ID == 0) or overwrites one.
+func (s *Store) SaveVessel(ctx context.Context, v db.Vessel) (db.Vessel, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return v, err
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	if v.Mmsi.Valid {
+		var other int64
+		err := tx.QueryRowContext(ctx, `SELECT id FROM vessels WHERE mmsi = ? AND id <> ?`, v.Mmsi.String, v.ID).Scan(&other)
+		if err == nil {
+			return v, fmt.Errorf("%w (vessel %d)", ErrDuplicateMMSI, other)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return v, err
+		}
+	}
+
+	now := time.Now().Unix()
+	v.UpdatedAt = now
+	if v.ID == 0 {
+		v.CreatedAt = now
+		res, err := tx.ExecContext(ctx, `
+			INSERT INTO vessels (name, mmsi, callsign, loa_m, beam_m, draft_m, air_draft_m, polar, engine, created_at, updated_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		`, v.Name, v.Mmsi, v.Callsign, v.LoaM, v.BeamM, v.DraftM, v.AirDraftM, v.Polar, v.Engine, v.CreatedAt, v.UpdatedAt)
+		if err != nil {
+			return v, err
+		}
+		if v.ID, err = res.LastInsertId(); err != nil {
+			return v, err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, `
+			UPDATE vessels SET name = ?, mmsi = ?, callsign = ?, loa_m = ?, beam_m = ?, draft_m = ?, air_draft_m = ?,
+			       polar = ?, engine = ?, updated_at = ?
+			WHERE id = ?
+		`, v.Name, v.Mmsi, v.Callsign, v.LoaM, v.BeamM, v.DraftM, v.AirDraftM, v.Polar, v.Engine, v.UpdatedAt, v.ID)
+		if err != nil {
+			return v, err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return v, ErrNotFound
+		}
+		if err := tx.QueryRowContext(ctx, `SELECT created_at FROM vessels WHERE id = ?`, v.ID).Scan(&v.CreatedAt); err != nil {
+			return v, err
+		}
+	}
+	return v, tx.Commit()
+}
+
+// DeleteVessel removes a vessel. Its tracks and log entries are kept but
+// no longer belong to any vessel.
+func (s *Store) DeleteVessel(ctx context.Context, id int64) error {
+	res, err := s.DB.ExecContext(ctx, `DELETE FROM vessels WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// SetTrackVessel moves a live track to another vessel (0 = none).
+// Vessel-filtered caches key on the track's vessel, so nothing else needs
+// re-deriving.
+func (s *Store) SetTrackVessel(ctx context.Context, trackID, vesselID int64) error {
+	if vesselID != 0 {
+		if _, err := s.Vessel(ctx, vesselID); err != nil {
+			return err
+		}
+	}
+	res, err := s.DB.ExecContext(ctx, `UPDATE tracks SET vessel_id = ? WHERE id = ? AND deleted_at IS NULL`,
+		sql.NullInt64{Int64: vesselID, Valid: vesselID != 0}, trackID)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// DefaultVesselID is where tracks go when nothing says otherwise: the only
+// vessel, if there is exactly one; else 0.
+func (s *Store) DefaultVesselID(ctx context.Context) (int64, error) {
+	var n, id int64
+	err := s.DB.QueryRowContext(ctx, `SELECT COUNT(*), COALESCE(MIN(id), 0) FROM vessels`).Scan(&n, &id)
+	if err != nil || n != 1 {
+		return 0, err
+	}
+	return id, nil
+}
+
+// vesselFilter is the SQL condition restricting col to one vessel, or ""
+// when vesselID is 0 (any vessel).
+func vesselFilter(col string, vesselID int64) (string, []any) {
+	if vesselID == 0 {
+		return "", nil
+	}
+	return " AND " + col + " = ?", []any{vesselID}
+}
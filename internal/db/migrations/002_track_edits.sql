@@ -0,0 +1,22 @@
+-- Soft-delete for tracks plus an edit log so split/merge/trim can be undone.
+-- Edits never modify a track in place: they copy positions into new output
+-- tracks and soft-delete the inputs. Undo flips that around.
+
+ALTER TABLE tracks ADD COLUMN deleted_at INTEGER;  -- epoch seconds; NULL = live
+
+CREATE TABLE IF NOT EXISTS track_edits (
+  id         INTEGER PRIMARY KEY,
+  op         TEXT NOT NULL CHECK (op IN ('split','merge','trim')),
+  params     TEXT,                 -- JSON, for display
+  created_at INTEGER NOT NULL,     -- epoch seconds
+  undone_at  INTEGER
+);
+
+CREATE TABLE IF NOT EXISTS track_edit_members (
+  edit_id  INTEGER NOT NULL REFERENCES track_edits(id) ON DELETE CASCADE,
+  track_id INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  role     TEXT NOT NULL CHECK (role IN ('input','output')),
+  PRIMARY KEY (edit_id, track_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS idx_track_edit_members_track ON track_edit_members(track_id);
@@ -0,0 +1,20 @@
+-- Stationary periods (anchored, moored, drifting) detected within tracks,
+-- persisted so the cross-track "places we've stayed" layer doesn't rescan
+-- every position. Rows are re-derived whenever the track summary version
+-- moves past stops_version.
+ALTER TABLE track_summaries ADD COLUMN version INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE track_summaries ADD COLUMN stops_version INTEGER;
+
+CREATE TABLE IF NOT EXISTS track_stops (
+  id         INTEGER PRIMARY KEY,
+  track_id   INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  kind       TEXT NOT NULL CHECK (kind IN ('anchored','moored','drifting')),
+  started_at INTEGER NOT NULL,     -- epoch seconds
+  ended_at   INTEGER NOT NULL,
+  lon        REAL NOT NULL,        -- centroid
+  lat        REAL NOT NULL,
+  radius_m   REAL NOT NULL,
+  points     INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_track_stops_track ON track_stops(track_id, started_at);
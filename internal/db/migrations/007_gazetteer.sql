@@ -0,0 +1,49 @@
+-- Offline gazetteer for reverse geocoding, loaded from a GeoNames dump or an
+-- OSM place extract with `wakemap gazetteer-import`. Each load replaces the
+-- rows of its source.
+CREATE TABLE IF NOT EXISTS gazetteer (
+  id         INTEGER PRIMARY KEY,
+  source     TEXT NOT NULL,        -- 'geonames', 'osm', ...
+  source_id  TEXT NOT NULL,
+  name       TEXT NOT NULL,
+  kind       TEXT NOT NULL,        -- city, town, village, harbour, bay, ...
+  country    TEXT,
+  admin1     TEXT,
+  population INTEGER NOT NULL DEFAULT 0,
+  lon        REAL NOT NULL,
+  lat        REAL NOT NULL,
+  UNIQUE (source, source_id)
+);
+
+CREATE VIRTUAL TABLE IF NOT EXISTS gazetteer_rtree USING rtree(
+  id, minX, maxX, minY, maxY
+);
+
+CREATE TRIGGER IF NOT EXISTS gazetteer_rtree_ins
+AFTER INSERT ON gazetteer BEGIN
+  INSERT OR REPLACE INTO gazetteer_rtree(id,minX,maxX,minY,maxY)
+  VALUES (new.id, new.lon, new.lon, new.lat, new.lat);
+END;
+
+CREATE TRIGGER IF NOT EXISTS gazetteer_rtree_upd
+AFTER UPDATE OF lon,lat ON gazetteer BEGIN
+  INSERT OR REPLACE INTO gazetteer_rtree(id,minX,maxX,minY,maxY)
+  VALUES (new.id, new.lon, new.lon, new.lat, new.lat);
+END;
+
+CREATE TRIGGER IF NOT EXISTS gazetteer_rtree_del
+AFTER DELETE ON gazetteer BEGIN
+  DELETE FROM gazetteer_rtree WHERE id = old.id;
+END;
+
+-- Where each track started and finished, resolved against the gazetteer.
+-- Re-derived whenever the summary version moves past place_version; a
+-- gazetteer load clears place_version (and log_version, as departure and
+-- arrival log entries name places) everywhere.
+ALTER TABLE track_summaries ADD COLUMN place_version INTEGER;
+ALTER TABLE track_summaries ADD COLUMN from_place TEXT;
+ALTER TABLE track_summaries ADD COLUMN to_place TEXT;
+
+-- Tracks created without a name are named after their route once the
+-- places resolve, and renamed as the route grows.
+ALTER TABLE tracks ADD COLUMN name_auto INTEGER NOT NULL DEFAULT 0;
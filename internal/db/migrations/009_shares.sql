@@ -0,0 +1,25 @@
+-- Server-wide values that aren't configuration, e.g. the key share links
+-- are signed with.
+CREATE TABLE IF NOT EXISTS settings (
+  key   TEXT PRIMARY KEY,
+  value BLOB NOT NULL
+);
+
+-- Read-only public links to chosen tracks. The token is signed, so it can
+-- be checked before touching this table; revoking or expiring a share
+-- kills every copy of its link.
+CREATE TABLE IF NOT EXISTS shares (
+  id         INTEGER PRIMARY KEY,
+  label      TEXT,
+  created_at INTEGER NOT NULL,
+  expires_at INTEGER,                   -- NULL = never
+  revoked_at INTEGER,
+  delay_s    INTEGER NOT NULL DEFAULT 0, -- only fixes at least this old are shown
+  hide       TEXT                        -- JSON [{"lon", "lat", "radius_m"}] hidden on top of redaction zones
+);
+
+CREATE TABLE IF NOT EXISTS share_tracks (
+  share_id INTEGER NOT NULL REFERENCES shares(id) ON DELETE CASCADE,
+  track_id INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  PRIMARY KEY (share_id, track_id)
+);
@@ -0,0 +1,37 @@
+-- Per-track figures behind the season and lifetime statistics, so totals
+-- over thousands of tracks read a few small tables instead of positions.
+-- Rows are re-derived whenever the track summary version moves past
+-- cruise_version.
+ALTER TABLE track_summaries ADD COLUMN cruise_version INTEGER;
+ALTER TABLE track_summaries ADD COLUMN best_run_m REAL;      -- most distance made good in any 24 h of the track
+ALTER TABLE track_summaries ADD COLUMN best_run_at INTEGER;  -- epoch seconds the best run started
+
+-- Distance and time underway (anything but anchored or moored) per UTC
+-- day, so tracks running past midnight or a month end split correctly.
+CREATE TABLE IF NOT EXISTS track_days (
+  track_id   INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  day        INTEGER NOT NULL,     -- epoch seconds / 86400, UTC
+  distance_m REAL NOT NULL,
+  underway_s INTEGER NOT NULL,
+  PRIMARY KEY (track_id, day)
+) WITHOUT ROWID;
+
+CREATE INDEX IF NOT EXISTS idx_track_days_day ON track_days(day);
+
+-- Departure to arrival. A track is one passage unless it stays somewhere
+-- for a couple of hours, which ends one passage and starts the next.
+CREATE TABLE IF NOT EXISTS passages (
+  id         INTEGER PRIMARY KEY,
+  track_id   INTEGER NOT NULL REFERENCES tracks(id) ON DELETE CASCADE,
+  started_at INTEGER NOT NULL,     -- epoch seconds
+  ended_at   INTEGER NOT NULL,
+  distance_m REAL NOT NULL,
+  underway_s INTEGER NOT NULL,
+  from_lon   REAL NOT NULL,
+  from_lat   REAL NOT NULL,
+  to_lon     REAL NOT NULL,
+  to_lat     REAL NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_passages_started ON passages(started_at);
+CREATE INDEX IF NOT EXISTS idx_passages_track ON passages(track_id);
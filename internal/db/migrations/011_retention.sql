@@ -0,0 +1,5 @@
+-- Old tracks are thinned by the retention job. The policy a track was
+-- thinned with is kept so a stricter policy thins it again and an
+-- unchanged one leaves it alone.
+ALTER TABLE tracks ADD COLUMN downsampled_at INTEGER;   -- epoch seconds
+ALTER TABLE tracks ADD COLUMN downsample_policy TEXT;   -- e.g. "30s/0m/10m"
@@ -18,13 +18,149 @@ type Position struct {
 	CogRad  sql.NullFloat64 `json:"cog_rad"`
 	Src     sql.NullString  `json:"src"`
 	Qual    sql.NullInt64   `json:"qual"`
+	TMs     int64           `json:"t_ms"`
 }
 
 type Track struct {
+	ID          int64           `json:"id"`
+	Name        string          `json:"name"`
+	StartedAt   int64           `json:"started_at"`
+	EndedAt     sql.NullInt64   `json:"ended_at"`
+	DistanceM   sql.NullFloat64 `json:"distance_m"`
+	Notes       sql.NullString  `json:"notes"`
+	DeletedAt   sql.NullInt64   `json:"deleted_at"`
+	NameAuto    int64           `json:"name_auto"`
+	VesselID    sql.NullInt64   `json:"vessel_id"`
+	StartedAtMs sql.NullInt64   `json:"started_at_ms"`
+	EndedAtMs   sql.NullInt64   `json:"ended_at_ms"`
+}
+
+type TrackEdit struct {
+	ID        int64          `json:"id"`
+	Op        string         `json:"op"`
+	Params    sql.NullString `json:"params"`
+	CreatedAt int64          `json:"created_at"`
+	UndoneAt  sql.NullInt64  `json:"undone_at"`
+}
+
+type TrackEditMember struct {
+	EditID  int64  `json:"edit_id"`
+	TrackID int64  `json:"track_id"`
+	Role    string `json:"role"`
+}
+
+type TrackStop struct {
+	ID        int64   `json:"id"`
+	TrackID   int64   `json:"track_id"`
+	Kind      string  `json:"kind"`
+	StartedAt int64   `json:"started_at"`
+	EndedAt   int64   `json:"ended_at"`
+	Lon       float64 `json:"lon"`
+	Lat       float64 `json:"lat"`
+	RadiusM   float64 `json:"radius_m"`
+	Points    int64   `json:"points"`
+}
+
+type Passage struct {
+	ID        int64   `json:"id"`
+	TrackID   int64   `json:"track_id"`
+	StartedAt int64   `json:"started_at"`
+	EndedAt   int64   `json:"ended_at"`
+	DistanceM float64 `json:"distance_m"`
+	UnderwayS int64   `json:"underway_s"`
+	FromLon   float64 `json:"from_lon"`
+	FromLat   float64 `json:"from_lat"`
+	ToLon     float64 `json:"to_lon"`
+	ToLat     float64 `json:"to_lat"`
+}
+
+type TrackSummary struct {
+	TrackID      int64           `json:"track_id"`
+	Points       int64           `json:"points"`
+	DistanceM    float64         `json:"distance_m"`
+	StartedAt    sql.NullInt64   `json:"started_at"`
+	EndedAt      sql.NullInt64   `json:"ended_at"`
+	MinX         sql.NullFloat64 `json:"min_x"`
+	MinY         sql.NullFloat64 `json:"min_y"`
+	MaxX         sql.NullFloat64 `json:"max_x"`
+	MaxY         sql.NullFloat64 `json:"max_y"`
+	LastLon      sql.NullFloat64 `json:"last_lon"`
+	LastLat      sql.NullFloat64 `json:"last_lat"`
+	Stale        int64           `json:"stale"`
+	UpdatedAt    int64           `json:"updated_at"`
+	Version      int64           `json:"version"`
+	StopsVersion sql.NullInt64   `json:"stops_version"`
+	HeatVersion  sql.NullInt64   `json:"heat_version"`
+	LogVersion   sql.NullInt64   `json:"log_version"`
+	PlaceVersion sql.NullInt64   `json:"place_version"`
+	FromPlace    sql.NullString  `json:"from_place"`
+	ToPlace      sql.NullString  `json:"to_place"`
+	StartedAtMs  sql.NullInt64   `json:"started_at_ms"`
+	EndedAtMs    sql.NullInt64   `json:"ended_at_ms"`
+	FromLon      sql.NullFloat64 `json:"from_lon"`
+	FromLat      sql.NullFloat64 `json:"from_lat"`
+	ToLon        sql.NullFloat64 `json:"to_lon"`
+	ToLat        sql.NullFloat64 `json:"to_lat"`
+}
+
+type LogEntry struct {
+	ID        int64           `json:"id"`
+	TrackID   sql.NullInt64   `json:"track_id"`
+	T         int64           `json:"t"`
+	Auto      int64           `json:"auto"`
+	Kind      string          `json:"kind"`
+	Text      string          `json:"text"`
+	Lon       sql.NullFloat64 `json:"lon"`
+	Lat       sql.NullFloat64 `json:"lat"`
+	SogMs     sql.NullFloat64 `json:"sog_ms"`
+	CogRad    sql.NullFloat64 `json:"cog_rad"`
+	CreatedAt int64           `json:"created_at"`
+	VesselID  sql.NullInt64   `json:"vessel_id"`
+}
+
+type HeatCell struct {
+	TrackID int64 `json:"track_id"`
+	Level   int64 `json:"level"`
+	Cx      int64 `json:"cx"`
+	Cy      int64 `json:"cy"`
+	Day     int64 `json:"day"`
+	DwellS  int64 `json:"dwell_s"`
+}
+
+type Gazetteer struct {
+	ID         int64          `json:"id"`
+	Source     string         `json:"source"`
+	SourceID   string         `json:"source_id"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Country    sql.NullString `json:"country"`
+	Admin1     sql.NullString `json:"admin1"`
+	Population int64          `json:"population"`
+	Lon        float64        `json:"lon"`
+	Lat        float64        `json:"lat"`
+}
+
+type Vessel struct {
 	ID        int64           `json:"id"`
 	Name      string          `json:"name"`
-	StartedAt int64           `json:"started_at"`
-	EndedAt   sql.NullInt64   `json:"ended_at"`
-	DistanceM sql.NullFloat64 `json:"distance_m"`
-	Notes     sql.NullString  `json:"notes"`
+	Mmsi      sql.NullString  `json:"mmsi"`
+	Callsign  sql.NullString  `json:"callsign"`
+	LoaM      sql.NullFloat64 `json:"loa_m"`
+	BeamM     sql.NullFloat64 `json:"beam_m"`
+	DraftM    sql.NullFloat64 `json:"draft_m"`
+	AirDraftM sql.NullFloat64 `json:"air_draft_m"`
+	Polar     sql.NullString  `json:"polar"`
+	Engine    sql.NullString  `json:"engine"`
+	CreatedAt int64           `json:"created_at"`
+	UpdatedAt int64           `json:"updated_at"`
+}
+
+type Share struct {
+	ID        int64          `json:"id"`
+	Label     sql.NullString `json:"label"`
+	CreatedAt int64          `json:"created_at"`
+	ExpiresAt sql.NullInt64  `json:"expires_at"`
+	RevokedAt sql.NullInt64  `json:"revoked_at"`
+	DelayS    int64          `json:"delay_s"`
+	Hide      sql.NullString `json:"hide"`
 }
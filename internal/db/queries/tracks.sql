@@ -5,8 +5,10 @@ SELECT
   started_at,
   ended_at,
   distance_m,
-  notes
+  notes,
+  deleted_at
 FROM tracks
+WHERE deleted_at IS NULL
 ORDER BY started_at DESC
 LIMIT ?;
 
@@ -29,7 +31,8 @@ SELECT
   sog_ms,
   cog_rad,
   src,
-  qual
+  qual,
+  t_ms
 FROM positions
 WHERE track_id = ?
-ORDER BY t ASC;
\ No newline at end of file
+ORDER BY t_ms ASC, id ASC;
\ No newline at end of file
@@ -16,8 +16,10 @@ SELECT
   started_at,
   ended_at,
   distance_m,
-  notes
+  notes,
+  deleted_at
 FROM tracks
+WHERE deleted_at IS NULL
 ORDER BY started_at DESC
 LIMIT ?
 `
@@ -38,6 +40,7 @@ func (q *Queries) ListTracks(ctx context.Context, limit int64) ([]Track, error)
 			&i.EndedAt,
 			&i.DistanceM,
 			&i.Notes,
+			&i.DeletedAt,
 		); err != nil {
 			return nil, err
 		}
@@ -91,10 +94,11 @@ SELECT
   sog_ms,
   cog_rad,
   src,
-  qual
+  qual,
+  t_ms
 FROM positions
 WHERE track_id = ?
-ORDER BY t ASC
+ORDER BY t_ms ASC, id ASC
 `
 
 func (q *Queries) TrackPositions(ctx context.Context, trackID int64) ([]Position, error) {
@@ -116,6 +120,7 @@ func (q *Queries) TrackPositions(ctx context.Context, trackID int64) ([]Position
 			&i.CogRad,
 			&i.Src,
 			&i.Qual,
+			&i.TMs,
 		); err != nil {
 			return nil, err
 		}
@@ -0,0 +1,307 @@
+package export
+
+import (
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+)
+
+const msToKnots = 1.943844492
+
+// CSVHeader mirrors db.Position with unit-explicit column names. t_iso
+// carries milliseconds only when there are any; t_epoch_ms went on the end
+// so existing readers of the earlier columns are unaffected.
+var CSVHeader = []string{"t_iso", "t_epoch", "lon", "lat", "sog_kn", "sog_ms", "cog_deg", "src", "qual", "t_epoch_ms"}
+
+// timeRFC3339Milli is RFC3339 with milliseconds when they aren't zero.
+const timeRFC3339Milli = "2006-01-02T15:04:05.999Z07:00"
+
+// CSVWriter writes positions one row at a time; csv.Writer's internal
+// buffer is flushed to w as it fills, so tracks are never held in memory.
+type CSVWriter struct {
+	w   *csv.Writer
+	rec []string
+}
+
+func NewCSVWriter(w io.Writer) (*CSVWriter, error) {
+	cw := &CSVWriter{w: csv.NewWriter(w), rec: make([]string, len(CSVHeader))}
+	if err := cw.w.Write(CSVHeader); err != nil {
+		return nil, err
+	}
+	return cw, nil
+}
+
+func (cw *CSVWriter) Write(p *db.Position) error {
+	r := cw.rec
+	r[0] = data.UnixMilliToTime(p.TMs).Format(timeRFC3339Milli)
+	r[1] = strconv.FormatInt(p.T, 10)
+	r[2] = strconv.FormatFloat(p.Lon, 'f', 7, 64)
+	r[3] = strconv.FormatFloat(p.Lat, 'f', 7, 64)
+	r[4], r[5] = "", ""
+	if p.SogMs.Valid {
+		r[4] = strconv.FormatFloat(p.SogMs.Float64*msToKnots, 'f', 2, 64)
+		r[5] = strconv.FormatFloat(p.SogMs.Float64, 'f', 3, 64)
+	}
+	r[6] = ""
+	if p.CogRad.Valid {
+		r[6] = strconv.FormatFloat(math.Mod(p.CogRad.Float64*180/math.Pi+360, 360), 'f', 1, 64)
+	}
+	r[7] = p.Src.String
+	r[8] = ""
+	if p.Qual.Valid {
+		r[8] = strconv.FormatInt(p.Qual.Int64, 10)
+	}
+	r[9] = strconv.FormatInt(p.TMs, 10)
+	return cw.w.Write(r)
+}
+
+func (cw *CSVWriter) Flush() error {
+	cw.w.Flush()
+	return cw.w.Error()
+}
+
+// CSVMapping names the source column for each position field. Empty column
+// names fall back to a list of common aliases (including our own export
+// headers). Units default to knots and degrees unless the matched alias
+// says otherwise (e.g. "sog_ms").
+type CSVMapping struct {
+	Time, Lon, Lat, SOG, COG, Src, Qual string
+
+	TimeFormat string // "auto" (default), "epoch", "epoch_ms" or a Go layout
+	SOGUnit    string // "kn" (default), "ms" or "kmh"
+	COGUnit    string // "deg" (default) or "rad"
+}
+
+type csvAlias struct {
+	name string
+	unit string
+}
+
+var csvAliases = map[string][]csvAlias{
+	"time": {{"t_epoch_ms", "epoch_ms"}, {"t_epoch", "epoch"}, {"t_iso", ""}, {"t", ""}, {"time", ""}, {"timestamp", ""}, {"datetime", ""}, {"utc", ""}, {"date_time", ""}},
+	"lon":  {{"lon", ""}, {"lng", ""}, {"long", ""}, {"longitude", ""}, {"x", ""}},
+	"lat":  {{"lat", ""}, {"latitude", ""}, {"y", ""}},
+	"sog":  {{"sog_ms", "ms"}, {"sog_kn", "kn"}, {"sog", ""}, {"speed", ""}, {"speed_kn", "kn"}, {"speed_kmh", "kmh"}},
+	"cog":  {{"cog_deg", "deg"}, {"cog_rad", "rad"}, {"cog", ""}, {"course", ""}, {"heading", ""}},
+	"src":  {{"src", ""}, {"source", ""}},
+	"qual": {{"qual", ""}, {"quality", ""}, {"fix", ""}},
+}
+
+// CSVReader turns rows from another logger into positions.
+type CSVReader struct {
+	r    *csv.Reader
+	m    CSVMapping
+	line int
+
+	iTime, iLon, iLat, iSOG, iCOG, iSrc, iQual int
+}
+
+func NewCSVReader(r io.Reader, m CSVMapping) (*CSVReader, error) {
+	cr := &CSVReader{r: csv.NewReader(r), m: m}
+	cr.r.FieldsPerRecord = -1
+	cr.r.TrimLeadingSpace = true
+
+	header, err := cr.r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("read header: %w", err)
+	}
+	cr.line = 1
+	idx := make(map[string]int, len(header))
+	for i, h := range header {
+		idx[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))] = i
+	}
+
+	resolve := func(field, explicit string, unit *string) (int, error) {
+		if explicit != "" {
+			i, ok := idx[strings.ToLower(explicit)]
+			if !ok {
+				return -1, fmt.Errorf("column %q (for %s) not in header", explicit, field)
+			}
+			return i, nil
+		}
+		for _, a := range csvAliases[field] {
+			if i, ok := idx[a.name]; ok {
+				if unit != nil && *unit == "" && a.unit != "" {
+					*unit = a.unit
+				}
+				return i, nil
+			}
+		}
+		return -1, nil
+	}
+
+	if cr.iTime, err = resolve("time", m.Time, &cr.m.TimeFormat); err != nil {
+		return nil, err
+	}
+	if cr.iLon, err = resolve("lon", m.Lon, nil); err != nil {
+		return nil, err
+	}
+	if cr.iLat, err = resolve("lat", m.Lat, nil); err != nil {
+		return nil, err
+	}
+	if cr.iSOG, err = resolve("sog", m.SOG, &cr.m.SOGUnit); err != nil {
+		return nil, err
+	}
+	if cr.iCOG, err = resolve("cog", m.COG, &cr.m.COGUnit); err != nil {
+		return nil, err
+	}
+	if cr.iSrc, err = resolve("src", m.Src, nil); err != nil {
+		return nil, err
+	}
+	if cr.iQual, err = resolve("qual", m.Qual, nil); err != nil {
+		return nil, err
+	}
+	if cr.iTime < 0 || cr.iLon < 0 || cr.iLat < 0 {
+		return nil, fmt.Errorf("time, lon and lat columns are required (header: %s)", strings.Join(header, ","))
+	}
+	return cr, nil
+}
+
+// Next returns the next position, or io.EOF when the input is exhausted.
+// Blank lines are skipped; malformed rows fail with their line number.
+func (cr *CSVReader) Next() (db.Position, error) {
+	for {
+		rec, err := cr.r.Read()
+		if err != nil {
+			return db.Position{}, err
+		}
+		cr.line++
+		if len(rec) == 1 && strings.TrimSpace(rec[0]) == "" {
+			continue
+		}
+		p, err := cr.parse(rec)
+		if err != nil {
+			return db.Position{}, fmt.Errorf("line %d: %w", cr.line, err)
+		}
+		return p, nil
+	}
+}
+
+func (cr *CSVReader) parse(rec []string) (db.Position, error) {
+	var p db.Position
+	field := func(i int) string {
+		if i < 0 || i >= len(rec) {
+			return ""
+		}
+		return strings.TrimSpace(rec[i])
+	}
+
+	ms, err := parseCSVTime(field(cr.iTime), cr.m.TimeFormat)
+	if err != nil {
+		return p, err
+	}
+	p.TMs = ms
+	data.FixTimes(&p)
+
+	if p.Lon, err = parseFinite(field(cr.iLon)); err != nil {
+		return p, fmt.Errorf("bad lon %q", field(cr.iLon))
+	}
+	if p.Lat, err = parseFinite(field(cr.iLat)); err != nil {
+		return p, fmt.Errorf("bad lat %q", field(cr.iLat))
+	}
+	if p.Lon < -180 || p.Lon > 180 || p.Lat < -90 || p.Lat > 90 {
+		return p, fmt.Errorf("lon/lat out of range (%v, %v)", p.Lon, p.Lat)
+	}
+
+	if s := field(cr.iSOG); s != "" {
+		v, err := parseFinite(s)
+		if err != nil {
+			return p, fmt.Errorf("bad sog %q", s)
+		}
+		switch cr.m.SOGUnit {
+		case "ms":
+		case "kmh":
+			v /= 3.6
+		default:
+			v /= msToKnots
+		}
+		p.SogMs = sql.NullFloat64{Float64: v, Valid: true}
+	}
+	if s := field(cr.iCOG); s != "" {
+		v, err := parseFinite(s)
+		if err != nil {
+			return p, fmt.Errorf("bad cog %q", s)
+		}
+		if cr.m.COGUnit != "rad" {
+			v = v * math.Pi / 180
+		}
+		p.CogRad = sql.NullFloat64{Float64: v, Valid: true}
+	}
+	if s := field(cr.iSrc); s != "" {
+		p.Src = sql.NullString{String: s, Valid: true}
+	}
+	if s := field(cr.iQual); s != "" {
+		v, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return p, fmt.Errorf("bad qual %q", s)
+		}
+		p.Qual = sql.NullInt64{Int64: v, Valid: true}
+	}
+	return p, nil
+}
+
+// parseFinite is strconv.ParseFloat refusing NaN and ±Inf, which it
+// accepts and which would slip past every range check.
+func parseFinite(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		err = fmt.Errorf("%q is not a finite number", s)
+	}
+	return f, err
+}
+
+var csvTimeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05",
+	"2006/01/02 15:04:05",
+}
+
+// parseCSVTime returns epoch milliseconds. Zone-less layouts are read as UTC.
+func parseCSVTime(s, format string) (int64, error) {
+	if s == "" {
+		return 0, fmt.Errorf("missing time")
+	}
+	switch format {
+	case "", "auto":
+		if f, err := parseFinite(s); err == nil {
+			if f > 1e11 { // clearly milliseconds
+				return int64(math.Floor(f)), nil
+			}
+			return int64(math.Floor(f * 1000)), nil
+		}
+		for _, l := range csvTimeLayouts {
+			if t, err := time.Parse(l, s); err == nil {
+				return t.UnixMilli(), nil
+			}
+		}
+		return 0, fmt.Errorf("unrecognised time %q", s)
+	case "epoch":
+		f, err := parseFinite(s)
+		if err != nil {
+			return 0, fmt.Errorf("bad epoch time %q", s)
+		}
+		return int64(math.Floor(f * 1000)), nil
+	case "epoch_ms":
+		f, err := parseFinite(s)
+		if err != nil {
+			return 0, fmt.Errorf("bad epoch_ms time %q", s)
+		}
+		return int64(math.Floor(f)), nil
+	default:
+		t, err := time.Parse(format, s)
+		if err != nil {
+			return 0, fmt.Errorf("time %q does not match layout %q", s, format)
+		}
+		return t.UnixMilli(), nil
+	}
+}
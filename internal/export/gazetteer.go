@@ -0,0 +1,314 @@
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"wakemap/internal/db"
+)
+
+// GeoNames feature codes worth naming a passage after, by gazetteer kind.
+// Populated places (class P) are handled separately.
+var geoNamesKinds = map[string]string{
+	"H.BAY": "bay", "H.BAYS": "bay", "H.COVE": "bay", "H.BGHT": "bay", "H.INLT": "bay",
+	"H.SD": "bay", "H.LGN": "bay", "H.FJD": "bay", "H.ESTY": "bay",
+	"H.HBR": "harbour", "H.ANCH": "anchorage",
+	"L.PRT": "port", "S.MAR": "marina",
+	"T.ISL": "island", "T.ISLET": "island", "T.ISLS": "island", "T.ATOL": "island",
+	"T.CAPE": "cape", "T.PT": "cape",
+}
+
+// GeoNamesReader reads places from a GeoNames dump (allCountries.txt, a
+// country file such as AU.txt, or citiesNNN.txt): tab-separated, no header.
+// Rows of feature classes a passage wouldn't be named after are skipped.
+type GeoNamesReader struct {
+	sc   *bufio.Scanner
+	line int
+}
+
+func NewGeoNamesReader(r io.Reader) *GeoNamesReader {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 0, 64<<10), 4<<20) // alternatenames can be long
+	return &GeoNamesReader{sc: sc}
+}
+
+// Next returns the next place, or io.EOF when the input is exhausted.
+func (gr *GeoNamesReader) Next() (db.Gazetteer, error) {
+	for gr.sc.Scan() {
+		gr.line++
+		rec := strings.Split(gr.sc.Text(), "\t")
+		if len(rec) < 15 {
+			if strings.TrimSpace(gr.sc.Text()) == "" {
+				continue
+			}
+			return db.Gazetteer{}, fmt.Errorf("line %d: want 19 tab-separated columns, got %d", gr.line, len(rec))
+		}
+		kind := geoNamesKind(rec[6], rec[7], rec[14])
+		if kind == "" || rec[1] == "" {
+			continue
+		}
+		g := db.Gazetteer{SourceID: rec[0], Name: rec[1], Kind: kind}
+		var err error
+		if g.Lat, err = strconv.ParseFloat(rec[4], 64); err != nil {
+			return g, fmt.Errorf("line %d: bad latitude %q", gr.line, rec[4])
+		}
+		if g.Lon, err = strconv.ParseFloat(rec[5], 64); err != nil {
+			return g, fmt.Errorf("line %d: bad longitude %q", gr.line, rec[5])
+		}
+		g.Population, _ = strconv.ParseInt(rec[14], 10, 64)
+		g.Country = sql.NullString{String: rec[8], Valid: rec[8] != ""}
+		g.Admin1 = sql.NullString{String: rec[10], Valid: rec[10] != "" && rec[10] != "00"}
+		return g, nil
+	}
+	if err := gr.sc.Err(); err != nil {
+		return db.Gazetteer{}, err
+	}
+	return db.Gazetteer{}, io.EOF
+}
+
+func geoNamesKind(class, code, population string) string {
+	if class != "P" {
+		return geoNamesKinds[class+"."+code]
+	}
+	pop, _ := strconv.ParseInt(population, 10, 64)
+	switch {
+	case code == "PPLH" || code == "PPLQ" || code == "PPLW": // historical, abandoned, destroyed
+		return ""
+	case code == "PPLX":
+		return "suburb"
+	case code == "PPLC" || pop >= 100000:
+		return "city"
+	case strings.HasPrefix(code, "PPLA") || pop >= 5000:
+		return "town"
+	}
+	return "village"
+}
+
+// OSMPlacesReader reads places from an OSM extract exported as GeoJSON: a
+// FeatureCollection (Overpass, osmium export) or GeoJSON text sequence,
+// one feature per line. Tags are read from feature properties; area
+// features are placed at the centre of their bounding box.
+type OSMPlacesReader struct {
+	dec          *json.Decoder
+	inCollection bool
+	n            int
+}
+
+func NewOSMPlacesReader(r io.Reader) *OSMPlacesReader {
+	return &OSMPlacesReader{dec: json.NewDecoder(rsStripper{r})}
+}
+
+type osmFeature struct {
+	ID         any            `json:"id"`
+	Properties map[string]any `json:"properties"`
+	Geometry   struct {
+		Coordinates json.RawMessage `json:"coordinates"`
+	} `json:"geometry"`
+}
+
+// Next returns the next place, or io.EOF when the input is exhausted.
+// Features without a name or a place-like tag are skipped.
+func (pr *OSMPlacesReader) Next() (db.Gazetteer, error) {
+	for {
+		f, err := pr.nextFeature()
+		if err != nil {
+			return db.Gazetteer{}, err
+		}
+		pr.n++
+		if g, ok := osmPlace(f); ok {
+			return g, nil
+		}
+	}
+}
+
+// nextFeature streams features out of a FeatureCollection's array, or
+// reads top-level features one at a time.
+func (pr *OSMPlacesReader) nextFeature() (osmFeature, error) {
+	var f osmFeature
+	for {
+		if pr.inCollection {
+			if pr.dec.More() {
+				if err := pr.dec.Decode(&f); err != nil {
+					return f, fmt.Errorf("feature %d: %w", pr.n+1, err)
+				}
+				return f, nil
+			}
+			// ']' then whatever members follow "features", then '}'.
+			if _, err := pr.dec.Token(); err != nil {
+				return f, err
+			}
+			if err := pr.skipMembers(); err != nil {
+				return f, err
+			}
+			pr.inCollection = false
+			continue
+		}
+
+		tok, err := pr.dec.Token()
+		if err != nil {
+			return f, err // io.EOF at the end of input
+		}
+		if d, ok := tok.(json.Delim); !ok || d != '{' {
+			return f, fmt.Errorf("expected a GeoJSON object, got %v", tok)
+		}
+		members := map[string]json.RawMessage{}
+		for pr.dec.More() {
+			tok, err := pr.dec.Token()
+			if err != nil {
+				return f, err
+			}
+			key, _ := tok.(string)
+			if key == "features" {
+				if tok, err := pr.dec.Token(); err != nil || tok != json.Delim('[') {
+					return f, errors.New(`"features" must be an array`)
+				}
+				pr.inCollection = true
+				break
+			}
+			var v json.RawMessage
+			if err := pr.dec.Decode(&v); err != nil {
+				return f, err
+			}
+			members[key] = v
+		}
+		if pr.inCollection {
+			continue
+		}
+		if _, err := pr.dec.Token(); err != nil { // '}'
+			return f, err
+		}
+		b, _ := json.Marshal(members)
+		if err := json.Unmarshal(b, &f); err != nil {
+			return f, fmt.Errorf("feature %d: %w", pr.n+1, err)
+		}
+		return f, nil
+	}
+}
+
+func (pr *OSMPlacesReader) skipMembers() error {
+	for pr.dec.More() {
+		if _, err := pr.dec.Token(); err != nil {
+			return err
+		}
+		var v json.RawMessage
+		if err := pr.dec.Decode(&v); err != nil {
+			return err
+		}
+	}
+	_, err := pr.dec.Token()
+	return err
+}
+
+var osmPlaceKinds = map[string]string{
+	"city": "city", "town": "town", "village": "village", "hamlet": "hamlet",
+	"suburb": "suburb", "quarter": "suburb", "neighbourhood": "suburb",
+	"locality": "locality", "island": "island", "islet": "island",
+}
+
+func osmPlace(f osmFeature) (db.Gazetteer, bool) {
+	tag := func(k string) string {
+		s, _ := f.Properties[k].(string)
+		return strings.TrimSpace(s)
+	}
+	var g db.Gazetteer
+	if g.Name = tag("name"); g.Name == "" {
+		return g, false
+	}
+	switch {
+	case osmPlaceKinds[tag("place")] != "":
+		g.Kind = osmPlaceKinds[tag("place")]
+	case tag("leisure") == "marina":
+		g.Kind = "marina"
+	case tag("harbour") != "" && tag("harbour") != "no", tag("seamark:type") == "harbour", tag("landuse") == "harbour":
+		g.Kind = "harbour"
+	case tag("seamark:type") == "anchorage":
+		g.Kind = "anchorage"
+	case tag("natural") == "bay":
+		g.Kind = "bay"
+	case tag("natural") == "cape":
+		g.Kind = "cape"
+	default:
+		return g, false
+	}
+
+	var ok bool
+	if g.Lon, g.Lat, ok = bboxCentre(f.Geometry.Coordinates); !ok {
+		return g, false
+	}
+	switch id := f.ID.(type) {
+	case string:
+		g.SourceID = id
+	case float64:
+		g.SourceID = strconv.FormatFloat(id, 'f', -1, 64)
+	}
+	if g.SourceID == "" {
+		g.SourceID = tag("@id")
+	}
+	if g.SourceID == "" {
+		g.SourceID = fmt.Sprintf("%s@%.5f,%.5f", g.Name, g.Lon, g.Lat)
+	}
+	g.Population, _ = strconv.ParseInt(strings.NewReplacer(",", "", " ", "").Replace(tag("population")), 10, 64)
+	if c := tag("is_in:country_code"); c != "" {
+		g.Country = sql.NullString{String: strings.ToUpper(c), Valid: true}
+	} else if c := tag("addr:country"); c != "" {
+		g.Country = sql.NullString{String: strings.ToUpper(c), Valid: true}
+	}
+	if s := tag("is_in:state"); s != "" {
+		g.Admin1 = sql.NullString{String: s, Valid: true}
+	}
+	return g, true
+}
+
+// bboxCentre finds the centre of any GeoJSON coordinates array, however
+// deeply nested.
+func bboxCentre(raw json.RawMessage) (lon, lat float64, ok bool) {
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return 0, 0, false
+	}
+	minX, minY, maxX, maxY := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	var walk func(v any)
+	walk = func(v any) {
+		a, _ := v.([]any)
+		if len(a) >= 2 {
+			x, xok := a[0].(float64)
+			y, yok := a[1].(float64)
+			if xok && yok {
+				minX, maxX = math.Min(minX, x), math.Max(maxX, x)
+				minY, maxY = math.Min(minY, y), math.Max(maxY, y)
+				return
+			}
+		}
+		for _, e := range a {
+			walk(e)
+		}
+	}
+	walk(v)
+	if minX > maxX {
+		return 0, 0, false
+	}
+	return (minX + maxX) / 2, (minY + maxY) / 2, true
+}
+
+// rsStripper drops the record separators (0x1E) that prefix each feature
+// in a GeoJSON text sequence (RFC 8142).
+type rsStripper struct{ r io.Reader }
+
+func (s rsStripper) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 && bytes.IndexByte(p[:n], 0x1e) >= 0 {
+		n = copy(p, bytes.ReplaceAll(p[:n], []byte{0x1e}, nil))
+		if n == 0 && err == nil {
+			return s.Read(p)
+		}
+	}
+	return n, err
+}
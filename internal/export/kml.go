@@ -0,0 +1,120 @@
+package export
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+)
+
+// Brand blue (#0b6cff) in KML's aabbggrr order.
+const kmlTrackColor = "ffff6c0b"
+
+// WriteKML renders a track as a KML document: a styled LineString for the
+// static path, a gx:Track with <when> stamps for the Google Earth time
+// slider, start/end placemarks and a stats balloon.
+func WriteKML(w io.Writer, id int64, ts *data.TrackStats) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprint(bw, xml.Header)
+	fmt.Fprint(bw, `<kml xmlns="http://www.opengis.net/kml/2.2" xmlns:gx="http://www.google.com/kml/ext/2.2">`+"\n")
+	fmt.Fprint(bw, "<Document>\n")
+	fmt.Fprintf(bw, "<name>%s</name>\n", esc(ts.Name))
+	fmt.Fprintf(bw, "<description><![CDATA[%s]]></description>\n", statsBalloon(id, ts))
+
+	fmt.Fprintf(bw, `<Style id="track"><LineStyle><color>%s</color><width>3</width></LineStyle>`+
+		`<IconStyle><Icon><href>http://maps.google.com/mapfiles/kml/shapes/sailing.png</href></Icon></IconStyle></Style>`+"\n", kmlTrackColor)
+	fmt.Fprint(bw, `<Style id="start"><IconStyle><Icon><href>http://maps.google.com/mapfiles/kml/paddle/grn-circle.png</href></Icon></IconStyle></Style>`+"\n")
+	fmt.Fprint(bw, `<Style id="end"><IconStyle><Icon><href>http://maps.google.com/mapfiles/kml/paddle/red-square.png</href></Icon></IconStyle></Style>`+"\n")
+
+	// Static path
+	fmt.Fprint(bw, "<Placemark>\n")
+	fmt.Fprintf(bw, "<name>%s</name>\n", esc(ts.Name))
+	fmt.Fprintf(bw, "<description><![CDATA[%s]]></description>\n", statsBalloon(id, ts))
+	fmt.Fprint(bw, "<styleUrl>#track</styleUrl>\n")
+	fmt.Fprint(bw, "<LineString><tessellate>1</tessellate><coordinates>\n")
+	for _, c := range ts.Coords {
+		fmt.Fprintf(bw, "%s,%s\n", ff(c[0]), ff(c[1]))
+	}
+	fmt.Fprint(bw, "</coordinates></LineString>\n</Placemark>\n")
+
+	// Time-slider playback
+	if len(ts.Coords) > 0 {
+		fmt.Fprint(bw, "<Placemark>\n<name>Playback</name>\n<styleUrl>#track</styleUrl>\n<gx:Track>\n")
+		for _, ms := range ts.TimesMs {
+			fmt.Fprintf(bw, "<when>%s</when>\n", data.UnixMilliToTime(ms).Format(timeRFC3339Milli))
+		}
+		for _, c := range ts.Coords {
+			fmt.Fprintf(bw, "<gx:coord>%s %s 0</gx:coord>\n", ff(c[0]), ff(c[1]))
+		}
+		fmt.Fprint(bw, "</gx:Track>\n</Placemark>\n")
+
+		first, last := ts.Coords[0], ts.Coords[len(ts.Coords)-1]
+		writePoint(bw, "Start", "#start", ts.StartedAt, first)
+		writePoint(bw, "End", "#end", ts.EndedAt, last)
+	}
+
+	fmt.Fprint(bw, "</Document>\n</kml>\n")
+	return bw.Flush()
+}
+
+// WriteKMZ wraps WriteKML in a zip archive as doc.kml.
+func WriteKMZ(w io.Writer, id int64, ts *data.TrackStats) error {
+	zw := zip.NewWriter(w)
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "doc.kml",
+		Method:   zip.Deflate,
+		Modified: data.UnixToTime(ts.EndedAt),
+	})
+	if err != nil {
+		return err
+	}
+	if err := WriteKML(f, id, ts); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+func writePoint(w io.Writer, name, style string, t int64, c [2]float64) {
+	fmt.Fprint(w, "<Placemark>\n")
+	fmt.Fprintf(w, "<name>%s</name>\n", name)
+	fmt.Fprintf(w, "<TimeStamp><when>%s</when></TimeStamp>\n", data.UnixToTime(t).Format(time.RFC3339))
+	fmt.Fprintf(w, "<styleUrl>%s</styleUrl>\n", style)
+	fmt.Fprintf(w, "<Point><coordinates>%s,%s</coordinates></Point>\n", ff(c[0]), ff(c[1]))
+	fmt.Fprint(w, "</Placemark>\n")
+}
+
+// statsBalloon is the HTML shown in the Google Earth info balloon.
+func statsBalloon(id int64, ts *data.TrackStats) string {
+	d := time.Duration(ts.DurationS()) * time.Second
+	return fmt.Sprintf(
+		"<table>"+
+			"<tr><td>Track</td><td>#%d</td></tr>"+
+			"<tr><td>Started</td><td>%s</td></tr>"+
+			"<tr><td>Ended</td><td>%s</td></tr>"+
+			"<tr><td>Distance</td><td>%.1f nm</td></tr>"+
+			"<tr><td>Duration</td><td>%s</td></tr>"+
+			"<tr><td>Avg speed</td><td>%.1f kn</td></tr>"+
+			"</table>",
+		id,
+		data.UnixToTime(ts.StartedAt).Format(time.RFC1123),
+		data.UnixToTime(ts.EndedAt).Format(time.RFC1123),
+		ts.DistanceM/1852.0,
+		d.String(),
+		ts.AvgKnots(),
+	)
+}
+
+func esc(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func ff(v float64) string { return strconv.FormatFloat(v, 'f', 7, 64) }
@@ -0,0 +1,147 @@
+package export
+
+import (
+	"encoding/csv"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"strconv"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+)
+
+// LogCSVHeader is the column set for logbook CSV exports.
+var LogCSVHeader = []string{"t_iso", "t_epoch", "kind", "auto", "text", "lat", "lon", "cog_deg", "sog_kn", "track_id"}
+
+// WriteLogCSV writes log entries as CSV.
+func WriteLogCSV(w io.Writer, entries []db.LogEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(LogCSVHeader); err != nil {
+		return err
+	}
+	r := make([]string, len(LogCSVHeader))
+	for _, e := range entries {
+		r[0] = data.UnixToTime(e.T).Format(time.RFC3339)
+		r[1] = strconv.FormatInt(e.T, 10)
+		r[2] = e.Kind
+		r[3] = strconv.FormatInt(e.Auto, 10)
+		r[4] = e.Text
+		r[5], r[6], r[7], r[8], r[9] = "", "", "", "", ""
+		if e.Lat.Valid && e.Lon.Valid {
+			r[5] = strconv.FormatFloat(e.Lat.Float64, 'f', 6, 64)
+			r[6] = strconv.FormatFloat(e.Lon.Float64, 'f', 6, 64)
+		}
+		if e.CogRad.Valid {
+			r[7] = strconv.FormatFloat(cogDeg(e.CogRad.Float64), 'f', 0, 64)
+		}
+		if e.SogMs.Valid {
+			r[8] = strconv.FormatFloat(e.SogMs.Float64*msToKnots, 'f', 1, 64)
+		}
+		if e.TrackID.Valid {
+			r[9] = strconv.FormatInt(e.TrackID.Int64, 10)
+		}
+		if err := cw.Write(r); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type logDay struct {
+	Date string
+	Rows []logRow
+}
+
+type logRow struct {
+	Time, Position, COG, SOG, Kind, Text string
+	Auto                                 bool
+}
+
+var logHTML = template.Must(template.New("log").Parse(`<!doctype html>
+<html lang="en"><head><meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+  body { font: 11pt/1.35 Georgia, serif; margin: 2em; color: #111; }
+  h1 { font-size: 16pt; margin: 0 0 .2em; }
+  .sub { color: #555; margin-bottom: 1.5em; }
+  h2 { font-size: 12pt; margin: 1.4em 0 .4em; border-bottom: 1px solid #999; page-break-after: avoid; }
+  table { width: 100%; border-collapse: collapse; }
+  th, td { text-align: left; vertical-align: top; padding: .2em .5em; border-bottom: 1px solid #ddd; }
+  th { font-size: 9pt; text-transform: uppercase; color: #555; }
+  td.num { text-align: right; white-space: nowrap; }
+  td.pos, td.time { white-space: nowrap; font-family: Menlo, monospace; font-size: 9.5pt; }
+  tr.auto td { color: #444; }
+  tr.manual td.text { font-weight: bold; }
+  tr { page-break-inside: avoid; }
+  @media print { body { margin: 0; } }
+</style></head>
+<body>
+<h1>{{.Title}}</h1>
+<div class="sub">{{.Subtitle}} · times UTC · generated {{.Generated}}</div>
+{{range .Days}}
+<h2>{{.Date}}</h2>
+<table>
+<tr><th>Time</th><th>Position</th><th>COG</th><th>SOG</th><th>Kind</th><th>Entry</th></tr>
+{{range .Rows}}<tr class="{{if .Auto}}auto{{else}}manual{{end}}"><td class="time">{{.Time}}</td><td class="pos">{{.Position}}</td><td class="num">{{.COG}}</td><td class="num">{{.SOG}}</td><td>{{.Kind}}</td><td class="text">{{.Text}}</td></tr>
+{{end}}</table>
+{{else}}
+<p>No entries.</p>
+{{end}}
+</body></html>
+`))
+
+// WriteLogHTML renders log entries as a printable, day-by-day ship's log.
+func WriteLogHTML(w io.Writer, title, subtitle string, entries []db.LogEntry) error {
+	var days []logDay
+	for _, e := range entries {
+		t := data.UnixToTime(e.T)
+		date := t.Format("Monday 2 January 2006")
+		if len(days) == 0 || days[len(days)-1].Date != date {
+			days = append(days, logDay{Date: date})
+		}
+		row := logRow{Time: t.Format("15:04"), Kind: e.Kind, Text: e.Text, Auto: e.Auto == 1}
+		if e.Lat.Valid && e.Lon.Valid {
+			row.Position = FormatDDM(e.Lat.Float64, e.Lon.Float64)
+		}
+		if e.CogRad.Valid {
+			row.COG = fmt.Sprintf("%03.0f°", cogDeg(e.CogRad.Float64))
+		}
+		if e.SogMs.Valid {
+			row.SOG = fmt.Sprintf("%.1f kn", e.SogMs.Float64*msToKnots)
+		}
+		d := &days[len(days)-1]
+		d.Rows = append(d.Rows, row)
+	}
+	return logHTML.Execute(w, map[string]any{
+		"Title":     title,
+		"Subtitle":  subtitle,
+		"Generated": time.Now().UTC().Format("2006-01-02 15:04"),
+		"Days":      days,
+	})
+}
+
+// FormatDDM formats a position as degrees and decimal minutes, the way
+// it's written in a paper log: 33°52.100'S 151°12.300'E.
+func FormatDDM(lat, lon float64) string {
+	ddm := func(v float64, pos, neg string) string {
+		h := pos
+		if v < 0 {
+			h, v = neg, -v
+		}
+		d := math.Floor(v)
+		m := (v - d) * 60
+		if m >= 59.9995 {
+			d, m = d+1, 0
+		}
+		return fmt.Sprintf("%.0f°%06.3f'%s", d, m, h)
+	}
+	return ddm(lat, "N", "S") + " " + ddm(lon, "E", "W")
+}
+
+func cogDeg(rad float64) float64 {
+	return math.Mod(rad*180/math.Pi+360, 360)
+}
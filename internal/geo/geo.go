@@ -0,0 +1,70 @@
+// Package geo holds the small amount of spherical and planar geometry the
+// track code needs. Coordinates are [lon, lat] in degrees throughout.
+package geo
+
+import "math"
+
+const EarthRadiusM = 6371000.0
+
+func toRad(d float64) float64 { return d * math.Pi / 180 }
+
+// HaversineM is the great-circle distance between two points in metres.
+func HaversineM(aLon, aLat, bLon, bLat float64) float64 {
+	dLat := toRad(bLat - aLat)
+	dLon := toRad(bLon - aLon)
+	la1 := toRad(aLat)
+	la2 := toRad(bLat)
+	sin1 := math.Sin(dLat / 2)
+	sin2 := math.Sin(dLon / 2)
+	h := sin1*sin1 + math.Cos(la1)*math.Cos(la2)*sin2*sin2
+	return 2 * EarthRadiusM * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// MetersPerPixel is the ground resolution of a 256px Web Mercator tile
+// pyramid at zoom z and latitude lat.
+func MetersPerPixel(z, lat float64) float64 {
+	return 156543.03392 * math.Cos(toRad(lat)) / math.Pow(2, z)
+}
+
+// Interpolate returns the point a fraction f of the way from a to b along
+// the great circle joining them.
+func Interpolate(aLon, aLat, bLon, bLat, f float64) (lon, lat float64) {
+	la1, lo1 := toRad(aLat), toRad(aLon)
+	la2, lo2 := toRad(bLat), toRad(bLon)
+	d := HaversineM(aLon, aLat, bLon, bLat) / EarthRadiusM
+	if d < 1e-12 {
+		return aLon + f*(bLon-aLon), aLat + f*(bLat-aLat)
+	}
+	ka := math.Sin((1-f)*d) / math.Sin(d)
+	kb := math.Sin(f*d) / math.Sin(d)
+	x := ka*math.Cos(la1)*math.Cos(lo1) + kb*math.Cos(la2)*math.Cos(lo2)
+	y := ka*math.Cos(la1)*math.Sin(lo1) + kb*math.Cos(la2)*math.Sin(lo2)
+	z := ka*math.Sin(la1) + kb*math.Sin(la2)
+	lat = math.Atan2(z, math.Hypot(x, y)) * 180 / math.Pi
+	lon = math.Atan2(y, x) * 180 / math.Pi
+	return lon, lat
+}
+
+// InterpolateAngle interpolates between two angles in radians the short
+// way round, returning a result in [0, 2π).
+func InterpolateAngle(a, b, f float64) float64 {
+	d := math.Remainder(b-a, 2*math.Pi)
+	r := math.Mod(a+f*d, 2*math.Pi)
+	if r < 0 {
+		r += 2 * math.Pi
+	}
+	return r
+}
+
+// MercatorXY projects lon/lat to Web Mercator in [0, 1) with y growing
+// south, clamping latitude to the square map.
+func MercatorXY(lon, lat float64) (x, y float64) {
+	lat = math.Max(math.Min(lat, 85.0511), -85.0511)
+	s := math.Sin(toRad(lat))
+	return (lon + 180) / 360, 0.5 - math.Log((1+s)/(1-s))/(4*math.Pi)
+}
+
+// MercatorLonLat is the inverse of MercatorXY.
+func MercatorLonLat(x, y float64) (lon, lat float64) {
+	return x*360 - 180, math.Atan(math.Sinh(math.Pi*(1-2*y))) * 180 / math.Pi
+}
@@ -0,0 +1,91 @@
+package geo
+
+import "math"
+
+// Simplify runs Douglas-Peucker over pts with a tolerance in metres and
+// returns the indices of the surviving vertices in order. Indices with
+// keep[i] set (keep may be nil) always survive and split the line, so
+// callers can pin stops and other features the geometry alone would lose.
+// The first and last points always survive.
+func Simplify(pts [][2]float64, tolM float64, keep []bool) []int {
+	n := len(pts)
+	if n <= 2 || tolM <= 0 {
+		idx := make([]int, n)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx
+	}
+
+	// Local equirectangular projection is plenty for a single track.
+	lat0 := 0.0
+	for _, p := range pts {
+		lat0 += p[1]
+	}
+	lat0 /= float64(n)
+	kx := EarthRadiusM * math.Pi / 180 * math.Cos(toRad(lat0))
+	ky := EarthRadiusM * math.Pi / 180
+	xy := make([][2]float64, n)
+	for i, p := range pts {
+		xy[i] = [2]float64{p[0] * kx, p[1] * ky}
+	}
+
+	marked := make([]bool, n)
+	marked[0], marked[n-1] = true, true
+	for i, k := range keep {
+		if k && i < n {
+			marked[i] = true
+		}
+	}
+
+	// Each pinned run boundary starts its own DP pass.
+	type span struct{ a, b int }
+	var stack []span
+	prev := 0
+	for i := 1; i < n; i++ {
+		if marked[i] {
+			stack = append(stack, span{prev, i})
+			prev = i
+		}
+	}
+
+	tol2 := tolM * tolM
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.b-s.a < 2 {
+			continue
+		}
+		far, farD := -1, tol2
+		for i := s.a + 1; i < s.b; i++ {
+			if d := segDist2(xy[i], xy[s.a], xy[s.b]); d > farD {
+				far, farD = i, d
+			}
+		}
+		if far >= 0 {
+			marked[far] = true
+			stack = append(stack, span{s.a, far}, span{far, s.b})
+		}
+	}
+
+	idx := make([]int, 0, n/4+2)
+	for i, m := range marked {
+		if m {
+			idx = append(idx, i)
+		}
+	}
+	return idx
+}
+
+// segDist2 is the squared distance from p to segment ab.
+func segDist2(p, a, b [2]float64) float64 {
+	dx, dy := b[0]-a[0], b[1]-a[1]
+	l2 := dx*dx + dy*dy
+	t := 0.0
+	if l2 > 0 {
+		t = ((p[0]-a[0])*dx + (p[1]-a[1])*dy) / l2
+		t = math.Max(0, math.Min(1, t))
+	}
+	ex, ey := p[0]-(a[0]+t*dx), p[1]-(a[1]+t*dy)
+	return ex*ex + ey*ey
+}
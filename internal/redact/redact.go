@@ -0,0 +1,456 @@
+// Package redact hides positions inside private zones (a home mooring, a
+// friend's jetty) from exports and public views. Zones are polygons kept in
+// a GeoJSON file so they can be edited by hand as well as through the API;
+// the stored positions are never touched.
+package redact
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"wakemap/internal/geo"
+)
+
+// What happens to a fix inside a zone.
+const (
+	// Clip drops the fix; tracks simply stop at the zone edge.
+	Clip = "clip"
+	// Jitter snaps the fix into a grid of JitterM cells, every fix in a
+	// cell landing on the same pseudo-random point within it, so averaging
+	// many fixes can't narrow the spot down past the cell.
+	Jitter = "jitter"
+)
+
+// DefaultJitterM is the jitter cell size when a zone doesn't set one.
+const DefaultJitterM = 500
+
+var ErrNotFound = errors.New("redaction zone not found")
+
+// Zone is one private area: a Polygon or MultiPolygon.
+type Zone struct {
+	ID      int64
+	Name    string
+	Mode    string  // Clip or Jitter
+	JitterM float64 // cell size for Jitter
+
+	polys  [][][][2]float64 // polygons → rings (outer first) → lon/lat
+	circle *Circle          // instead of polys
+	bbox   [4]float64
+}
+
+// Circle is a private spot hidden out to RadiusM, for redaction that only
+// applies to one view, such as a share link.
+type Circle struct {
+	Lon     float64 `json:"lon"`
+	Lat     float64 `json:"lat"`
+	RadiusM float64 `json:"radius_m"`
+}
+
+// With returns a set of s's zones plus circles clipped out around each
+// point. s is unchanged; the result is for reading only.
+func (s *Set) With(circles []Circle) *Set {
+	out := &Set{}
+	if s != nil {
+		s.mu.RLock()
+		out.zones = append(out.zones, s.zones...)
+		out.version = s.version
+		s.mu.RUnlock()
+	}
+	if len(circles) == 0 {
+		return out
+	}
+	h := fnv.New64a()
+	fmt.Fprint(h, out.version)
+	for i := range circles {
+		c := circles[i]
+		dLat := c.RadiusM / 111320
+		dLon := dLat / math.Max(math.Cos(c.Lat*math.Pi/180), 1e-6)
+		out.zones = append(out.zones, &Zone{
+			Mode:   Clip,
+			circle: &c,
+			bbox:   [4]float64{c.Lon - dLon, c.Lat - dLat, c.Lon + dLon, c.Lat + dLat},
+		})
+		fmt.Fprintf(h, "|%g,%g,%g", c.Lon, c.Lat, c.RadiusM)
+	}
+	out.version = fmt.Sprintf("%016x", h.Sum64())
+	return out
+}
+
+// Set is the zones from one file. A nil *Set has no zones, so callers can
+// pass nil for views that aren't redacted.
+type Set struct {
+	path string
+
+	mu      sync.RWMutex
+	zones   []*Zone
+	version string
+}
+
+// Load reads the zones in path. A missing file is an empty set; it is
+// created on the first edit.
+func Load(path string) (*Set, error) {
+	s := &Set{path: path}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var fc struct {
+		Type     string            `json:"type"`
+		Features []json.RawMessage `json:"features"`
+	}
+	if err := json.Unmarshal(b, &fc); err != nil || fc.Type != "FeatureCollection" {
+		return nil, fmt.Errorf("%s: want a GeoJSON FeatureCollection", path)
+	}
+	for i, raw := range fc.Features {
+		z, err := ParseFeature(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: feature %d: %w", path, i, err)
+		}
+		s.zones = append(s.zones, &z)
+	}
+	// Hand-written files may leave ids out; number those after the rest.
+	var next int64
+	for _, z := range s.zones {
+		next = max(next, z.ID)
+	}
+	seen := map[int64]bool{}
+	for _, z := range s.zones {
+		if z.ID <= 0 || seen[z.ID] {
+			next++
+			z.ID = next
+		}
+		seen[z.ID] = true
+	}
+	s.version = versionOf(b, len(s.zones))
+	return s, nil
+}
+
+// ParseFeature reads a zone from a GeoJSON Feature with a Polygon or
+// MultiPolygon geometry and optional "name", "mode" and "jitter_m"
+// properties.
+func ParseFeature(raw []byte) (Zone, error) {
+	var f struct {
+		Type       string `json:"type"`
+		ID         any    `json:"id"`
+		Properties struct {
+			Name    string  `json:"name"`
+			Mode    string  `json:"mode"`
+			JitterM float64 `json:"jitter_m"`
+		} `json:"properties"`
+		Geometry struct {
+			Type        string          `json:"type"`
+			Coordinates json.RawMessage `json:"coordinates"`
+		} `json:"geometry"`
+	}
+	var z Zone
+	if err := json.Unmarshal(raw, &f); err != nil {
+		return z, err
+	}
+	if f.Type != "Feature" {
+		return z, errors.New(`want a GeoJSON "Feature"`)
+	}
+	if id, ok := f.ID.(float64); ok && id > 0 && id == math.Trunc(id) {
+		z.ID = int64(id)
+	}
+	z.Name = strings.TrimSpace(f.Properties.Name)
+	switch z.Mode = f.Properties.Mode; z.Mode {
+	case "":
+		z.Mode = Clip
+	case Clip:
+	case Jitter:
+		z.JitterM = f.Properties.JitterM
+		if z.JitterM == 0 {
+			z.JitterM = DefaultJitterM
+		}
+		if z.JitterM < 50 || z.JitterM > 50000 {
+			return z, errors.New("jitter_m must be between 50 and 50000")
+		}
+	default:
+		return z, fmt.Errorf("mode must be %q or %q", Clip, Jitter)
+	}
+
+	switch f.Geometry.Type {
+	case "Polygon":
+		var p [][][2]float64
+		if err := json.Unmarshal(f.Geometry.Coordinates, &p); err != nil {
+			return z, fmt.Errorf("polygon coordinates: %w", err)
+		}
+		z.polys = [][][][2]float64{p}
+	case "MultiPolygon":
+		if err := json.Unmarshal(f.Geometry.Coordinates, &z.polys); err != nil {
+			return z, fmt.Errorf("multipolygon coordinates: %w", err)
+		}
+	default:
+		return z, errors.New("geometry must be a Polygon or MultiPolygon")
+	}
+	if len(z.polys) == 0 {
+		return z, errors.New("geometry is empty")
+	}
+	z.bbox = [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	for _, p := range z.polys {
+		if len(p) == 0 {
+			return z, errors.New("polygon has no rings")
+		}
+		for _, ring := range p {
+			if len(ring) < 4 {
+				return z, errors.New("polygon rings need at least 4 positions")
+			}
+			for _, c := range ring {
+				if c[0] < -180 || c[0] > 180 || c[1] < -90 || c[1] > 90 {
+					return z, fmt.Errorf("position %v out of lon/lat range", c)
+				}
+			}
+		}
+		for _, c := range p[0] {
+			z.bbox[0], z.bbox[1] = min(z.bbox[0], c[0]), min(z.bbox[1], c[1])
+			z.bbox[2], z.bbox[3] = max(z.bbox[2], c[0]), max(z.bbox[3], c[1])
+		}
+	}
+	return z, nil
+}
+
+// Feature renders the zone as the GeoJSON Feature it is stored as.
+func (z *Zone) Feature() map[string]any {
+	props := map[string]any{"name": z.Name, "mode": z.Mode}
+	if z.Mode == Jitter {
+		props["jitter_m"] = z.JitterM
+	}
+	geom := map[string]any{"type": "MultiPolygon", "coordinates": z.polys}
+	if len(z.polys) == 1 {
+		geom = map[string]any{"type": "Polygon", "coordinates": z.polys[0]}
+	}
+	return map[string]any{"type": "Feature", "id": z.ID, "properties": props, "geometry": geom, "bbox": z.bbox}
+}
+
+// contains is an even-odd test against the zone's polygons; holes count.
+func (z *Zone) contains(lon, lat float64) bool {
+	if lon < z.bbox[0] || lon > z.bbox[2] || lat < z.bbox[1] || lat > z.bbox[3] {
+		return false
+	}
+	if z.circle != nil {
+		return geo.HaversineM(z.circle.Lon, z.circle.Lat, lon, lat) <= z.circle.RadiusM
+	}
+	for _, p := range z.polys {
+		if !inRing(p[0], lon, lat) {
+			continue
+		}
+		inHole := false
+		for _, hole := range p[1:] {
+			if inRing(hole, lon, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+func inRing(ring [][2]float64, x, y float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// jitter moves a fix to its cell's stand-in point. Cells are square in
+// metres at the zone's mid latitude.
+func (z *Zone) jitter(lon, lat float64) (float64, float64) {
+	dLat := z.JitterM / 111320
+	dLon := dLat / math.Max(math.Cos((z.bbox[1]+z.bbox[3])/2*math.Pi/180), 0.01)
+	i, j := math.Floor(lon/dLon), math.Floor(lat/dLat)
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%d:%d:%d", z.ID, int64(i), int64(j))
+	v := h.Sum64()
+	// 0.1–0.9 of the cell, so the stand-in never sits on a cell edge.
+	u1 := 0.1 + 0.8*float64(v&0xffff)/0xffff
+	u2 := 0.1 + 0.8*float64(v>>16&0xffff)/0xffff
+	return (i + u1) * dLon, (j + u2) * dLat
+}
+
+// Apply redacts one fix: ok is false when it must be dropped, otherwise
+// lon/lat are where to show it. Fixes outside every zone come back as is.
+func (s *Set) Apply(lon, lat float64) (float64, float64, bool) {
+	if s == nil {
+		return lon, lat, true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, z := range s.zones {
+		if !z.contains(lon, lat) {
+			continue
+		}
+		if z.Mode == Jitter {
+			jlon, jlat := z.jitter(lon, lat)
+			return jlon, jlat, true
+		}
+		return 0, 0, false
+	}
+	return lon, lat, true
+}
+
+// Hides reports whether lon/lat is inside any zone, whatever its mode. It
+// suits aggregates like dwell-time cells, where a moved fix would still
+// give the spot away.
+func (s *Set) Hides(lon, lat float64) bool {
+	if s == nil {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, z := range s.zones {
+		if z.contains(lon, lat) {
+			return true
+		}
+	}
+	return false
+}
+
+// Empty reports whether there is nothing to redact.
+func (s *Set) Empty() bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.zones) == 0
+}
+
+// Version changes whenever the zones do, for keying cached renderings.
+func (s *Set) Version() string {
+	if s == nil {
+		return ""
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version
+}
+
+// Zones returns the zones by id.
+func (s *Set) Zones() []Zone {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Zone, 0, len(s.zones))
+	for _, z := range s.zones {
+		out = append(out, *z)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// Zone returns one zone.
+func (s *Set) Zone(id int64) (Zone, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, z := range s.zones {
+		if z.ID == id {
+			return *z, nil
+		}
+	}
+	return Zone{}, ErrNotFound
+}
+
+// Put adds a zone (z.ID == 0) or replaces one, and saves the file.
+func (s *Set) Put(z Zone) (Zone, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	zones := append([]*Zone(nil), s.zones...)
+	if z.ID == 0 {
+		for _, o := range zones {
+			z.ID = max(z.ID, o.ID)
+		}
+		z.ID++
+		zones = append(zones, &z)
+	} else {
+		i := s.index(z.ID)
+		if i < 0 {
+			return z, ErrNotFound
+		}
+		zones[i] = &z
+	}
+	return z, s.save(zones)
+}
+
+// Delete removes a zone and saves the file.
+func (s *Set) Delete(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := s.index(id)
+	if i < 0 {
+		return ErrNotFound
+	}
+	zones := append(append([]*Zone(nil), s.zones[:i]...), s.zones[i+1:]...)
+	return s.save(zones)
+}
+
+func (s *Set) index(id int64) int {
+	for i, z := range s.zones {
+		if z.ID == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// save writes zones to the file and, once that worked, makes them current.
+// Callers hold mu.
+func (s *Set) save(zones []*Zone) error {
+	sort.Slice(zones, func(i, j int) bool { return zones[i].ID < zones[j].ID })
+	features := make([]map[string]any, 0, len(zones))
+	for _, z := range zones {
+		features = append(features, z.Feature())
+	}
+	b, err := json.MarshalIndent(map[string]any{"type": "FeatureCollection", "features": features}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".redaction-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	s.zones = zones
+	s.version = versionOf(b, len(zones))
+	return nil
+}
+
+func versionOf(b []byte, zones int) string {
+	if zones == 0 {
+		return ""
+	}
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
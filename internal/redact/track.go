@@ -0,0 +1,81 @@
+package redact
+
+import (
+	"math"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+)
+
+// TrackStats returns ts with every fix, stop and bbox redacted. Distance,
+// times and motion stats describe the passage, not where it was, and are
+// kept. ts itself is not modified; with no zones it is returned as is.
+func (s *Set) TrackStats(ts *data.TrackStats) *data.TrackStats {
+	if s.Empty() {
+		return ts
+	}
+	out := *ts
+	out.Coords = make([][2]float64, 0, len(ts.Coords))
+	out.Times = make([]int64, 0, len(ts.Times))
+	out.TimesMs = make([]int64, 0, len(ts.TimesMs))
+	out.SOGms = make([]float64, 0, len(ts.SOGms))
+	out.COGrad = make([]float64, 0, len(ts.COGrad))
+	out.Src = make([]string, 0, len(ts.Src))
+	out.Breaks = make([]bool, 0, len(ts.Coords))
+	out.MinX, out.MinY, out.MaxX, out.MaxY = math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	// The line breaks where fixes were clipped out, rather than drawing
+	// straight across a private place.
+	clipped := false
+	for i, c := range ts.Coords {
+		lon, lat, ok := s.Apply(c[0], c[1])
+		if !ok {
+			clipped = true
+			continue
+		}
+		// As in Position, a moved fix loses its speed and course.
+		sog, cog := ts.SOGms[i], ts.COGrad[i]
+		if lon != c[0] || lat != c[1] {
+			sog, cog = math.NaN(), math.NaN()
+		}
+		brk := i < len(ts.Breaks) && ts.Breaks[i]
+		out.Breaks = append(out.Breaks, len(out.Coords) > 0 && (brk || clipped))
+		clipped = false
+		out.Coords = append(out.Coords, [2]float64{lon, lat})
+		out.Times = append(out.Times, ts.Times[i])
+		out.TimesMs = append(out.TimesMs, ts.TimesMs[i])
+		out.SOGms = append(out.SOGms, sog)
+		out.COGrad = append(out.COGrad, cog)
+		out.Src = append(out.Src, ts.Src[i])
+		out.MinX, out.MaxX = min(out.MinX, lon), max(out.MaxX, lon)
+		out.MinY, out.MaxY = min(out.MinY, lat), max(out.MaxY, lat)
+	}
+	if len(out.Coords) == 0 {
+		out.MinX, out.MinY, out.MaxX, out.MaxY = 0, 0, 0, 0
+	}
+
+	out.Segments = make([]data.Segment, 0, len(ts.Segments))
+	for _, sg := range ts.Segments {
+		lon, lat, ok := s.Apply(sg.Centroid[0], sg.Centroid[1])
+		if !ok {
+			continue
+		}
+		sg.Centroid = [2]float64{lon, lat}
+		out.Segments = append(out.Segments, sg)
+	}
+	return &out
+}
+
+// Position redacts a fix in place, reporting false when it must be
+// dropped. A moved fix loses its course and speed, which would point back
+// at where it really was.
+func (s *Set) Position(p *db.Position) bool {
+	lon, lat, ok := s.Apply(p.Lon, p.Lat)
+	if !ok {
+		return false
+	}
+	if lon != p.Lon || lat != p.Lat {
+		p.Lon, p.Lat = lon, lat
+		p.SogMs.Valid, p.CogRad.Valid = false, false
+	}
+	return true
+}
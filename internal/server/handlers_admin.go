@@ -0,0 +1,105 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"wakemap/internal/data"
+)
+
+// Backup takes and lists database snapshots. Owner only.
+//
+//	GET  /api/admin/backup   snapshots on disk, oldest first
+//	POST /api/admin/backup   take one now; older ones beyond the keep count go
+func (a *API) Backup(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwner(w, r) {
+		return
+	}
+	if a.Backups.Dir == "" {
+		writeErr(w, http.StatusServiceUnavailable, "backups_disabled", "no backup directory configured", nil)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		paths, err := data.Snapshots(a.Backups.Dir)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "backup_error", "failed to list snapshots", map[string]any{"err": err.Error()})
+			return
+		}
+		out := make([]map[string]any, 0, len(paths))
+		for _, p := range paths {
+			fi, err := os.Stat(p)
+			if err != nil {
+				continue // pruned since the listing
+			}
+			out = append(out, map[string]any{
+				"name":       filepath.Base(p),
+				"bytes":      fi.Size(),
+				"created_at": fi.ModTime().UTC().Format(timeRFC3339),
+			})
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"dir": a.Backups.Dir, "keep": a.Backups.Keep, "snapshots": out})
+	case http.MethodPost:
+		rep, err := a.Store.Backup(r.Context(), a.Backups)
+		if errors.Is(err, data.ErrBackupRunning) {
+			writeErr(w, http.StatusConflict, "backup_running", "a backup is already running", nil)
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "backup_error", "backup failed", map[string]any{"err": err.Error()})
+			return
+		}
+		pruned := make([]string, 0, len(rep.Pruned))
+		for _, p := range rep.Pruned {
+			pruned = append(pruned, filepath.Base(p))
+		}
+		writeJSON(w, http.StatusCreated, map[string]any{
+			"name":        filepath.Base(rep.Path),
+			"path":        rep.Path,
+			"bytes":       rep.Bytes,
+			"pages":       rep.Pages,
+			"integrity":   "ok",
+			"pruned":      pruned,
+			"duration_ms": rep.Duration.Milliseconds(),
+		})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST", nil)
+	}
+}
+
+// IngestStats handles GET /api/admin/ingest: the ingest writer's queue
+// depth, drop counts and commit latency. Owner only.
+func (a *API) IngestStats(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwner(w, r) {
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET", nil)
+		return
+	}
+	if a.Ingest == nil {
+		writeErr(w, http.StatusServiceUnavailable, "ingest_disabled", "positions are written directly, without the ingest writer", nil)
+		return
+	}
+	st := a.Ingest.Stats()
+	ms := func(d time.Duration) float64 { return float64(d.Microseconds()) / 1000 }
+	writeJSON(w, http.StatusOK, map[string]any{
+		"policy":         st.Policy,
+		"depth":          st.Depth,
+		"peak":           st.Peak,
+		"capacity":       st.Capacity,
+		"queued":         st.Queued,
+		"committed":      st.Committed,
+		"dropped":        st.Dropped,
+		"rejected":       st.Rejected,
+		"failed":         st.Failed,
+		"batches":        st.Batches,
+		"last_batch":     st.LastBatch,
+		"last_commit_ms": ms(st.LastCommit),
+		"max_commit_ms":  ms(st.MaxCommit),
+		"avg_commit_ms":  ms(st.AvgCommit),
+	})
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+)
+
+// parseTimeParam accepts RFC3339 or epoch seconds.
+func parseTimeParam(v string) (int64, error) {
+	ms, err := parseTimeParamMs(v)
+	if err != nil {
+		return 0, err
+	}
+	return time.UnixMilli(ms).Unix(), nil
+}
+
+// parseTimeParamMs is parseTimeParam to the millisecond: RFC3339 with
+// fractional seconds, or epoch seconds with decimals.
+func parseTimeParamMs(v string) (int64, error) {
+	if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return n * 1000, nil
+	}
+	if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+		return int64(math.Floor(f * 1000)), nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.UnixMilli(), nil
+}
+
+// requiredTimeParamMs reads a mandatory time query param in epoch
+// milliseconds, writing a 400 on failure.
+func requiredTimeParamMs(w http.ResponseWriter, r *http.Request, key string) (int64, bool) {
+	v := r.URL.Query().Get(key)
+	if v == "" {
+		writeErr(w, http.StatusBadRequest, "missing_params", key+" is required", nil)
+		return 0, false
+	}
+	t, err := parseTimeParamMs(v)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_params", key+" must be RFC3339 or epoch seconds", map[string]any{key: v})
+		return 0, false
+	}
+	return t, true
+}
+
+func requirePOST(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST", nil)
+		return false
+	}
+	return true
+}
+
+func writeEditResult(w http.ResponseWriter, res *data.EditResult, err error) {
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "not_found", err.Error(), nil)
+	case errors.Is(err, data.ErrEditConflict):
+		writeErr(w, http.StatusConflict, "edit_conflict", err.Error(), nil)
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "db_error", "track edit failed", map[string]any{"err": err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, res)
+	}
+}
+
+// SplitTrack handles POST /api/tracks/:id/split?at=<time>.
+func (a *API) SplitTrack(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePOST(w, r) {
+		return
+	}
+	at, ok := requiredTimeParamMs(w, r, "at")
+	if !ok {
+		return
+	}
+	res, err := a.Store.SplitTrack(r.Context(), id, at)
+	writeEditResult(w, res, err)
+}
+
+// TrimTrack handles POST /api/tracks/:id/trim?from=<time>&to=<time>.
+func (a *API) TrimTrack(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePOST(w, r) {
+		return
+	}
+	from, ok := requiredTimeParamMs(w, r, "from")
+	if !ok {
+		return
+	}
+	to, ok := requiredTimeParamMs(w, r, "to")
+	if !ok {
+		return
+	}
+	res, err := a.Store.TrimTrack(r.Context(), id, from, to)
+	writeEditResult(w, res, err)
+}
+
+// MergeTracks handles POST /api/tracks/merge?ids=1,2[,3]&name=...
+func (a *API) MergeTracks(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	if !requirePOST(w, r) {
+		return
+	}
+	raw := r.URL.Query().Get("ids")
+	var ids []int64
+	for _, p := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", "ids must be a comma-separated list of track ids", map[string]any{"ids": raw})
+			return
+		}
+		ids = append(ids, id)
+	}
+	res, err := a.Store.MergeTracks(r.Context(), ids, strings.TrimSpace(r.URL.Query().Get("name")))
+	writeEditResult(w, res, err)
+}
+
+// TrackEdits handles GET /api/track-edits and POST /api/track-edits/:id/undo.
+func (a *API) TrackEdits(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/track-edits"), "/")
+	if rest == "" {
+		limit := 50
+		if q := r.URL.Query().Get("limit"); q != "" {
+			if v, err := strconv.Atoi(q); err == nil && v > 0 && v <= 200 {
+				limit = v
+			}
+		}
+		items, err := a.Store.ListTrackEdits(r.Context(), limit)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to list edits", map[string]any{"err": err.Error()})
+			return
+		}
+
+		type outEdit struct {
+			ID        int64           `json:"id"`
+			Op        string          `json:"op"`
+			Params    json.RawMessage `json:"params,omitempty"`
+			CreatedAt string          `json:"created_at"`
+			UndoneAt  string          `json:"undone_at,omitempty"`
+		}
+		out := make([]outEdit, 0, len(items))
+		for _, e := range items {
+			oe := outEdit{
+				ID:        e.ID,
+				Op:        e.Op,
+				CreatedAt: data.UnixToTime(e.CreatedAt).Format(timeRFC3339),
+			}
+			if e.Params.Valid {
+				oe.Params = json.RawMessage(e.Params.String)
+			}
+			if e.UndoneAt.Valid {
+				oe.UndoneAt = data.UnixToTime(e.UndoneAt.Int64).Format(timeRFC3339)
+			}
+			out = append(out, oe)
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"edits": out})
+		return
+	}
+
+	idStr, action, _ := strings.Cut(rest, "/")
+	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil || action != "undo" {
+		writeErr(w, http.StatusNotFound, "not_found", "unknown edit resource", map[string]any{"path": r.URL.Path})
+		return
+	}
+	if !requirePOST(w, r) {
+		return
+	}
+	res, err := a.Store.UndoEdit(r.Context(), id)
+	writeEditResult(w, res, err)
+}
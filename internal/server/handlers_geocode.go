@@ -0,0 +1,72 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+
+	"wakemap/internal/data"
+)
+
+// ReverseGeocode handles GET /api/geocode/reverse against the offline
+// gazetteer: the best name for a point, plus the runners-up.
+//
+//	?near=lon,lat&radius=15000&limit=5
+func (a *API) ReverseGeocode(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	lon, lat, ok := parseLonLat(q.Get("near"))
+	if !ok {
+		writeErr(w, http.StatusBadRequest, "bad_params", "near must be lon,lat", map[string]any{"near": q.Get("near")})
+		return
+	}
+	radius := float64(data.DefaultGeocodeRadiusM)
+	if v := q.Get("radius"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 || f > data.MaxGeocodeRadiusM {
+			writeErr(w, http.StatusBadRequest, "bad_params", "radius must be 0 < metres <= 100000", map[string]any{"radius": v})
+			return
+		}
+		radius = f
+	}
+	limit := 5
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > 50 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "limit must be 1..50", map[string]any{"limit": v})
+			return
+		}
+		limit = n
+	}
+
+	places, err := a.Store.ReverseGeocode(r.Context(), lon, lat, radius, limit)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to search gazetteer", map[string]any{"err": err.Error()})
+		return
+	}
+	out := make([]map[string]any, 0, len(places))
+	for _, p := range places {
+		m := map[string]any{
+			"id":         p.ID,
+			"name":       p.Name,
+			"kind":       p.Kind,
+			"lon":        p.Lon,
+			"lat":        p.Lat,
+			"distance_m": p.DistanceM,
+			"source":     p.Source,
+		}
+		if p.Country.Valid {
+			m["country"] = p.Country.String
+		}
+		if p.Admin1.Valid {
+			m["admin1"] = p.Admin1.String
+		}
+		if p.Population > 0 {
+			m["population"] = p.Population
+		}
+		out = append(out, m)
+	}
+	resp := map[string]any{"place": nil, "candidates": out}
+	if len(out) > 0 {
+		resp["place"] = out[0]
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
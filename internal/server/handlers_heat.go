@@ -0,0 +1,83 @@
+package server
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"wakemap/internal/data"
+	"wakemap/internal/tiles"
+)
+
+// HeatTile handles GET /tiles/heat/{z}/{x}/{y}.{png,mvt}: where we spend
+// time on the water, as dwell time per grid cell. PNG is a ready-to-show
+// raster; MVT is a "heat" point layer with dwell_s per cell for a MapLibre
+// heatmap. Optional ?from=&to= limit it to a date range, ?vessel_id= to
+// one boat.
+func (a *API) HeatTile(w http.ResponseWriter, r *http.Request) {
+	p := strings.TrimPrefix(r.URL.Path, "/tiles/heat/")
+	ext := path.Ext(p)
+	if ext != ".png" && ext != ".mvt" {
+		writeErr(w, http.StatusNotFound, "not_found", "heat tiles are .png or .mvt", map[string]any{"path": r.URL.Path})
+		return
+	}
+	t, err := tiles.ParseTileID(p, ext)
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "not_found", err.Error(), map[string]any{"path": r.URL.Path})
+		return
+	}
+
+	level := tiles.HeatLevel(t.Z, data.HeatLevels[len(data.HeatLevels)-1])
+	hq := data.HeatQuery{Level: level}
+	hq.X0, hq.Y0, hq.X1, hq.Y1 = t.CellRange(level)
+	q := r.URL.Query()
+	for key, dst := range map[string]*int64{"from": &hq.From, "to": &hq.To} {
+		if v := q.Get(key); v != "" {
+			ts, err := parseTimeParam(v)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "bad_params", key+" must be RFC3339 or epoch seconds", map[string]any{key: v})
+				return
+			}
+			*dst = ts
+		}
+	}
+
+	var ok bool
+	if hq.VesselID, ok = vesselParam(w, r); !ok {
+		return
+	}
+
+	if zones := a.viewZones(r); !zones.Empty() {
+		hq.Exclude = zones.Hides
+	}
+
+	rows, err := a.Store.HeatCells(r.Context(), hq)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load heat cells", map[string]any{"err": err.Error()})
+		return
+	}
+	cells := make([]tiles.Cell, len(rows))
+	for i, c := range rows {
+		cells[i] = tiles.Cell{X: c.X, Y: c.Y, Value: c.DwellS}
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	if ext == ".mvt" {
+		b := tiles.Encode(t.HeatLayer("heat", level, cells))
+		if len(b) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+		_, _ = w.Write(b)
+		return
+	}
+
+	b, err := t.RenderHeatPNG(level, cells)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "render_error", "failed to render tile", map[string]any{"err": err.Error()})
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	_, _ = w.Write(b)
+}
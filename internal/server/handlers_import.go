@@ -0,0 +1,225 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/export"
+)
+
+// ImportTrackCSV creates a track from a CSV log.
+//
+//	POST /api/tracks/import?name=...&col_time=Timestamp&sog_unit=kmh
+//
+// The body is either raw CSV or a multipart form with a "file" part.
+// Column mapping params (col_time, col_lon, col_lat, col_sog, col_cog,
+// col_src, col_qual) are optional when the header uses common names.
+// The track goes to ?vessel_id=, else the vessel configured for the
+// ?source= logger (default "import").
+func (a *API) ImportTrackCSV(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST", nil)
+		return
+	}
+	q := r.URL.Query()
+
+	var body io.Reader = r.Body
+	name := strings.TrimSpace(q.Get("name"))
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt == "multipart/form-data" {
+		f, fh, err := r.FormFile("file")
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "missing_file", "multipart upload needs a \"file\" part", nil)
+			return
+		}
+		defer f.Close()
+		body = f
+		if name == "" {
+			name = strings.TrimSuffix(fh.Filename, ".csv")
+		}
+	}
+	// Unnamed tracks are named after their route ("Port Stephens →
+	// Newcastle") once the gazetteer resolves it; until then, a placeholder.
+	nameAuto := name == ""
+	if nameAuto {
+		name = "Imported " + time.Now().UTC().Format("2006-01-02 15:04")
+	}
+
+	m := export.CSVMapping{
+		Time:       q.Get("col_time"),
+		Lon:        q.Get("col_lon"),
+		Lat:        q.Get("col_lat"),
+		SOG:        q.Get("col_sog"),
+		COG:        q.Get("col_cog"),
+		Src:        q.Get("col_src"),
+		Qual:       q.Get("col_qual"),
+		TimeFormat: q.Get("time_format"),
+		SOGUnit:    q.Get("sog_unit"),
+		COGUnit:    q.Get("cog_unit"),
+	}
+	cr, err := export.NewCSVReader(body, m)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_csv", err.Error(), nil)
+		return
+	}
+
+	explicit, ok := vesselParam(w, r)
+	if !ok {
+		return
+	}
+	source := q.Get("source")
+	if source == "" {
+		source = "import"
+	}
+	vesselID, err := a.ingestVessel(r.Context(), explicit, source)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusBadRequest, "bad_params", "no such vessel", map[string]any{"source": source, "vessel_id": explicit})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to resolve vessel", map[string]any{"err": err.Error()})
+		return
+	}
+
+	notes := q.Get("notes")
+	meta := db.Track{
+		Name:     name,
+		Notes:    sql.NullString{String: notes, Valid: notes != ""},
+		VesselID: sql.NullInt64{Int64: vesselID, Valid: vesselID != 0},
+	}
+	if nameAuto {
+		meta.NameAuto = 1
+	}
+	id, n, err := a.Store.ImportTrack(r.Context(), meta, cr.Next)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "import_failed", err.Error(), map[string]any{"rows_read": n})
+		return
+	}
+	if nameAuto {
+		if route, err := a.Store.TrackRoute(r.Context(), id); err == nil && route.Name() != "" {
+			name = route.Name()
+		}
+	}
+
+	out := map[string]any{"id": id, "name": name, "points": n}
+	if vesselID != 0 {
+		out["vessel_id"] = vesselID
+	}
+	writeJSON(w, http.StatusCreated, out)
+}
+
+// positionIn is the JSON shape accepted by AppendPositions. Time is t in
+// epoch seconds, decimals allowed, or t_ms in epoch milliseconds.
+type positionIn struct {
+	T      float64  `json:"t"`
+	TMs    int64    `json:"t_ms"`
+	Lon    float64  `json:"lon"`
+	Lat    float64  `json:"lat"`
+	SogMs  *float64 `json:"sog_ms"`
+	CogRad *float64 `json:"cog_rad"`
+	Src    *string  `json:"src"`
+	Qual   *int64   `json:"qual"`
+}
+
+func (p positionIn) toDB() db.Position {
+	out := db.Position{TMs: p.TMs, Lon: p.Lon, Lat: p.Lat}
+	if out.TMs == 0 {
+		out.TMs = int64(math.Floor(p.T * 1000))
+	}
+	data.FixTimes(&out)
+	if p.SogMs != nil {
+		out.SogMs = sql.NullFloat64{Float64: *p.SogMs, Valid: true}
+	}
+	if p.CogRad != nil {
+		out.CogRad = sql.NullFloat64{Float64: *p.CogRad, Valid: true}
+	}
+	if p.Src != nil {
+		out.Src = sql.NullString{String: *p.Src, Valid: true}
+	}
+	if p.Qual != nil {
+		out.Qual = sql.NullInt64{Int64: *p.Qual, Valid: true}
+	}
+	return out
+}
+
+// AppendPositions handles POST /api/tracks/:id/positions with a JSON array
+// of fixes. The track summary is updated incrementally. With an ingest
+// writer the fixes are batched with other sources'; the reply waits for
+// their commit unless ?wait=0, which answers 202 once they are queued.
+// A full queue answers 503 with Retry-After.
+func (a *API) AppendPositions(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePOST(w, r) {
+		return
+	}
+	var in []positionIn
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", "body must be a JSON array of positions", map[string]any{"err": err.Error()})
+		return
+	}
+	ps := make([]db.Position, 0, len(in))
+	for i, p := range in {
+		if (p.T <= 0 && p.TMs <= 0) || p.Lon < -180 || p.Lon > 180 || p.Lat < -90 || p.Lat > 90 {
+			writeErr(w, http.StatusBadRequest, "bad_position", "t or t_ms must be set and lon/lat in range", map[string]any{"index": i})
+			return
+		}
+		ps = append(ps, p.toDB())
+	}
+
+	ctx := r.Context()
+	if ok, err := a.Store.TrackExists(ctx, id); err != nil || !ok {
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		} else {
+			writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		}
+		return
+	}
+	if a.Ingest != nil {
+		done, err := a.Ingest.Submit(ctx, id, ps)
+		switch {
+		case errors.Is(err, data.ErrIngestTooLarge):
+			// A bulk upload is one transaction already; write it directly.
+		case errors.Is(err, data.ErrIngestFull), errors.Is(err, data.ErrIngestClosed):
+			w.Header().Set("Retry-After", "1")
+			writeErr(w, http.StatusServiceUnavailable, "ingest_busy", "ingest queue is full, retry shortly", map[string]any{"err": err.Error()})
+			return
+		case err != nil:
+			return // client went away while waiting for room
+		case r.URL.Query().Get("wait") == "0":
+			writeJSON(w, http.StatusAccepted, map[string]any{"id": id, "queued": len(ps)})
+			return
+		default:
+			select {
+			case err = <-done:
+			case <-ctx.Done():
+				return // the fixes are still written
+			}
+			switch {
+			case errors.Is(err, data.ErrIngestDropped):
+				w.Header().Set("Retry-After", "1")
+				writeErr(w, http.StatusServiceUnavailable, "ingest_dropped", "positions were dropped from a full ingest queue unwritten, retry", nil)
+			case err != nil:
+				writeErr(w, http.StatusInternalServerError, "db_error", "failed to store positions", map[string]any{"err": err.Error()})
+			default:
+				writeJSON(w, http.StatusOK, map[string]any{"id": id, "appended": len(ps)})
+			}
+			return
+		}
+	}
+	if err := a.Store.AppendPositions(ctx, id, ps); err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to store positions", map[string]any{"err": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "appended": len(ps)})
+}
@@ -0,0 +1,245 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/export"
+)
+
+// Logbook handles the ship's log.
+//
+//	GET    /api/logbook?track_id=|from=&to=&vessel_id=&format=json|csv|html
+//	POST   /api/logbook        {"at": "...", "vessel_id": 1, "kind": "sail_plan", "text": "..."}
+//	PUT    /api/logbook/:id    same body
+//	DELETE /api/logbook/:id
+//
+// Automatic entries are generated from the tracks and can't be edited;
+// manual entries are position-tagged from the track covering their time.
+func (a *API) Logbook(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/logbook"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			a.listLogbook(w, r)
+		case http.MethodPost:
+			a.writeLogEntry(w, r, 0)
+		default:
+			writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST", nil)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_id", "invalid log entry id", map[string]any{"id": rest})
+		return
+	}
+	switch r.Method {
+	case http.MethodPut:
+		a.writeLogEntry(w, r, id)
+	case http.MethodDelete:
+		err := a.Store.DeleteLogEntry(r.Context(), id)
+		if errors.Is(err, data.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "no manual log entry with that id", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to delete log entry", map[string]any{"err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "deleted": true})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use PUT or DELETE", nil)
+	}
+}
+
+func (a *API) listLogbook(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var lq data.LogQuery
+	if v := q.Get("track_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_id", "invalid track id", map[string]any{"track_id": v})
+			return
+		}
+		lq.TrackID = id
+	}
+	var ok bool
+	if lq.VesselID, ok = vesselParam(w, r); !ok {
+		return
+	}
+	for key, dst := range map[string]*int64{"from": &lq.From, "to": &lq.To} {
+		if v := q.Get(key); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "bad_params", key+" must be RFC3339 or epoch seconds", map[string]any{key: v})
+				return
+			}
+			*dst = t
+		}
+	}
+
+	entries, err := a.Store.Logbook(r.Context(), lq)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": lq.TrackID})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load logbook", map[string]any{"err": err.Error()})
+		return
+	}
+
+	zones := a.viewZones(r)
+	if q.Get("format") != "" && q.Get("format") != "json" {
+		zones = a.exportZones(r)
+	}
+	for i := range entries {
+		redactLogEntry(zones, &entries[i])
+	}
+
+	switch q.Get("format") {
+	case "", "json":
+		out := make([]map[string]any, 0, len(entries))
+		for _, e := range entries {
+			out = append(out, logEntryJSON(e))
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"entries": out})
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, logbookFilename(lq)))
+		if err := export.WriteLogCSV(w, entries); err != nil {
+			// Headers are gone; best effort.
+			fmt.Fprintf(w, "\n# error: %v\n", err)
+		}
+	case "html":
+		title, subtitle := a.logbookTitle(r, lq)
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := export.WriteLogHTML(w, title, subtitle, entries); err != nil {
+			fmt.Fprintf(w, "\n<!-- error: %v -->\n", err)
+		}
+	default:
+		writeErr(w, http.StatusBadRequest, "bad_params", "format must be json, csv or html", map[string]any{"format": q.Get("format")})
+	}
+}
+
+// logEntryIn is the JSON body for creating or editing a manual entry.
+type logEntryIn struct {
+	At       string `json:"at"`        // RFC3339 or epoch seconds; defaults to now
+	VesselID int64  `json:"vessel_id"` // defaults to the boat under way at At
+	Kind     string `json:"kind"`
+	Text     string `json:"text"`
+}
+
+func (a *API) writeLogEntry(w http.ResponseWriter, r *http.Request, id int64) {
+	var in logEntryIn
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", "body must be a JSON log entry", map[string]any{"err": err.Error()})
+		return
+	}
+	if !data.ManualLogKinds[in.Kind] {
+		writeErr(w, http.StatusBadRequest, "bad_kind", "kind must be crew, sail_plan, event, weather, engine or note", map[string]any{"kind": in.Kind})
+		return
+	}
+	t := time.Now().Unix()
+	if in.At != "" {
+		var err error
+		if t, err = parseTimeParam(in.At); err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", "at must be RFC3339 or epoch seconds", map[string]any{"at": in.At})
+			return
+		}
+	}
+
+	ctx := r.Context()
+	if in.VesselID != 0 {
+		if _, err := a.Store.Vessel(ctx, in.VesselID); err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", "no such vessel", map[string]any{"vessel_id": in.VesselID})
+			return
+		}
+	}
+	var e db.LogEntry
+	var err error
+	status := http.StatusOK
+	if id == 0 {
+		e, err = a.Store.AddLogEntry(ctx, t, in.VesselID, in.Kind, strings.TrimSpace(in.Text))
+		status = http.StatusCreated
+	} else {
+		e, err = a.Store.UpdateLogEntry(ctx, id, t, in.VesselID, in.Kind, strings.TrimSpace(in.Text))
+	}
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "no manual log entry with that id", map[string]any{"id": id})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to save log entry", map[string]any{"err": err.Error()})
+		return
+	}
+	writeJSON(w, status, logEntryJSON(e))
+}
+
+func logEntryJSON(e db.LogEntry) map[string]any {
+	m := map[string]any{
+		"id":   e.ID,
+		"t":    data.UnixToTime(e.T).Format(timeRFC3339),
+		"kind": e.Kind,
+		"auto": e.Auto == 1,
+		"text": e.Text,
+	}
+	if e.TrackID.Valid {
+		m["track_id"] = e.TrackID.Int64
+	}
+	if e.VesselID.Valid {
+		m["vessel_id"] = e.VesselID.Int64
+	}
+	if e.Lat.Valid && e.Lon.Valid {
+		m["lat"], m["lon"] = e.Lat.Float64, e.Lon.Float64
+	}
+	if e.CogRad.Valid {
+		m["cog_deg"] = math.Mod(e.CogRad.Float64*180/math.Pi+360, 360)
+	}
+	if e.SogMs.Valid {
+		m["sog_kn"] = e.SogMs.Float64 * 1.943844492
+	}
+	return m
+}
+
+func (a *API) logbookTitle(r *http.Request, lq data.LogQuery) (title, subtitle string) {
+	if lq.TrackID != 0 {
+		if t, err := a.Store.Track(r.Context(), lq.TrackID); err == nil {
+			if names, err := a.trackNames(r.Context(), a.viewZones(r), t.ID); err == nil {
+				return "Ship's log — " + names(t.ID, t.Name), fmt.Sprintf("Passage #%d", t.ID)
+			}
+		}
+	}
+	title = "Ship's log"
+	switch {
+	case lq.From > 0 && lq.To > 0:
+		subtitle = data.UnixToTime(lq.From).Format("2 Jan 2006") + " – " + data.UnixToTime(lq.To).Format("2 Jan 2006")
+	case lq.From > 0:
+		subtitle = "From " + data.UnixToTime(lq.From).Format("2 Jan 2006")
+	case lq.To > 0:
+		subtitle = "Until " + data.UnixToTime(lq.To).Format("2 Jan 2006")
+	default:
+		subtitle = "All entries"
+	}
+	return title, subtitle
+}
+
+func logbookFilename(lq data.LogQuery) string {
+	if lq.TrackID != 0 {
+		return fmt.Sprintf("logbook-track-%d", lq.TrackID)
+	}
+	return "logbook"
+}
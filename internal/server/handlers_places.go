@@ -0,0 +1,47 @@
+package server
+
+import (
+	"net/http"
+
+	"wakemap/internal/data"
+)
+
+// Places handles GET /api/places?vessel_id=: anchorages and berths
+// aggregated across all tracks, as a GeoJSON FeatureCollection of points.
+func (a *API) Places(w http.ResponseWriter, r *http.Request) {
+	vesselID, ok := vesselParam(w, r)
+	if !ok {
+		return
+	}
+	places, err := a.Store.Places(r.Context(), vesselID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load places", map[string]any{"err": err.Error()})
+		return
+	}
+
+	zones := a.viewZones(r)
+	features := make([]map[string]any, 0, len(places))
+	for _, p := range places {
+		lon, lat, ok := zones.Apply(p.Lon, p.Lat)
+		if !ok {
+			continue
+		}
+		features = append(features, map[string]any{
+			"type": "Feature",
+			"properties": map[string]any{
+				"visits":    p.Visits,
+				"nights":    p.Nights,
+				"total_s":   p.TotalS,
+				"first_at":  data.UnixToTime(p.FirstAt).Format(timeRFC3339),
+				"last_at":   data.UnixToTime(p.LastAt).Format(timeRFC3339),
+				"kinds":     p.Kinds,
+				"track_ids": p.TrackIDs,
+			},
+			"geometry": map[string]any{
+				"type":        "Point",
+				"coordinates": []float64{lon, lat},
+			},
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"type": "FeatureCollection", "features": features})
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/redact"
+)
+
+const (
+	maxPlaybackTracks = 8
+	maxPlaybackSpeed  = 10000
+
+	// playbackKeepalive is how often an idle stream gets an SSE comment, so
+	// proxies don't drop a paused replay.
+	playbackKeepalive = 15 * time.Second
+)
+
+// playback is one running replay. The stream goroutine owns the output;
+// control requests change the clock here and poke wake.
+type playback struct {
+	mu     sync.Mutex
+	offset float64   // seconds into the replay at since
+	since  time.Time // wall time offset was taken
+	speed  float64
+	paused bool
+	seeked bool // offset jumped; the stream must resync
+	end    float64
+	zero   int64 // epoch milliseconds at offset 0, for seeks by time
+	wake   chan struct{}
+}
+
+// now is the replay clock: seconds since the start of the replay.
+func (p *playback) now() float64 {
+	if p.paused {
+		return p.offset
+	}
+	return math.Min(p.offset+time.Since(p.since).Seconds()*p.speed, p.end)
+}
+
+func (p *playback) state() map[string]any {
+	return map[string]any{"offset_s": p.now(), "speed": p.speed, "paused": p.paused}
+}
+
+// playbackFix is one fix on the shared replay clock.
+type playbackFix struct {
+	at  float64 // seconds into the replay
+	pos db.Position
+}
+
+// Playback replays recorded tracks as Server-Sent Events, paced in real
+// time or faster, for debriefs and demos.
+//
+//	GET  /api/playback?track_ids=3,7&speed=10&from=&offset_s=&align=start|time
+//	POST /api/playback/:session {"action": "pause"|"play"|"seek"|"speed", "offset_s": 600, "at": "...", "speed": 20}
+//
+// With align=start (the default) every track starts at offset 0, so the
+// same course sailed on different days races side by side; align=time keeps
+// their real times, for boats out together. from (RFC3339 or epoch) is read
+// on the first track's clock; offset_s is seconds into the replay.
+//
+// The stream sends "session" first (its id is what control requests
+// address), then "fix" events as the clock passes them, "state" after every
+// control change, and "end" when the clock runs out. It stays open after
+// "end" so the client can seek back.
+func (a *API) Playback(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/playback"), "/")
+	if rest != "" {
+		a.controlPlayback(w, r, rest)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET", nil)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeErr(w, http.StatusInternalServerError, "no_streaming", "response can't be streamed", nil)
+		return
+	}
+	q := r.URL.Query()
+	ctx := r.Context()
+
+	raw := q.Get("track_ids")
+	var ids []int64
+	seen := map[int64]bool{}
+	for _, p := range strings.Split(raw, ",") {
+		id, err := strconv.ParseInt(strings.TrimSpace(p), 10, 64)
+		if err != nil || id <= 0 || seen[id] {
+			writeErr(w, http.StatusBadRequest, "bad_params", "track_ids must be a comma-separated list of distinct track ids", map[string]any{"track_ids": raw})
+			return
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	if len(ids) > maxPlaybackTracks {
+		writeErr(w, http.StatusBadRequest, "bad_params", fmt.Sprintf("at most %d tracks at once", maxPlaybackTracks), nil)
+		return
+	}
+	speed := 1.0
+	if v := q.Get("speed"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || !(f > 0 && f <= maxPlaybackSpeed) {
+			writeErr(w, http.StatusBadRequest, "bad_params", fmt.Sprintf("speed must be above 0 and at most %d", maxPlaybackSpeed), map[string]any{"speed": v})
+			return
+		}
+		speed = f
+	}
+	align := q.Get("align")
+	if align == "" {
+		align = "start"
+	}
+	if align != "start" && align != "time" {
+		writeErr(w, http.StatusBadRequest, "bad_params", "align must be start or time", map[string]any{"align": align})
+		return
+	}
+
+	// Fixes are read from a cursor per track as the clock reaches them;
+	// only each track's first and last visible fix are needed up front.
+	zones := a.viewZones(r)
+	names, err := a.trackNames(ctx, zones, ids...)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track names", map[string]any{"err": err.Error()})
+		return
+	}
+	tracks := make([]map[string]any, 0, len(ids))
+	cursors := make([]*playbackCursor, len(ids))
+	bases := make([]int64, len(ids)) // epoch ms
+	lasts := make([]int64, len(ids))
+	for i, id := range ids {
+		t, err := a.Store.Track(ctx, id)
+		if errors.Is(err, data.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+			return
+		}
+		cursors[i] = &playbackCursor{store: a.Store, zones: zones, id: id}
+		first, last, ok, err := cursors[i].span(ctx)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load positions", map[string]any{"err": err.Error()})
+			return
+		}
+		bases[i], lasts[i] = t.StartedAt*1000, t.StartedAt*1000
+		if ok {
+			bases[i], lasts[i] = first, last
+		}
+		tracks = append(tracks, map[string]any{"id": t.ID, "name": names(t.ID, t.Name), "started_at": data.UnixMilliToTime(bases[i]).Format(timeRFC3339Milli)})
+	}
+	starts := append([]int64(nil), bases...)
+	if align == "time" {
+		origin := bases[0]
+		for _, b := range bases {
+			origin = min(origin, b)
+		}
+		for i := range bases {
+			bases[i] = origin
+		}
+	}
+	// offset_s is where each track starts on the replay clock.
+	end := 0.0
+	for i := range ids {
+		tracks[i]["offset_s"] = float64(starts[i]-bases[i]) / 1000
+		cursors[i].base = bases[i]
+		end = math.Max(end, float64(lasts[i]-bases[i])/1000)
+	}
+
+	pb := &playback{speed: speed, since: time.Now(), end: end, zero: bases[0], wake: make(chan struct{}, 1)}
+	if v := q.Get("offset_s"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "offset_s must be seconds into the replay", map[string]any{"offset_s": v})
+			return
+		}
+		pb.offset = f
+	} else if v := q.Get("from"); v != "" {
+		from, err := parseTimeParamMs(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", "from must be RFC3339 or epoch seconds", map[string]any{"from": v})
+			return
+		}
+		pb.offset = float64(from-bases[0]) / 1000
+	}
+	pb.offset = math.Max(0, math.Min(pb.offset, pb.end))
+
+	id, err := newPlaybackID()
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "internal", "failed to start playback", map[string]any{"err": err.Error()})
+		return
+	}
+	a.playbacks.Store(id, pb)
+	defer a.playbacks.Delete(id)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-store")
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event string, v any) bool {
+		b, _ := json.Marshal(v)
+		_, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, b)
+		return err == nil
+	}
+	sendFix := func(f playbackFix) bool {
+		m := fixJSON(f.pos)
+		m["track_id"] = f.pos.TrackID
+		m["offset_s"] = f.at
+		return send("fix", m)
+	}
+	// A read failing mid-stream can't become an error response any more.
+	fail := func(err error) {
+		send("error", map[string]any{"code": "db_error", "message": err.Error()})
+	}
+	// resync sends each track's last fix at or before the clock, so boats
+	// jump to where they were, and moves every cursor to just after it.
+	resync := func(at float64) bool {
+		var lastFixes []playbackFix
+		for i := len(cursors) - 1; i >= 0; i-- { // latest first, ties last track first
+			c := cursors[i]
+			f, ok, err := c.seek(ctx, c.base+int64(math.Floor(at*1000)))
+			if err != nil {
+				fail(err)
+				return false
+			}
+			if ok {
+				lastFixes = append(lastFixes, f)
+			}
+		}
+		sort.SliceStable(lastFixes, func(i, j int) bool { return lastFixes[i].at > lastFixes[j].at })
+		for _, f := range lastFixes {
+			if !sendFix(f) {
+				return false
+			}
+		}
+		return true
+	}
+	// next is the cursor with the earliest fix to come, ties going to the
+	// track listed first; nil once every track has run out.
+	next := func() (*playbackCursor, error) {
+		var best *playbackCursor
+		for _, c := range cursors {
+			ok, err := c.fill(ctx)
+			if err != nil {
+				return nil, err
+			}
+			if ok && (best == nil || c.buf[0].at < best.buf[0].at) {
+				best = c
+			}
+		}
+		return best, nil
+	}
+
+	pb.mu.Lock()
+	st := pb.state()
+	pb.mu.Unlock()
+	if !send("session", map[string]any{"session": id, "align": align, "duration_s": pb.end, "tracks": tracks}) || !send("state", st) {
+		return
+	}
+	if !resync(st["offset_s"].(float64)) {
+		return
+	}
+	flusher.Flush()
+
+	ended := false
+	lastWrite := time.Now()
+	for {
+		pb.mu.Lock()
+		now, speed, paused := pb.now(), pb.speed, pb.paused
+		pb.mu.Unlock()
+
+		wrote := false
+		c, err := next()
+		for ; err == nil && c != nil && c.buf[0].at <= now; c, err = next() {
+			if !sendFix(c.pop()) {
+				return
+			}
+			wrote = true
+		}
+		if err != nil {
+			fail(err)
+			return
+		}
+		if c == nil && !ended {
+			if !send("end", map[string]any{"offset_s": pb.end}) {
+				return
+			}
+			ended, wrote = true, true
+		}
+		if !wrote && time.Since(lastWrite) >= playbackKeepalive {
+			if _, err := io.WriteString(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			wrote = true
+		}
+		if wrote {
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+
+		wait := playbackKeepalive
+		if !paused && c != nil {
+			wait = min(wait, time.Duration((c.buf[0].at-now)/speed*float64(time.Second)))
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		case <-pb.wake:
+			timer.Stop()
+			pb.mu.Lock()
+			st, seeked := pb.state(), pb.seeked
+			pb.seeked = false
+			pb.mu.Unlock()
+			if !send("state", st) {
+				return
+			}
+			if seeked {
+				if !resync(st["offset_s"].(float64)) {
+					return
+				}
+				ended = false
+			}
+			flusher.Flush()
+			lastWrite = time.Now()
+		}
+	}
+}
+
+// playbackChunk is how many fixes a cursor reads at a time.
+const playbackChunk = 500
+
+// playbackCursor reads one track's fixes in time order a chunk at a time,
+// redacted for the viewer, so a replay holds a chunk per track rather than
+// every fix.
+type playbackCursor struct {
+	store *data.Store
+	zones *redact.Set
+	id    int64
+	base  int64 // epoch ms at offset 0 on the replay clock
+
+	buf            []playbackFix
+	lastMs, lastID int64 // the last fix read; the next chunk starts after it
+	done           bool
+}
+
+func (c *playbackCursor) fix(p db.Position) playbackFix {
+	return playbackFix{at: float64(p.TMs-c.base) / 1000, pos: p}
+}
+
+// fill reads chunks until a fix is buffered, reporting false once the
+// track has run out.
+func (c *playbackCursor) fill(ctx context.Context) (bool, error) {
+	for len(c.buf) == 0 && !c.done {
+		ps, err := c.store.TrackPositionsAfter(ctx, c.id, c.lastMs, c.lastID, playbackChunk)
+		if err != nil {
+			return false, err
+		}
+		c.done = len(ps) < playbackChunk
+		for _, p := range ps {
+			c.lastMs, c.lastID = p.TMs, p.ID
+			if c.zones.Position(&p) {
+				c.buf = append(c.buf, c.fix(p))
+			}
+		}
+	}
+	return len(c.buf) > 0, nil
+}
+
+// span is the time of the track's first and last visible fix, which may
+// differ from its recorded start and end inside a redaction zone.
+func (c *playbackCursor) span(ctx context.Context) (first, last int64, ok bool, err error) {
+	f, ok, err := c.seek(ctx, math.MaxInt64)
+	if err != nil || !ok {
+		return 0, 0, false, err
+	}
+	last = f.pos.TMs
+	c.buf, c.done = nil, false
+	c.lastMs, c.lastID = math.MinInt64, 0
+	if ok, err = c.fill(ctx); err != nil || !ok {
+		return 0, 0, false, err
+	}
+	return c.buf[0].pos.TMs, last, true, nil
+}
+
+func (c *playbackCursor) pop() playbackFix {
+	f := c.buf[0]
+	c.buf = c.buf[1:]
+	return f
+}
+
+// seek moves the cursor to the first fix after atMs and returns the last
+// visible one at or before it, if any.
+func (c *playbackCursor) seek(ctx context.Context, atMs int64) (playbackFix, bool, error) {
+	c.buf, c.done = nil, false
+	c.lastMs, c.lastID = atMs, math.MaxInt64
+	beforeMs, beforeID := atMs, int64(math.MaxInt64)
+	for {
+		ps, err := c.store.TrackPositionsBefore(ctx, c.id, beforeMs, beforeID, playbackChunk)
+		if err != nil {
+			return playbackFix{}, false, err
+		}
+		for _, p := range ps {
+			beforeMs, beforeID = p.TMs, p.ID
+			if c.zones.Position(&p) {
+				return c.fix(p), true, nil
+			}
+		}
+		if len(ps) < playbackChunk {
+			return playbackFix{}, false, nil
+		}
+	}
+}
+
+// controlPlayback handles POST /api/playback/:session. The session id is
+// only ever sent down its own stream, so holding it is the permission.
+func (a *API) controlPlayback(w http.ResponseWriter, r *http.Request, id string) {
+	if !requirePOST(w, r) {
+		return
+	}
+	v, ok := a.playbacks.Load(id)
+	if !ok {
+		writeErr(w, http.StatusNotFound, "not_found", "no such playback session", map[string]any{"session": id})
+		return
+	}
+	pb := v.(*playback)
+
+	var in struct {
+		Action  string   `json:"action"`
+		OffsetS *float64 `json:"offset_s"`
+		At      string   `json:"at"`
+		Speed   float64  `json:"speed"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", "invalid JSON body", map[string]any{"err": err.Error()})
+		return
+	}
+
+	pb.mu.Lock()
+	defer pb.mu.Unlock()
+	now := pb.now()
+	switch in.Action {
+	case "pause":
+		pb.offset, pb.paused = now, true
+	case "play":
+		if now >= pb.end {
+			now = 0 // play after the end starts over
+			pb.seeked = true
+		}
+		pb.offset, pb.paused = now, false
+	case "seek":
+		switch {
+		case in.OffsetS != nil:
+			now = *in.OffsetS
+		case in.At != "":
+			at, err := parseTimeParamMs(in.At)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "bad_params", "at must be RFC3339 or epoch seconds", map[string]any{"at": in.At})
+				return
+			}
+			// The session event's first started_at is the clock's zero.
+			now = float64(at-pb.zero) / 1000
+		default:
+			writeErr(w, http.StatusBadRequest, "bad_params", "seek needs offset_s or at", nil)
+			return
+		}
+		pb.offset, pb.seeked = math.Max(0, math.Min(now, pb.end)), true
+	case "speed":
+		if !(in.Speed > 0 && in.Speed <= maxPlaybackSpeed) {
+			writeErr(w, http.StatusBadRequest, "bad_params", fmt.Sprintf("speed must be above 0 and at most %d", maxPlaybackSpeed), map[string]any{"speed": in.Speed})
+			return
+		}
+		pb.offset, pb.speed = now, in.Speed
+	default:
+		writeErr(w, http.StatusBadRequest, "bad_params", "action must be pause, play, seek or speed", map[string]any{"action": in.Action})
+		return
+	}
+	pb.since = time.Now()
+	select {
+	case pb.wake <- struct{}{}:
+	default:
+	}
+	out := pb.state()
+	out["session"] = id
+	writeJSON(w, http.StatusOK, out)
+}
+
+func newPlaybackID() (string, error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,96 @@
+package server
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+)
+
+// PositionAt handles GET /api/position?at=<RFC3339|epoch>&vessel_id=: where
+// the boat was at that moment, interpolated between the surrounding fixes.
+// gap_s and gap_m say how far apart those fixes were, i.e. how much to
+// trust it.
+func (a *API) PositionAt(w http.ResponseWriter, r *http.Request) {
+	atMs, ok := requiredTimeParamMs(w, r, "at")
+	if !ok {
+		return
+	}
+	vesselID, ok := vesselParam(w, r)
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	if _, err := a.Store.RefreshStaleSummaries(ctx); err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to refresh track summaries", map[string]any{"err": err.Error()})
+		return
+	}
+
+	fix, err := a.Store.PositionAt(ctx, atMs, vesselID)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "no track covers that time", map[string]any{"at": data.UnixMilliToTime(atMs).Format(timeRFC3339Milli)})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to look up position", map[string]any{"err": err.Error()})
+		return
+	}
+	// A hidden fix reads as no fix; a blurred one loses course and speed.
+	zones := a.viewZones(r)
+	lon, lat, ok := zones.Apply(fix.Lon, fix.Lat)
+	if !ok || !zones.Position(&fix.Before) || !zones.Position(&fix.After) {
+		writeErr(w, http.StatusNotFound, "not_found", "no position to show at that time", map[string]any{"at": data.UnixMilliToTime(atMs).Format(timeRFC3339Milli)})
+		return
+	}
+	if lon != fix.Lon || lat != fix.Lat {
+		fix.Lon, fix.Lat = lon, lat
+		fix.SogMs.Valid, fix.CogRad.Valid = false, false
+	}
+	names, err := a.trackNames(ctx, zones, fix.TrackID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track name", map[string]any{"err": err.Error()})
+		return
+	}
+
+	out := map[string]any{
+		"track_id": fix.TrackID,
+		"name":     names(fix.TrackID, fix.TrackName),
+		"at":       data.UnixMilliToTime(fix.TMs).Format(timeRFC3339Milli),
+		"lon":      fix.Lon,
+		"lat":      fix.Lat,
+		"exact":    fix.GapS() == 0,
+		"gap_s":    fix.GapS(),
+		"gap_m":    fix.GapM(),
+		"before":   fixJSON(fix.Before),
+		"after":    fixJSON(fix.After),
+	}
+	if fix.VesselID != 0 {
+		out["vessel_id"] = fix.VesselID
+	}
+	if fix.SogMs.Valid {
+		out["sog_ms"] = fix.SogMs.Float64
+		out["sog_kn"] = fix.SogMs.Float64 * 1.943844492
+	}
+	if fix.CogRad.Valid {
+		out["cog_deg"] = fix.CogRad.Float64 * 180 / math.Pi
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func fixJSON(p db.Position) map[string]any {
+	m := map[string]any{
+		"t":    data.UnixMilliToTime(p.TMs).Format(timeRFC3339Milli),
+		"t_ms": p.TMs,
+		"lon":  p.Lon,
+		"lat":  p.Lat,
+	}
+	if p.SogMs.Valid {
+		m["sog_ms"] = p.SogMs.Float64
+	}
+	if p.CogRad.Valid {
+		m["cog_deg"] = p.CogRad.Float64 * 180 / math.Pi
+	}
+	return m
+}
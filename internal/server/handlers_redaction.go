@@ -0,0 +1,271 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/redact"
+)
+
+// ownerCookie carries the owner token for browsers, which can't add an
+// Authorization header to tile and image requests.
+const ownerCookie = "wakemap_owner"
+
+// isOwner reports whether r is from the server's owner: it carries
+// OwnerToken as a bearer token or in the owner cookie. With no OwnerToken
+// configured everyone on the network is the owner.
+func (a *API) isOwner(r *http.Request) bool {
+	if a.OwnerToken == "" {
+		return true
+	}
+	tok, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		if c, err := r.Cookie(ownerCookie); err == nil {
+			tok = c.Value
+		}
+	}
+	return subtle.ConstantTimeCompare([]byte(tok), []byte(a.OwnerToken)) == 1
+}
+
+// OwnerLogin handles POST /api/owner {"token": "..."}, setting the owner
+// cookie so a browser sees unredacted views; DELETE clears it.
+func (a *API) OwnerLogin(w http.ResponseWriter, r *http.Request) {
+	c := &http.Cookie{Name: ownerCookie, Path: "/", HttpOnly: true, SameSite: http.SameSiteStrictMode}
+	switch r.Method {
+	case http.MethodPost:
+		var in struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&in); err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_json", `body must be {"token": "..."}`, map[string]any{"err": err.Error()})
+			return
+		}
+		if a.OwnerToken == "" || subtle.ConstantTimeCompare([]byte(in.Token), []byte(a.OwnerToken)) != 1 {
+			writeErr(w, http.StatusForbidden, "forbidden", "wrong owner token", nil)
+			return
+		}
+		c.Value = in.Token
+		c.MaxAge = 365 * 24 * 3600
+	case http.MethodDelete:
+		c.MaxAge = -1
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use POST or DELETE", nil)
+		return
+	}
+	http.SetCookie(w, c)
+	writeJSON(w, http.StatusOK, map[string]any{"owner": c.MaxAge > 0})
+}
+
+// viewZones is the redaction to apply to a map or API response: none for
+// the owner, the private zones for anyone else.
+func (a *API) viewZones(r *http.Request) *redact.Set {
+	if a.isOwner(r) {
+		return nil
+	}
+	return a.Zones
+}
+
+// exportZones is the redaction to apply to a download, which is made to be
+// passed on: always the private zones, unless the owner asks for ?raw=1.
+func (a *API) exportZones(r *http.Request) *redact.Set {
+	if a.isOwner(r) && r.URL.Query().Get("raw") == "1" {
+		return nil
+	}
+	return a.Zones
+}
+
+// showsExactly reports whether zones leave lon/lat where it is, so a
+// place named for it gives nothing away.
+func showsExactly(zones *redact.Set, lon, lat float64) bool {
+	rlon, rlat, ok := zones.Apply(lon, lat)
+	return ok && rlon == lon && rlat == lat
+}
+
+// viewRoute is route as someone seeing zones may know it: a place name
+// goes when zones hide or move the end it was resolved for.
+func viewRoute(zones *redact.Set, route data.TrackRoute) data.TrackRoute {
+	if zones.Empty() {
+		return route
+	}
+	if route.From != "" && !showsExactly(zones, route.FromLon, route.FromLat) {
+		route.From = ""
+	}
+	if route.To != "" && !showsExactly(zones, route.ToLon, route.ToLat) {
+		route.To = ""
+	}
+	return route
+}
+
+// trackNames returns a function giving the names of ids as someone seeing
+// zones may know them: a name generated from a route is rebuilt from the
+// places they may see, or from the date if none. Other names pass through.
+func (a *API) trackNames(ctx context.Context, zones *redact.Set, ids ...int64) (func(id int64, name string) string, error) {
+	same := func(_ int64, name string) string { return name }
+	if zones.Empty() || len(ids) == 0 {
+		return same, nil
+	}
+	named, err := a.Store.NamedRoutes(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+	return func(id int64, name string) string {
+		nr, ok := named[id]
+		if !ok {
+			return name
+		}
+		if n := viewRoute(zones, nr.Route).Name(); n != "" {
+			return n
+		}
+		return "Track " + data.UnixToTime(nr.StartedAt).Format("2006-01-02")
+	}, nil
+}
+
+// viewTrackName is ts with its name as someone seeing zones may know it;
+// ts itself, which may be cached, is left alone.
+func (a *API) viewTrackName(w http.ResponseWriter, r *http.Request, zones *redact.Set, id int64, ts *data.TrackStats) (*data.TrackStats, bool) {
+	names, err := a.trackNames(r.Context(), zones, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track name", map[string]any{"err": err.Error()})
+		return nil, false
+	}
+	if n := names(id, ts.Name); n != ts.Name {
+		c := *ts
+		c.Name = n
+		ts = &c
+	}
+	return ts, true
+}
+
+// requireOwner writes a 403 unless r is from the owner.
+func (a *API) requireOwner(w http.ResponseWriter, r *http.Request) bool {
+	if a.isOwner(r) {
+		return true
+	}
+	writeErr(w, http.StatusForbidden, "forbidden", "owner token required", nil)
+	return false
+}
+
+// requireOwnerToWrite lets anyone read but only the owner change data:
+// it writes a 403 for a non-GET request not from the owner.
+func (a *API) requireOwnerToWrite(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		return true
+	}
+	return a.requireOwner(w, r)
+}
+
+// RedactionZones edits the private zones kept out of exports and public
+// views. Zones are GeoJSON Polygon or MultiPolygon features with optional
+// "name", "mode" (clip or jitter) and "jitter_m" properties. Owner only.
+//
+//	GET    /api/redaction-zones
+//	POST   /api/redaction-zones       a Feature
+//	GET    /api/redaction-zones/:id
+//	PUT    /api/redaction-zones/:id   a Feature; replaces the zone
+//	DELETE /api/redaction-zones/:id
+func (a *API) RedactionZones(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwner(w, r) {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/redaction-zones"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			zones := a.Zones.Zones()
+			features := make([]map[string]any, 0, len(zones))
+			for _, z := range zones {
+				features = append(features, z.Feature())
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"type": "FeatureCollection", "features": features})
+		case http.MethodPost:
+			a.writeRedactionZone(w, r, 0)
+		default:
+			writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST", nil)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil || id <= 0 {
+		writeErr(w, http.StatusBadRequest, "bad_id", "invalid zone id", map[string]any{"id": rest})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		z, err := a.Zones.Zone(id)
+		if err != nil {
+			writeErr(w, http.StatusNotFound, "not_found", "zone not found", map[string]any{"id": id})
+			return
+		}
+		writeJSON(w, http.StatusOK, z.Feature())
+	case http.MethodPut:
+		a.writeRedactionZone(w, r, id)
+	case http.MethodDelete:
+		err := a.Zones.Delete(id)
+		if errors.Is(err, redact.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "zone not found", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "save_error", "failed to save zones", map[string]any{"err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "deleted": true})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET, PUT or DELETE", nil)
+	}
+}
+
+func (a *API) writeRedactionZone(w http.ResponseWriter, r *http.Request, id int64) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, 4<<20))
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_body", "failed to read body", map[string]any{"err": err.Error()})
+		return
+	}
+	z, err := redact.ParseFeature(body)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_zone", err.Error(), nil)
+		return
+	}
+	z.ID = id
+	z, err = a.Zones.Put(z)
+	if errors.Is(err, redact.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "zone not found", map[string]any{"id": id})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "save_error", "failed to save zones", map[string]any{"err": err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if id == 0 {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, z.Feature())
+}
+
+// redactLogEntry hides where a log entry was written if that was inside a
+// zone. The entry itself stays; its position goes or is blurred, and so
+// does any place its generated text names.
+func redactLogEntry(z *redact.Set, e *db.LogEntry) {
+	if !e.Lon.Valid || !e.Lat.Valid {
+		return
+	}
+	lon, lat, ok := z.Apply(e.Lon.Float64, e.Lat.Float64)
+	if lon == e.Lon.Float64 && lat == e.Lat.Float64 && ok {
+		return
+	}
+	e.Lon = sql.NullFloat64{Float64: lon, Valid: ok}
+	e.Lat = sql.NullFloat64{Float64: lat, Valid: ok}
+	e.CogRad.Valid, e.SogMs.Valid = false, false
+	data.UnnamePlace(e)
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"wakemap/internal/data"
+)
+
+// maxSearchRadiusM keeps ?near= queries to a harbour-sized area.
+const maxSearchRadiusM = 50000
+
+// SearchTracks handles GET /api/search/tracks: which tracks passed through
+// an area, when, and how close they came.
+//
+//	?bbox=minLon,minLat,maxLon,maxLat
+//	?near=lon,lat&radius=500        radius in metres (default 500)
+//	&limit=50&vessel_id=
+func (a *API) SearchTracks(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	var area data.AreaQuery
+	switch {
+	case q.Get("bbox") != "":
+		bb, err := parseBBox(q.Get("bbox"))
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", err.Error(), map[string]any{"bbox": q.Get("bbox")})
+			return
+		}
+		area = data.BBoxArea(bb)
+	case q.Get("near") != "":
+		lon, lat, ok := parseLonLat(q.Get("near"))
+		if !ok {
+			writeErr(w, http.StatusBadRequest, "bad_params", "near must be lon,lat", map[string]any{"near": q.Get("near")})
+			return
+		}
+		radius := 500.0
+		if v := q.Get("radius"); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f <= 0 || f > maxSearchRadiusM {
+				writeErr(w, http.StatusBadRequest, "bad_params", "radius must be 0 < metres <= 50000", map[string]any{"radius": v})
+				return
+			}
+			radius = f
+		}
+		area = data.NearArea(lon, lat, radius)
+	default:
+		writeErr(w, http.StatusBadRequest, "missing_params", "bbox or near is required", nil)
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "limit must be a positive integer", map[string]any{"limit": v})
+			return
+		}
+		area.Limit = n
+	}
+	var ok bool
+	if area.VesselID, ok = vesselParam(w, r); !ok {
+		return
+	}
+
+	zones := a.viewZones(r)
+	if !zones.Empty() {
+		area.Exclude = zones.Hides
+	}
+
+	hits, err := a.Store.SearchTracks(r.Context(), area)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to search tracks", map[string]any{"err": err.Error()})
+		return
+	}
+	ids := make([]int64, len(hits))
+	for i, h := range hits {
+		ids[i] = h.TrackID
+	}
+	names, err := a.trackNames(r.Context(), zones, ids...)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track names", map[string]any{"err": err.Error()})
+		return
+	}
+
+	type outPass struct {
+		EnteredAt string `json:"entered_at"`
+		ExitedAt  string `json:"exited_at"`
+		DurationS int64  `json:"duration_s"`
+		Points    int    `json:"points"`
+	}
+	type outHit struct {
+		ID        int64     `json:"id"`
+		Name      string    `json:"name"`
+		EnteredAt string    `json:"entered_at"`
+		ExitedAt  string    `json:"exited_at"`
+		InsideS   int64     `json:"inside_s"`
+		Points    int       `json:"points"`
+		Passes    []outPass `json:"passes"`
+		Closest   any       `json:"closest"`
+	}
+	out := make([]outHit, 0, len(hits))
+	for _, h := range hits {
+		oh := outHit{
+			ID:        h.TrackID,
+			Name:      names(h.TrackID, h.Name),
+			EnteredAt: data.UnixToTime(h.EnteredAt).Format(timeRFC3339),
+			ExitedAt:  data.UnixToTime(h.ExitedAt).Format(timeRFC3339),
+			Points:    h.Points,
+			Passes:    make([]outPass, 0, len(h.Passes)),
+			Closest: map[string]any{
+				"t":          data.UnixToTime(h.ClosestT).Format(timeRFC3339),
+				"lon":        h.ClosestLon,
+				"lat":        h.ClosestLat,
+				"distance_m": h.ClosestM,
+			},
+		}
+		for _, p := range h.Passes {
+			oh.InsideS += p.ExitedAt - p.EnteredAt
+			oh.Passes = append(oh.Passes, outPass{
+				EnteredAt: data.UnixToTime(p.EnteredAt).Format(timeRFC3339),
+				ExitedAt:  data.UnixToTime(p.ExitedAt).Format(timeRFC3339),
+				DurationS: p.ExitedAt - p.EnteredAt,
+				Points:    p.Points,
+			})
+		}
+		out = append(out, oh)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"tracks": out})
+}
+
+// parseLonLat parses "lon,lat".
+func parseLonLat(v string) (lon, lat float64, ok bool) {
+	parts := strings.Split(v, ",")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	lon, err1 := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+	lat, err2 := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err1 != nil || err2 != nil || lon < -180 || lon > 180 || lat < -90 || lat > 90 {
+		return 0, 0, false
+	}
+	return lon, lat, true
+}
@@ -0,0 +1,355 @@
+package server
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/export"
+	"wakemap/internal/redact"
+)
+
+// maxShareHide caps the private spots one share can hide.
+const maxShareHide = 50
+
+// Shares manages public links to chosen tracks. Owner only.
+//
+//	GET    /api/shares
+//	POST   /api/shares      {"track_ids": [3, 4], "label": "...", "expires_in_s": 604800,
+//	                         "delay_s": 21600, "hide": [{"lon": 151.2, "lat": -33.8, "radius_m": 500}]}
+//	GET    /api/shares/:id
+//	DELETE /api/shares/:id  revokes the link
+//
+// expires_at (RFC3339 or epoch seconds) may be given instead of
+// expires_in_s; neither means the link never expires. delay_s holds back
+// fixes newer than that, for tracks still being logged.
+func (a *API) Shares(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwner(w, r) {
+		return
+	}
+	ctx := r.Context()
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/shares"), "/")
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			shares, err := a.Store.Shares(ctx)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "db_error", "failed to list shares", map[string]any{"err": err.Error()})
+				return
+			}
+			out := make([]map[string]any, 0, len(shares))
+			for _, sh := range shares {
+				m, err := a.shareJSON(r, sh)
+				if err != nil {
+					writeErr(w, http.StatusInternalServerError, "db_error", "failed to sign share", map[string]any{"err": err.Error()})
+					return
+				}
+				out = append(out, m)
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"shares": out})
+		case http.MethodPost:
+			a.createShare(w, r)
+		default:
+			writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST", nil)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_id", "invalid share id", map[string]any{"id": rest})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodDelete:
+		err := a.Store.RevokeShare(ctx, id)
+		if errors.Is(err, data.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "share not found", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to revoke share", map[string]any{"err": err.Error()})
+			return
+		}
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or DELETE", nil)
+		return
+	}
+	sh, err := a.Store.Share(ctx, id)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "share not found", map[string]any{"id": id})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load share", map[string]any{"err": err.Error()})
+		return
+	}
+	m, err := a.shareJSON(r, sh)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to sign share", map[string]any{"err": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+func (a *API) createShare(w http.ResponseWriter, r *http.Request) {
+	var in struct {
+		TrackIDs   []int64         `json:"track_ids"`
+		Label      string          `json:"label"`
+		ExpiresAt  string          `json:"expires_at"`
+		ExpiresInS int64           `json:"expires_in_s"`
+		DelayS     int64           `json:"delay_s"`
+		Hide       []redact.Circle `json:"hide"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", "body must be a JSON share", map[string]any{"err": err.Error()})
+		return
+	}
+	if len(in.TrackIDs) == 0 {
+		writeErr(w, http.StatusBadRequest, "bad_params", "track_ids must list at least one track", nil)
+		return
+	}
+	sh := db.Share{DelayS: in.DelayS}
+	if l := strings.TrimSpace(in.Label); l != "" {
+		sh.Label = sql.NullString{String: l, Valid: true}
+	}
+	now := time.Now().Unix()
+	switch {
+	case in.ExpiresAt != "" && in.ExpiresInS != 0:
+		writeErr(w, http.StatusBadRequest, "bad_params", "give expires_at or expires_in_s, not both", nil)
+		return
+	case in.ExpiresAt != "":
+		t, err := parseTimeParam(in.ExpiresAt)
+		if err != nil || t <= now {
+			writeErr(w, http.StatusBadRequest, "bad_params", "expires_at must be a future RFC3339 time or epoch seconds", map[string]any{"expires_at": in.ExpiresAt})
+			return
+		}
+		sh.ExpiresAt = sql.NullInt64{Int64: t, Valid: true}
+	case in.ExpiresInS < 0:
+		writeErr(w, http.StatusBadRequest, "bad_params", "expires_in_s must be positive", map[string]any{"expires_in_s": in.ExpiresInS})
+		return
+	case in.ExpiresInS > 0:
+		sh.ExpiresAt = sql.NullInt64{Int64: now + in.ExpiresInS, Valid: true}
+	}
+	if in.DelayS < 0 || in.DelayS > 30*86400 {
+		writeErr(w, http.StatusBadRequest, "bad_params", "delay_s must be between 0 and 30 days", map[string]any{"delay_s": in.DelayS})
+		return
+	}
+	if len(in.Hide) > maxShareHide {
+		writeErr(w, http.StatusBadRequest, "bad_params", fmt.Sprintf("hide takes at most %d places", maxShareHide), nil)
+		return
+	}
+	for i, c := range in.Hide {
+		if c.Lon < -180 || c.Lon > 180 || c.Lat < -90 || c.Lat > 90 || c.RadiusM <= 0 || c.RadiusM > 50000 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "hide places need lon/lat in range and 0 < radius_m <= 50000", map[string]any{"index": i})
+			return
+		}
+	}
+	if len(in.Hide) > 0 {
+		b, _ := json.Marshal(in.Hide)
+		sh.Hide = sql.NullString{String: string(b), Valid: true}
+	}
+
+	share, err := a.Store.CreateShare(r.Context(), sh, in.TrackIDs)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusBadRequest, "bad_params", err.Error(), nil)
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to create share", map[string]any{"err": err.Error()})
+		return
+	}
+	m, err := a.shareJSON(r, share)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to sign share", map[string]any{"err": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusCreated, m)
+}
+
+func (a *API) shareJSON(r *http.Request, sh data.Share) (map[string]any, error) {
+	token, err := a.Store.ShareToken(r.Context(), sh.Share)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{
+		"id":         sh.ID,
+		"token":      token,
+		"url":        "/share/" + token,
+		"created_at": data.UnixToTime(sh.CreatedAt).Format(timeRFC3339),
+		"delay_s":    sh.DelayS,
+		"track_ids":  sh.TrackIDs,
+		"live":       sh.Live(time.Now().Unix()),
+	}
+	if sh.TrackIDs == nil {
+		m["track_ids"] = []int64{}
+	}
+	if sh.Label.Valid {
+		m["label"] = sh.Label.String
+	}
+	if sh.ExpiresAt.Valid {
+		m["expires_at"] = data.UnixToTime(sh.ExpiresAt.Int64).Format(timeRFC3339)
+	}
+	if sh.RevokedAt.Valid {
+		m["revoked_at"] = data.UnixToTime(sh.RevokedAt.Int64).Format(timeRFC3339)
+	}
+	if sh.Hide.Valid {
+		m["hide"] = json.RawMessage(sh.Hide.String)
+	}
+	return m, nil
+}
+
+// SharedView is the public side of a share link. It answers only for the
+// link's tracks, always redacted and held back by the share's delay.
+//
+//	GET /share/:token                       the shared tracks
+//	GET /share/:token/tracks/:id.geojson    one track (?tolerance= or ?zoom= to simplify)
+//	GET /share/:token/tracks/:id.kml
+func (a *API) SharedView(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET", nil)
+		return
+	}
+	// Keep the token out of Referer headers sent to tile servers and the like.
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("Cache-Control", "no-store")
+
+	token, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/share/"), "/")
+	ctx := r.Context()
+	sh, err := a.Store.ShareByToken(ctx, token)
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "not_found", "no such share", nil)
+		return
+	case errors.Is(err, data.ErrShareGone):
+		writeErr(w, http.StatusGone, "gone", "this link has expired or been revoked", nil)
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load share", map[string]any{"err": err.Error()})
+		return
+	}
+
+	var hide []redact.Circle
+	if sh.Hide.Valid {
+		if err := json.Unmarshal([]byte(sh.Hide.String), &hide); err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "bad hidden places on share", map[string]any{"err": err.Error()})
+			return
+		}
+	}
+	zones := a.Zones.With(hide)
+	var until int64
+	if sh.DelayS > 0 {
+		until = time.Now().Unix() - sh.DelayS
+	}
+
+	if rest == "" {
+		out := make([]map[string]any, 0, len(sh.TrackIDs))
+		for _, id := range sh.TrackIDs {
+			ts, ok := a.sharedTrackStats(w, r, id, until, zones)
+			if !ok {
+				return
+			}
+			if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
+				return
+			}
+			if len(ts.Coords) == 0 {
+				continue // nothing old enough, or outside private places, to show
+			}
+			out = append(out, map[string]any{
+				"id":          id,
+				"name":        ts.Name,
+				"started_at":  data.UnixToTime(ts.StartedAt).Format(timeRFC3339),
+				"ended_at":    data.UnixToTime(ts.EndedAt).Format(timeRFC3339),
+				"distance_nm": ts.DistanceM / 1852.0,
+				"duration_s":  ts.DurationS(),
+				"bbox":        []float64{ts.MinX, ts.MinY, ts.MaxX, ts.MaxY},
+				"geojson":     fmt.Sprintf("/share/%s/tracks/%d.geojson", token, id),
+			})
+		}
+		m := map[string]any{"tracks": out}
+		if sh.Label.Valid {
+			m["label"] = sh.Label.String
+		}
+		if sh.ExpiresAt.Valid {
+			m["expires_at"] = data.UnixToTime(sh.ExpiresAt.Int64).Format(timeRFC3339)
+		}
+		if until > 0 {
+			m["until"] = data.UnixToTime(until).Format(timeRFC3339)
+		}
+		writeJSON(w, http.StatusOK, m)
+		return
+	}
+
+	file, ok := strings.CutPrefix(rest, "tracks/")
+	ext := path.Ext(file)
+	id, err := strconv.ParseInt(strings.TrimSuffix(file, ext), 10, 64)
+	if !ok || err != nil || (ext != ".geojson" && ext != ".kml") {
+		writeErr(w, http.StatusNotFound, "not_found", "unknown share resource", map[string]any{"path": r.URL.Path})
+		return
+	}
+	shared := false
+	for _, t := range sh.TrackIDs {
+		shared = shared || t == id
+	}
+	if !shared {
+		writeErr(w, http.StatusNotFound, "not_found", "track not in this share", map[string]any{"id": id})
+		return
+	}
+
+	ts, ok := a.sharedTrackStats(w, r, id, until, nil)
+	if !ok {
+		return
+	}
+	if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
+		return
+	}
+	if ext == ".kml" {
+		w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%d.kml"`, id))
+		_ = export.WriteKML(w, id, zones.TrackStats(ts))
+		return
+	}
+	tol, ok := a.simplifyTolerance(w, r, id)
+	if !ok {
+		return
+	}
+	gaps, ok := gapRule(w, r)
+	if !ok {
+		return
+	}
+	full, ok := geojsonFormat(w, r)
+	if !ok {
+		return
+	}
+	raw := len(ts.Coords)
+	ts.MarkGaps(gaps)
+	if tol > 0 {
+		ts = data.SimplifyTrackStats(ts, tol)
+	}
+	writeTrackGeoJSON(w, id, zones.TrackStats(ts), raw, tol, full)
+}
+
+// sharedTrackStats loads a shared track as it stood at until (0 = now),
+// redacted by zones when given.
+func (a *API) sharedTrackStats(w http.ResponseWriter, r *http.Request, id, until int64, zones *redact.Set) (*data.TrackStats, bool) {
+	ts, err := a.Store.ComputeTrackStatsUntil(r.Context(), id, until)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		return nil, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return nil, false
+	}
+	return zones.TrackStats(ts), true
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"wakemap/internal/data"
+	"wakemap/internal/redact"
+)
+
+// CruiseStats handles GET /api/stats?group=month|year&from=&to=&year=&vessel_id=&top=:
+// distance, hours underway, nights at anchor or moored and passages across
+// the archive, in total and per month or year, with the longest passage,
+// the best 24-hour run and the top destinations. year=2025 is shorthand for
+// that calendar year grouped by month, i.e. an annual summary.
+func (a *API) CruiseStats(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	vesselID, ok := vesselParam(w, r)
+	if !ok {
+		return
+	}
+	cq := data.CruiseQuery{VesselID: vesselID, Group: q.Get("group"), Top: 10}
+	if v := q.Get("year"); v != "" {
+		y, err := strconv.Atoi(v)
+		if err != nil || y < 1970 || y > 9999 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "year must be a calendar year", map[string]any{"year": v})
+			return
+		}
+		cq.From = time.Date(y, 1, 1, 0, 0, 0, 0, time.UTC).Unix()
+		cq.To = time.Date(y+1, 1, 1, 0, 0, 0, 0, time.UTC).Unix() - 1
+		if cq.Group == "" {
+			cq.Group = data.GroupMonth
+		}
+	}
+	for key, dst := range map[string]*int64{"from": &cq.From, "to": &cq.To} {
+		if v := q.Get(key); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "bad_params", key+" must be RFC3339 or epoch seconds", map[string]any{key: v})
+				return
+			}
+			*dst = t
+		}
+	}
+	switch cq.Group {
+	case "", "none":
+		cq.Group = ""
+	case data.GroupMonth, data.GroupYear:
+	default:
+		writeErr(w, http.StatusBadRequest, "bad_params", "group must be month, year or none", map[string]any{"group": cq.Group})
+		return
+	}
+	if v := q.Get("top"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 100 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "top must be 0..100", map[string]any{"top": v})
+			return
+		}
+		cq.Top = n
+	}
+
+	st, err := a.Store.CruiseStats(r.Context(), cq)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to compute statistics", map[string]any{"err": err.Error()})
+		return
+	}
+
+	zones := a.viewZones(r)
+	out := map[string]any{"totals": cruiseTotalsJSON(st.Totals)}
+	if cq.From > 0 {
+		out["from"] = data.UnixToTime(cq.From).Format(timeRFC3339)
+	}
+	if cq.To > 0 {
+		out["to"] = data.UnixToTime(cq.To).Format(timeRFC3339)
+	}
+	if cq.Group != "" {
+		periods := make([]map[string]any, 0, len(st.Periods))
+		for _, p := range st.Periods {
+			m := cruiseTotalsJSON(p.CruiseTotals)
+			m["period"] = p.Key
+			m["from"] = data.UnixToTime(p.From).Format(timeRFC3339)
+			m["to"] = data.UnixToTime(p.To).Format(timeRFC3339)
+			periods = append(periods, m)
+		}
+		out["group"] = cq.Group
+		out["periods"] = periods
+	}
+	if p := st.LongestPassage; p != nil {
+		m := map[string]any{
+			"track_id":    p.TrackID,
+			"started_at":  data.UnixToTime(p.StartedAt).Format(timeRFC3339),
+			"ended_at":    data.UnixToTime(p.EndedAt).Format(timeRFC3339),
+			"distance_m":  p.DistanceM,
+			"distance_nm": p.DistanceM / 1852.0,
+			"underway_s":  p.UnderwayS,
+		}
+		addPassageEnd(m, zones, "from", p.FromLon, p.FromLat, p.FromName)
+		addPassageEnd(m, zones, "to", p.ToLon, p.ToLat, p.ToName)
+		out["longest_passage"] = m
+	}
+	if b := st.BestRun; b != nil {
+		out["best_24h_run"] = map[string]any{
+			"track_id":    b.TrackID,
+			"started_at":  data.UnixToTime(b.StartedAt).Format(timeRFC3339),
+			"distance_m":  b.DistanceM,
+			"distance_nm": b.DistanceM / 1852.0,
+		}
+	}
+	dests := make([]map[string]any, 0, len(st.Destinations))
+	for _, d := range st.Destinations {
+		lon, lat, ok := zones.Apply(d.Lon, d.Lat)
+		if !ok {
+			continue
+		}
+		m := map[string]any{
+			"lon":      lon,
+			"lat":      lat,
+			"arrivals": d.Arrivals,
+			"nights":   d.Nights,
+			"last_at":  data.UnixToTime(d.LastAt).Format(timeRFC3339),
+		}
+		if d.Name != "" && lon == d.Lon && lat == d.Lat {
+			m["name"] = d.Name
+		}
+		dests = append(dests, m)
+	}
+	out["top_destinations"] = dests
+	writeJSON(w, http.StatusOK, out)
+}
+
+func cruiseTotalsJSON(t data.CruiseTotals) map[string]any {
+	return map[string]any{
+		"distance_m":      t.DistanceM,
+		"distance_nm":     t.DistanceM / 1852.0,
+		"underway_s":      t.UnderwayS,
+		"hours_underway":  float64(t.UnderwayS) / 3600,
+		"nights_anchored": t.NightsAnchored,
+		"nights_moored":   t.NightsMoored,
+		"passages":        t.Passages,
+	}
+}
+
+// addPassageEnd adds one end of a passage as <key>: [lon, lat] and
+// <key>_name, leaving out whatever redaction hides.
+func addPassageEnd(m map[string]any, zones *redact.Set, key string, lon, lat float64, name string) {
+	rlon, rlat, ok := zones.Apply(lon, lat)
+	if !ok {
+		return
+	}
+	m[key] = []float64{rlon, rlat}
+	if name != "" && rlon == lon && rlat == lat {
+		m[key+"_name"] = name
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"wakemap/internal/data"
+	"wakemap/internal/tiles"
+)
+
+// Tile buffer in tile units, so line joins and caps aren't cut at edges.
+const tileBuffer = 64
+
+// TrackTile handles GET /tiles/tracks/{z}/{x}/{y}.mvt: every live track as
+// a "tracks" linestring layer, simplified for the zoom. Features carry the
+// track id (also as the feature id), name, start/end time, year and
+// distance for styling and click-through. ?vessel_id= shows one boat's
+// tracks; each filter, and the redacted public view, gets its own cache so
+// they don't evict each other.
+func (a *API) TrackTile(w http.ResponseWriter, r *http.Request) {
+	t, err := tiles.ParseTileID(strings.TrimPrefix(r.URL.Path, "/tiles/tracks/"), ".mvt")
+	if err != nil {
+		writeErr(w, http.StatusNotFound, "not_found", err.Error(), map[string]any{"path": r.URL.Path})
+		return
+	}
+	vesselID, ok := vesselParam(w, r)
+	if !ok {
+		return
+	}
+	cache := a.Tiles
+	zones := a.viewZones(r)
+	if !zones.Empty() {
+		cache = cache.Sub("redacted")
+	}
+	if vesselID != 0 {
+		cache = cache.Sub(fmt.Sprintf("vessel-%d", vesselID))
+	}
+	ctx := r.Context()
+	if _, err := a.Store.RefreshStaleSummaries(ctx); err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to refresh track summaries", map[string]any{"err": err.Error()})
+		return
+	}
+
+	bb := t.Bounds(tileBuffer, tiles.DefaultExtent)
+	fp, err := a.Store.TileFingerprint(ctx, bb, vesselID)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to fingerprint tile", map[string]any{"err": err.Error()})
+		return
+	}
+	if v := zones.Version(); v != "" {
+		fp += "-" + v
+	}
+	etag := `"` + fp + `"`
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	b, ok := cache.Get(t, fp)
+	if !ok {
+		lat := (bb[1] + bb[3]) / 2
+		lines, err := a.Store.TileTracks(ctx, bb, data.ToleranceForZoom(float64(t.Z), lat), vesselID)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load tile", map[string]any{"err": err.Error()})
+			return
+		}
+		ids := make([]int64, len(lines))
+		for i, ln := range lines {
+			ids[i] = ln.TrackID
+		}
+		names, err := a.trackNames(ctx, zones, ids...)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track names", map[string]any{"err": err.Error()})
+			return
+		}
+		layer := tiles.NewLayer("tracks", tiles.DefaultExtent)
+		for _, ln := range lines {
+			started := data.UnixToTime(ln.StartedAt)
+			props := map[string]any{
+				"id":          ln.TrackID,
+				"name":        names(ln.TrackID, ln.Name),
+				"started_at":  ln.StartedAt,
+				"ended_at":    ln.EndedAt,
+				"date":        started.Format("2006-01-02"),
+				"year":        int64(started.Year()),
+				"distance_nm": ln.DistanceM / 1852.0,
+			}
+			if ln.VesselID != 0 {
+				props["vessel_id"] = ln.VesselID
+			}
+			// The line breaks where zones clip fixes out, as in
+			// redact.TrackStats, rather than running across the zone.
+			runs := [][][2]float64{ln.Coords}
+			if !zones.Empty() {
+				runs = nil
+				var run [][2]float64
+				for _, c := range ln.Coords {
+					lon, lat, ok := zones.Apply(c[0], c[1])
+					if !ok {
+						if len(run) > 0 {
+							runs, run = append(runs, run), nil
+						}
+						continue
+					}
+					run = append(run, [2]float64{lon, lat})
+				}
+				if len(run) > 0 {
+					runs = append(runs, run)
+				}
+			}
+			var parts [][][2]int32
+			for _, run := range runs {
+				parts = append(parts, t.ClipLine(run, tileBuffer, tiles.DefaultExtent)...)
+			}
+			layer.AddLineString(uint64(ln.TrackID), parts, props)
+		}
+		b = tiles.Encode(layer)
+		if err := cache.Put(t, fp, b); err != nil {
+			log.Printf("tile cache %s: %v", t, err)
+		}
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if len(b) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.mapbox-vector-tile")
+	_, _ = w.Write(b)
+}
@@ -1,22 +1,54 @@
 package server
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"math"
 	"net/http"
+	"path"
 	"strconv"
 	"strings"
+	"sync"
 
 	"wakemap/internal/data"
+	"wakemap/internal/db"
+	"wakemap/internal/export"
+	"wakemap/internal/redact"
+	"wakemap/internal/tiles"
 )
 
 type API struct {
 	Store *data.Store
+	Tiles *tiles.Cache // nil disables the tile cache
+
+	// SourceVessels maps an ingest source name to the vessel its tracks
+	// belong to; "*" catches sources not listed.
+	SourceVessels map[string]int64
+
+	// Zones are kept out of exports and of anything served to someone
+	// other than the owner, who proves it with OwnerToken ("" = everyone).
+	Zones      *redact.Set
+	OwnerToken string
+
+	// Backups is where POST /api/admin/backup writes snapshots ("" = off).
+	Backups data.BackupPolicy
+
+	// Ingest batches appended positions into shared transactions; nil
+	// writes each request in its own.
+	Ingest *data.IngestWriter
+
+	playbacks sync.Map // session id -> *playback
 }
 
 // RFC3339 layout literal (avoids importing time just for the const)
 const timeRFC3339 = "2006-01-02T15:04:05Z07:00"
 
+// timeRFC3339Milli adds milliseconds, but only when there are any, so
+// whole-second times read exactly as timeRFC3339.
+const timeRFC3339Milli = "2006-01-02T15:04:05.999Z07:00"
+
 // at top of handlers_tracks.go (below imports)
 func asFloat(v any) float64 {
 	switch t := v.(type) {
@@ -32,15 +64,92 @@ func asFloat(v any) float64 {
 	return 0
 }
 
+// ListTracks handles GET /api/tracks.
+//
+//	?limit=50&cursor=...            page size (max 200) and next_cursor from the previous page
+//	?from=&to=                      tracks overlapping the range (RFC3339 or epoch seconds)
+//	?min_distance_m=&max_distance_m=
+//	?q=text                         substring of name, notes or route places (those shown to the viewer)
+//	?bbox=minLon,minLat,maxLon,maxLat
+//	?vessel_id=
+//	?sort=started|ended|distance|name&order=desc|asc
 func (api *API) ListTracks(w http.ResponseWriter, r *http.Request) {
-	limit := 50
-	if q := r.URL.Query().Get("limit"); q != "" {
-		if v, err := strconv.Atoi(q); err == nil && v > 0 && v <= 200 {
-			limit = v
+	q := r.URL.Query()
+	tq := data.TrackQuery{
+		Text:   q.Get("q"),
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "limit must be a positive integer", map[string]any{"limit": v})
+			return
+		}
+		tq.Limit = min(n, data.MaxTrackPage)
+	}
+	switch q.Get("order") {
+	case "", "desc":
+	case "asc":
+		tq.Asc = true
+	default:
+		writeErr(w, http.StatusBadRequest, "bad_params", "order must be asc or desc", map[string]any{"order": q.Get("order")})
+		return
+	}
+	for key, dst := range map[string]*int64{"from": &tq.From, "to": &tq.To} {
+		if v := q.Get(key); v != "" {
+			t, err := parseTimeParam(v)
+			if err != nil {
+				writeErr(w, http.StatusBadRequest, "bad_params", key+" must be RFC3339 or epoch seconds", map[string]any{key: v})
+				return
+			}
+			*dst = t
+		}
+	}
+	for key, dst := range map[string]*float64{"min_distance_m": &tq.MinDistanceM, "max_distance_m": &tq.MaxDistanceM} {
+		if v := q.Get(key); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || f < 0 {
+				writeErr(w, http.StatusBadRequest, "bad_params", key+" must be a non-negative number", map[string]any{key: v})
+				return
+			}
+			*dst = f
 		}
 	}
+	if v := q.Get("bbox"); v != "" {
+		bb, err := parseBBox(v)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_params", err.Error(), map[string]any{"bbox": v})
+			return
+		}
+		tq.BBox = &bb
+		if zones := api.viewZones(r); !zones.Empty() {
+			tq.Exclude = zones.Hides
+		}
+	}
+	if zones := api.viewZones(r); tq.Text != "" && !zones.Empty() {
+		tq.ShowsPlace = func(lon, lat float64) bool { return showsExactly(zones, lon, lat) }
+	}
+	var ok bool
+	if tq.VesselID, ok = vesselParam(w, r); !ok {
+		return
+	}
 
-	items, err := api.Store.ListTracks(r.Context(), limit)
+	// Pick up tracks written outside the app before reading distances.
+	if _, err := api.Store.RefreshStaleSummaries(r.Context()); err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to refresh track summaries", map[string]any{"err": err.Error()})
+		return
+	}
+
+	page, err := api.Store.QueryTracks(r.Context(), tq)
+	if errors.Is(err, data.ErrBadCursor) {
+		writeErr(w, http.StatusBadRequest, "bad_cursor", "cursor is invalid or from a different sort", nil)
+		return
+	}
+	if errors.Is(err, data.ErrBadSort) {
+		writeErr(w, http.StatusBadRequest, "bad_params", "sort must be started, ended, distance or name", map[string]any{"sort": tq.Sort})
+		return
+	}
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "db_error", "failed to list tracks", map[string]any{"err": err.Error()})
 		return
@@ -49,19 +158,44 @@ func (api *API) ListTracks(w http.ResponseWriter, r *http.Request) {
 	type outTrack struct {
 		ID        int64   `json:"id"`
 		Name      string  `json:"name"`
+		NameAuto  bool    `json:"name_auto"`
+		VesselID  int64   `json:"vessel_id,omitempty"`
+		FromPlace string  `json:"from_place,omitempty"`
+		ToPlace   string  `json:"to_place,omitempty"`
+		Route     string  `json:"route,omitempty"`
 		StartedAt string  `json:"started_at"`
 		EndedAt   string  `json:"ended_at,omitempty"`
 		DistanceM float64 `json:"distance_m,omitempty"`
 	}
 
 	resp := struct {
-		Tracks []outTrack `json:"tracks"`
-	}{Tracks: make([]outTrack, 0, len(items))}
+		Tracks     []outTrack `json:"tracks"`
+		Total      int        `json:"total"`
+		NextCursor string     `json:"next_cursor,omitempty"`
+	}{Tracks: make([]outTrack, 0, len(page.Tracks)), Total: page.Total, NextCursor: page.NextCursor}
 
-	for _, t := range items {
+	// Someone shown redacted views mustn't learn private places from
+	// route names, nor from the track names generated from them.
+	zones := api.viewZones(r)
+	ids := make([]int64, len(page.Tracks))
+	for i, t := range page.Tracks {
+		ids[i] = t.ID
+	}
+	names, err := api.trackNames(r.Context(), zones, ids...)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track names", map[string]any{"err": err.Error()})
+		return
+	}
+	for _, t := range page.Tracks {
+		route := viewRoute(zones, page.Routes[t.ID])
 		ot := outTrack{
 			ID:        t.ID,
-			Name:      t.Name,
+			Name:      names(t.ID, t.Name),
+			NameAuto:  t.NameAuto == 1,
+			VesselID:  t.VesselID.Int64,
+			FromPlace: route.From,
+			ToPlace:   route.To,
+			Route:     route.Name(),
 			StartedAt: data.UnixToTime(t.StartedAt).Format(timeRFC3339),
 		}
 		if t.EndedAt.Valid {
@@ -76,24 +210,250 @@ func (api *API) ListTracks(w http.ResponseWriter, r *http.Request) {
 	writeJSON(w, http.StatusOK, resp)
 }
 
-func (a *API) TrackGeoJSONByID(w http.ResponseWriter, r *http.Request) {
-	// /api/tracks/:id.geojson
+// parseBBox parses "minLon,minLat,maxLon,maxLat".
+func parseBBox(v string) ([4]float64, error) {
+	var bb [4]float64
+	parts := strings.Split(v, ",")
+	if len(parts) != 4 {
+		return bb, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+	}
+	for i, p := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			return bb, errors.New("bbox must be minLon,minLat,maxLon,maxLat")
+		}
+		bb[i] = f
+	}
+	if bb[0] > bb[2] || bb[1] > bb[3] || bb[0] < -180 || bb[2] > 180 || bb[1] < -90 || bb[3] > 90 {
+		return bb, errors.New("bbox must be ordered min,max and within lon/lat range")
+	}
+	return bb, nil
+}
+
+// TrackRoutes dispatches /api/tracks/:id.<ext> by extension and
+// /api/tracks/:id/<action> by action.
+func (a *API) TrackRoutes(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/tracks/")
+	if idStr, action, ok := strings.Cut(rest, "/"); ok {
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "bad_id", "invalid track id", map[string]any{"id": idStr})
+			return
+		}
+		a.trackAction(w, r, id, action)
+		return
+	}
+
+	switch path.Ext(r.URL.Path) {
+	case "":
+		a.TrackDetail(w, r)
+	case ".geojson":
+		a.TrackGeoJSONByID(w, r)
+	case ".kml":
+		a.TrackKML(w, r)
+	case ".kmz":
+		a.TrackKMZ(w, r)
+	case ".csv":
+		a.TrackCSV(w, r)
+	default:
+		writeErr(w, http.StatusNotFound, "not_found", "unknown track resource", map[string]any{"path": r.URL.Path})
+	}
+}
+
+func (a *API) trackAction(w http.ResponseWriter, r *http.Request, id int64, action string) {
+	switch action {
+	case "split":
+		a.SplitTrack(w, r, id)
+	case "trim":
+		a.TrimTrack(w, r, id)
+	case "stats":
+		a.TrackStats(w, r, id)
+	case "positions":
+		a.AppendPositions(w, r, id)
+	case "segments":
+		a.TrackSegments(w, r, id)
+	case "vessel":
+		a.TrackVessel(w, r, id)
+	default:
+		writeErr(w, http.StatusNotFound, "not_found", "unknown track action", map[string]any{"action": action})
+	}
+}
+
+// trackIDFromPath parses /api/tracks/:id<suffix>, writing a 400 on failure.
+func trackIDFromPath(w http.ResponseWriter, r *http.Request, suffix string) (int64, bool) {
 	p := strings.TrimPrefix(r.URL.Path, "/api/tracks/")
-	p = strings.TrimSuffix(p, ".geojson")
+	p = strings.TrimSuffix(p, suffix)
 
 	id, err := strconv.ParseInt(p, 10, 64)
 	if err != nil {
 		writeErr(w, http.StatusBadRequest, "bad_id", "invalid track id", map[string]any{"id": p})
-		return
+		return 0, false
 	}
+	return id, true
+}
 
-	ctx := r.Context()
-	ts, err := a.Store.ComputeTrackStats(ctx, id)
+// loadTrackStats fetches a track's stats, mapping a missing track to 404.
+func (a *API) loadTrackStats(w http.ResponseWriter, r *http.Request, id int64) (*data.TrackStats, bool) {
+	ts, err := a.Store.ComputeTrackStats(r.Context(), id)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		return nil, false
+	}
 	if err != nil {
 		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return nil, false
+	}
+	return ts, true
+}
+
+// simplifyTolerance reads ?tolerance= (metres) or ?zoom= (web-map zoom,
+// resolved against the track's mid latitude). Zero means full resolution.
+func (a *API) simplifyTolerance(w http.ResponseWriter, r *http.Request, id int64) (float64, bool) {
+	q := r.URL.Query()
+	if v := q.Get("tolerance"); v != "" {
+		tol, err := strconv.ParseFloat(v, 64)
+		if err != nil || tol < 0 || tol > 100000 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "tolerance must be metres between 0 and 100000", map[string]any{"tolerance": v})
+			return 0, false
+		}
+		return tol, true
+	}
+	if v := q.Get("zoom"); v != "" {
+		z, err := strconv.ParseFloat(v, 64)
+		if err != nil || z < 0 || z > 24 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "zoom must be between 0 and 24", map[string]any{"zoom": v})
+			return 0, false
+		}
+		bb, err := a.Store.TrackBBox(r.Context(), id)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+			return 0, false
+		}
+		return data.ToleranceForZoom(z, (asFloat(bb.MinY)+asFloat(bb.MaxY))/2), true
+	}
+	return 0, true
+}
+
+// By default a line breaks across ten minutes or two kilometres without a
+// fix: a logger off or a GPS outage, not a slow sample rate.
+const (
+	defaultGapS = 600
+	defaultGapM = 2000
+)
+
+// gapRule reads ?gap_s= and ?gap_m=, how far apart in time and distance
+// consecutive fixes can be before the line breaks; 0 turns a limit off.
+func gapRule(w http.ResponseWriter, r *http.Request) (data.GapRule, bool) {
+	g := data.GapRule{S: defaultGapS, M: defaultGapM}
+	q := r.URL.Query()
+	if v := q.Get("gap_s"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "gap_s must be seconds, 0 for no limit", map[string]any{"gap_s": v})
+			return g, false
+		}
+		g.S = n
+	}
+	if v := q.Get("gap_m"); v != "" {
+		m, err := strconv.ParseFloat(v, 64)
+		if err != nil || m < 0 {
+			writeErr(w, http.StatusBadRequest, "bad_params", "gap_m must be metres, 0 for no limit", map[string]any{"gap_m": v})
+			return g, false
+		}
+		g.M = m
+	}
+	return g, true
+}
+
+func nanToNil(v float64) *float64 {
+	if math.IsNaN(v) {
+		return nil
+	}
+	return &v
+}
+
+// geojsonFormat reads ?format=compact (the default) or ?format=full.
+func geojsonFormat(w http.ResponseWriter, r *http.Request) (full bool, ok bool) {
+	switch v := r.URL.Query().Get("format"); v {
+	case "", "compact":
+		return false, true
+	case "full":
+		return true, true
+	default:
+		writeErr(w, http.StatusBadRequest, "bad_params", "format must be compact or full", map[string]any{"format": v})
+		return false, false
+	}
+}
+
+// loadSimplifiedTrackStats is loadTrackStats broken at gaps and thinned to
+// tol metres. It also returns the full-resolution point count.
+func (a *API) loadSimplifiedTrackStats(w http.ResponseWriter, r *http.Request, id int64, tol float64, gaps data.GapRule) (*data.TrackStats, int, bool) {
+	if tol <= 0 {
+		ts, ok := a.loadTrackStats(w, r, id)
+		if !ok {
+			return nil, 0, false
+		}
+		ts.MarkGaps(gaps)
+		return ts, len(ts.Coords), true
+	}
+
+	ts, raw, err := a.Store.SimplifiedTrackStats(r.Context(), id, tol, gaps)
+	if errors.Is(err, sql.ErrNoRows) {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		return nil, 0, false
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return nil, 0, false
+	}
+	return ts, raw, true
+}
+
+// TrackGeoJSONByID handles GET /api/tracks/:id.geojson. The line breaks
+// into a MultiLineString across gaps in the record (?gap_s=, ?gap_m=), and
+// the line feature carries coordTimes (epoch seconds, with milliseconds
+// as decimals), parallel to its coordinates. ?format=full adds
+// coordProperties with SOG, COG and source per fix.
+func (a *API) TrackGeoJSONByID(w http.ResponseWriter, r *http.Request) {
+	// /api/tracks/:id.geojson
+	id, ok := trackIDFromPath(w, r, ".geojson")
+	if !ok {
+		return
+	}
+
+	tol, ok := a.simplifyTolerance(w, r, id)
+	if !ok {
+		return
+	}
+	gaps, ok := gapRule(w, r)
+	if !ok {
+		return
+	}
+	full, ok := geojsonFormat(w, r)
+	if !ok {
+		return
+	}
+	ts, raw, ok := a.loadSimplifiedTrackStats(w, r, id, tol, gaps)
+	if !ok {
+		return
+	}
+	zones := a.exportZones(r)
+	if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
 		return
 	}
+	writeTrackGeoJSON(w, id, zones.TrackStats(ts), raw, tol, full)
+}
 
+// writeTrackGeoJSON renders a track as a FeatureCollection: the line, with
+// SOG as a third coordinate where known, and a point per stop. The line is
+// a LineString, or a MultiLineString when ts.Breaks splits it; coordTimes
+// (and with full, coordProperties) are nested the same way as the
+// coordinates. raw is the full-resolution point count and tol the
+// simplification applied.
+func writeTrackGeoJSON(w http.ResponseWriter, id int64, ts *data.TrackStats, raw int, tol float64, full bool) {
 	props := map[string]any{
 		"id":          id,
 		"name":        ts.Name,
@@ -101,43 +461,354 @@ func (a *API) TrackGeoJSONByID(w http.ResponseWriter, r *http.Request) {
 		"ended_at":    ts.EndedAt,
 		"distance_m":  ts.DistanceM,
 		"distance_nm": ts.DistanceM / 1852.0,
-		"duration_s":  max64(0, ts.EndedAt-ts.StartedAt),
+		"duration_s":  ts.DurationS(),
+		"avg_knots":   ts.AvgKnots(),
+		"points":      raw,
+	}
+	addMotionProps(props, &ts.Motion)
+	if tol > 0 {
+		props["points_simplified"] = len(ts.Coords)
+		props["tolerance_m"] = tol
+	}
+
+	// A fix alone between two gaps can't make a line; it's left out.
+	parts := ts.Parts()
+	if len(parts) > 1 {
+		kept := parts[:0]
+		for _, pt := range parts {
+			if pt[1] > pt[0] {
+				kept = append(kept, pt)
+			}
+		}
+		parts = kept
+	}
+	lines := make([][][]float64, 0, len(parts))
+	times := make([][]float64, 0, len(parts))
+	type coordProps struct {
+		SOGms  []*float64 `json:"sog_ms"`
+		COGdeg []*float64 `json:"cog_deg"`
+		Src    []*string  `json:"src"`
 	}
-	if props["duration_s"].(int64) > 0 {
-		props["avg_knots"] = (ts.DistanceM / float64(props["duration_s"].(int64))) * 1.943844492
+	cprops := make([]coordProps, 0, len(parts))
+	for _, pt := range parts {
+		coords := make([][]float64, 0, pt[1]-pt[0]+1)
+		for i := pt[0]; i <= pt[1]; i++ {
+			lon, lat := ts.Coords[i][0], ts.Coords[i][1]
+			sog := ts.SOGms[i]
+			if math.IsNaN(sog) {
+				// emit 2D when SOG unknown to keep JSON small and avoid nulls
+				coords = append(coords, []float64{lon, lat})
+			} else {
+				coords = append(coords, []float64{lon, lat, sog}) // sog in m/s
+			}
+		}
+		lines = append(lines, coords)
+		ct := make([]float64, 0, pt[1]-pt[0]+1)
+		for _, ms := range ts.TimesMs[pt[0] : pt[1]+1] {
+			ct = append(ct, float64(ms)/1000)
+		}
+		times = append(times, ct)
+		if !full {
+			continue
+		}
+		var cp coordProps
+		for i := pt[0]; i <= pt[1]; i++ {
+			cp.SOGms = append(cp.SOGms, nanToNil(ts.SOGms[i]))
+			cp.COGdeg = append(cp.COGdeg, nanToNil(ts.COGrad[i]*180/math.Pi))
+			var src *string
+			if ts.Src[i] != "" {
+				src = &ts.Src[i]
+			}
+			cp.Src = append(cp.Src, src)
+		}
+		cprops = append(cprops, cp)
+	}
+
+	lineProps := make(map[string]any, len(props)+3)
+	for k, v := range props {
+		lineProps[k] = v
+	}
+	lineProps["gaps"] = max(len(parts)-1, 0)
+	geometry := map[string]any{"type": "MultiLineString", "coordinates": lines}
+	if len(lines) == 1 {
+		geometry = map[string]any{"type": "LineString", "coordinates": lines[0]}
+		lineProps["coordTimes"] = times[0]
+		if full {
+			lineProps["coordProperties"] = cprops[0]
+		}
 	} else {
-		props["avg_knots"] = 0.0
+		lineProps["coordTimes"] = times
+		if full {
+			lineProps["coordProperties"] = cprops
+		}
 	}
 
-	coords := make([][]float64, 0, len(ts.Coords))
-	for i, xy := range ts.Coords {
-		lon, lat := xy[0], xy[1]
-		sog := ts.SOGms[i]
-		if math.IsNaN(sog) {
-			// emit 2D when SOG unknown to keep JSON small and avoid nulls
-			coords = append(coords, []float64{lon, lat})
-		} else {
-			coords = append(coords, []float64{lon, lat, sog}) // sog in m/s
+	features := []map[string]any{{
+		"type":       "Feature",
+		"properties": lineProps,
+		"geometry":   geometry,
+	}}
+	// One point per stop, at its centroid.
+	for _, sg := range ts.Segments {
+		if !sg.Stationary() {
+			continue
 		}
+		features = append(features, map[string]any{
+			"type": "Feature",
+			"properties": map[string]any{
+				"kind":       sg.Kind,
+				"started_at": sg.StartedAt,
+				"ended_at":   sg.EndedAt,
+				"duration_s": sg.EndedAt - sg.StartedAt,
+				"radius_m":   sg.RadiusM,
+				"points":     sg.Points,
+			},
+			"geometry": map[string]any{
+				"type":        "Point",
+				"coordinates": []float64{sg.Centroid[0], sg.Centroid[1]},
+			},
+		})
 	}
 
+	// Track props are repeated at the top level for clients that read them
+	// off the collection.
 	gj := map[string]any{
-		"type":       "Feature",
-		"properties": props, // your existing props incl. distance_nm, duration_s, avg_knots
-		"geometry": map[string]any{
-			"type":        "LineString",
-			"coordinates": coords,
-		},
-		"bbox": []float64{ts.MinX, ts.MinY, ts.MaxX, ts.MaxY},
+		"type":       "FeatureCollection",
+		"properties": props,
+		"features":   features,
+		"bbox":       []float64{ts.MinX, ts.MinY, ts.MaxX, ts.MaxY},
 	}
 
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	_ = json.NewEncoder(w).Encode(gj)
 }
 
-func max64(a, b int64) int64 {
-	if a > b {
-		return a
+func (a *API) TrackKML(w http.ResponseWriter, r *http.Request) {
+	// /api/tracks/:id.kml
+	id, ok := trackIDFromPath(w, r, ".kml")
+	if !ok {
+		return
+	}
+	ts, ok := a.loadTrackStats(w, r, id)
+	if !ok {
+		return
+	}
+	zones := a.exportZones(r)
+	if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
+		return
+	}
+	ts = zones.TrackStats(ts)
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kml+xml")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%d.kml"`, id))
+	_ = export.WriteKML(w, id, ts)
+}
+
+func (a *API) TrackKMZ(w http.ResponseWriter, r *http.Request) {
+	// /api/tracks/:id.kmz
+	id, ok := trackIDFromPath(w, r, ".kmz")
+	if !ok {
+		return
+	}
+	ts, ok := a.loadTrackStats(w, r, id)
+	if !ok {
+		return
+	}
+	zones := a.exportZones(r)
+	if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
+		return
+	}
+	ts = zones.TrackStats(ts)
+
+	w.Header().Set("Content-Type", "application/vnd.google-earth.kmz")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%d.kmz"`, id))
+	_ = export.WriteKMZ(w, id, ts)
+}
+
+func (a *API) TrackCSV(w http.ResponseWriter, r *http.Request) {
+	// /api/tracks/:id.csv
+	id, ok := trackIDFromPath(w, r, ".csv")
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	exists, err := a.Store.TrackExists(ctx, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return
+	}
+	if !exists {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="track-%d.csv"`, id))
+
+	// Rows stream straight from the cursor; once the header is out we can
+	// no longer change the status, so errors just truncate the body.
+	cw, err := export.NewCSVWriter(w)
+	if err != nil {
+		return
+	}
+	zones := a.exportZones(r)
+	_ = a.Store.EachTrackPosition(ctx, id, func(p *db.Position) error {
+		if !zones.Position(p) {
+			return nil
+		}
+		return cw.Write(p)
+	})
+	_ = cw.Flush()
+}
+
+// addMotionProps flattens MotionStats into GeoJSON properties.
+func addMotionProps(props map[string]any, m *data.MotionStats) {
+	props["moving_s"] = m.MovingS
+	props["stopped_s"] = m.StoppedS
+	props["moving_nm"] = m.MovingM / 1852.0
+	props["avg_moving_knots"] = m.AvgMovingKnots
+	props["max_knots"] = m.MaxKnots
+	props["p50_knots"] = m.P50Knots
+	props["p95_knots"] = m.P95Knots
+	props["stops"] = m.Stops
+	props["stops_s"] = m.StopsS
+	if m.LongestLeg != nil {
+		props["longest_leg"] = m.LongestLeg
+	}
+	props["speed_histogram"] = m.SpeedHistogram
+}
+
+// TrackStats handles GET /api/tracks/:id/stats.
+func (a *API) TrackStats(w http.ResponseWriter, r *http.Request, id int64) {
+	ts, ok := a.loadTrackStats(w, r, id)
+	if !ok {
+		return
+	}
+	zones := a.viewZones(r)
+	if ts, ok = a.viewTrackName(w, r, zones, id, ts); !ok {
+		return
+	}
+	ts = zones.TrackStats(ts)
+	writeJSON(w, http.StatusOK, map[string]any{
+		"id":          id,
+		"name":        ts.Name,
+		"started_at":  data.UnixToTime(ts.StartedAt).Format(timeRFC3339),
+		"ended_at":    data.UnixToTime(ts.EndedAt).Format(timeRFC3339),
+		"points":      len(ts.Coords),
+		"distance_m":  ts.DistanceM,
+		"distance_nm": ts.DistanceM / 1852.0,
+		"duration_s":  ts.DurationS(),
+		"avg_knots":   ts.AvgKnots(),
+		"bbox":        []float64{ts.MinX, ts.MinY, ts.MaxX, ts.MaxY},
+		"motion":      ts.Motion,
+	})
+}
+
+// TrackSegments handles GET /api/tracks/:id/segments: underway legs and
+// anchored, moored or drifting periods in time order.
+func (a *API) TrackSegments(w http.ResponseWriter, r *http.Request, id int64) {
+	ts, ok := a.loadTrackStats(w, r, id)
+	if !ok {
+		return
+	}
+	ts = a.viewZones(r).TrackStats(ts)
+	type outSegment struct {
+		Kind      string     `json:"kind"`
+		StartedAt string     `json:"started_at"`
+		EndedAt   string     `json:"ended_at"`
+		DurationS int64      `json:"duration_s"`
+		DistanceM float64    `json:"distance_m"`
+		Centroid  [2]float64 `json:"centroid"`
+		RadiusM   float64    `json:"radius_m"`
+		Points    int        `json:"points"`
+	}
+	out := make([]outSegment, 0, len(ts.Segments))
+	for _, sg := range ts.Segments {
+		out = append(out, outSegment{
+			Kind:      sg.Kind,
+			StartedAt: data.UnixToTime(sg.StartedAt).Format(timeRFC3339),
+			EndedAt:   data.UnixToTime(sg.EndedAt).Format(timeRFC3339),
+			DurationS: sg.EndedAt - sg.StartedAt,
+			DistanceM: sg.DistanceM,
+			Centroid:  sg.Centroid,
+			RadiusM:   sg.RadiusM,
+			Points:    sg.Points,
+		})
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "segments": out})
+}
+
+// TrackDetail handles GET /api/tracks/:id from precomputed aggregates;
+// it never scans positions unless the summary is stale, or the bbox has to
+// be redacted.
+func (a *API) TrackDetail(w http.ResponseWriter, r *http.Request) {
+	id, ok := trackIDFromPath(w, r, "")
+	if !ok {
+		return
+	}
+	ctx := r.Context()
+	sum, err := a.Store.TrackSummary(ctx, id)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "track not found", map[string]any{"id": id})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return
+	}
+	// Resolving the route may rename an auto-named track, so do it first.
+	route, err := a.Store.TrackRoute(ctx, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to resolve track route", map[string]any{"err": err.Error()})
+		return
+	}
+	t, err := a.Store.Track(ctx, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track", map[string]any{"err": err.Error()})
+		return
+	}
+	zones := a.viewZones(r)
+	names, err := a.trackNames(ctx, zones, id)
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to load track name", map[string]any{"err": err.Error()})
+		return
+	}
+	route = viewRoute(zones, route)
+
+	out := map[string]any{
+		"id":          id,
+		"name":        names(id, t.Name),
+		"points":      sum.Points,
+		"distance_m":  sum.DistanceM,
+		"distance_nm": sum.DistanceM / 1852.0,
+	}
+	if t.Notes.Valid {
+		out["notes"] = t.Notes.String
+	}
+	if t.VesselID.Valid {
+		out["vessel_id"] = t.VesselID.Int64
+	}
+	if route.From != "" {
+		out["from_place"] = route.From
+	}
+	if route.To != "" {
+		out["to_place"] = route.To
+	}
+	if sum.Points > 0 {
+		out["started_at"] = data.UnixToTime(sum.StartedAt.Int64).Format(timeRFC3339)
+		out["ended_at"] = data.UnixToTime(sum.EndedAt.Int64).Format(timeRFC3339)
+		out["duration_s"] = sum.EndedAt.Int64 - sum.StartedAt.Int64
+		out["started_at_ms"] = sum.StartedAtMs.Int64
+		out["ended_at_ms"] = sum.EndedAtMs.Int64
+		out["bbox"] = []float64{sum.MinX.Float64, sum.MinY.Float64, sum.MaxX.Float64, sum.MaxY.Float64}
+		if !zones.Empty() {
+			ts, ok := a.loadTrackStats(w, r, id)
+			if !ok {
+				return
+			}
+			ts = zones.TrackStats(ts)
+			out["bbox"] = []float64{ts.MinX, ts.MinY, ts.MaxX, ts.MaxY}
+		}
 	}
-	return b
+	writeJSON(w, http.StatusOK, out)
 }
@@ -0,0 +1,270 @@
+package server
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"wakemap/internal/data"
+	"wakemap/internal/db"
+)
+
+var mmsiRE = regexp.MustCompile(`^[0-9]{9}$`)
+
+// Vessels handles the boats this server logs.
+//
+//	GET    /api/vessels
+//	POST   /api/vessels        {"name": "...", "mmsi": "...", "loa_m": 11.3, "polar": {...}, ...}
+//	GET    /api/vessels/:id
+//	PUT    /api/vessels/:id    same body; replaces every field
+//	DELETE /api/vessels/:id    its tracks are kept, unassigned
+func (a *API) Vessels(w http.ResponseWriter, r *http.Request) {
+	if !a.requireOwnerToWrite(w, r) {
+		return
+	}
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/vessels"), "/")
+	ctx := r.Context()
+	if rest == "" {
+		switch r.Method {
+		case http.MethodGet:
+			vs, counts, err := a.Store.Vessels(ctx)
+			if err != nil {
+				writeErr(w, http.StatusInternalServerError, "db_error", "failed to list vessels", map[string]any{"err": err.Error()})
+				return
+			}
+			out := make([]map[string]any, 0, len(vs))
+			for _, v := range vs {
+				out = append(out, vesselJSON(v, counts[v.ID]))
+			}
+			writeJSON(w, http.StatusOK, map[string]any{"vessels": out})
+		case http.MethodPost:
+			a.writeVessel(w, r, 0)
+		default:
+			writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET or POST", nil)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(rest, 10, 64)
+	if err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_id", "invalid vessel id", map[string]any{"id": rest})
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		v, err := a.Store.Vessel(ctx, id)
+		if errors.Is(err, data.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "vessel not found", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to load vessel", map[string]any{"err": err.Error()})
+			return
+		}
+		_, counts, err := a.Store.Vessels(ctx)
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to count tracks", map[string]any{"err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, vesselJSON(v, counts[id]))
+	case http.MethodPut:
+		a.writeVessel(w, r, id)
+	case http.MethodDelete:
+		err := a.Store.DeleteVessel(ctx, id)
+		if errors.Is(err, data.ErrNotFound) {
+			writeErr(w, http.StatusNotFound, "not_found", "vessel not found", map[string]any{"id": id})
+			return
+		}
+		if err != nil {
+			writeErr(w, http.StatusInternalServerError, "db_error", "failed to delete vessel", map[string]any{"err": err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]any{"id": id, "deleted": true})
+	default:
+		writeErr(w, http.StatusMethodNotAllowed, "method_not_allowed", "use GET, PUT or DELETE", nil)
+	}
+}
+
+// vesselIn is the JSON body for creating or replacing a vessel. Polar and
+// engine are stored as given, e.g. {"tws": [...], "twa": [...], "stw": [[...]]}
+// and {"make": "Yanmar", "model": "3YM30", "kw": 21}.
+type vesselIn struct {
+	Name      string          `json:"name"`
+	MMSI      string          `json:"mmsi"`
+	Callsign  string          `json:"callsign"`
+	LoaM      *float64        `json:"loa_m"`
+	BeamM     *float64        `json:"beam_m"`
+	DraftM    *float64        `json:"draft_m"`
+	AirDraftM *float64        `json:"air_draft_m"`
+	Polar     json.RawMessage `json:"polar"`
+	Engine    json.RawMessage `json:"engine"`
+}
+
+func (a *API) writeVessel(w http.ResponseWriter, r *http.Request, id int64) {
+	var in vesselIn
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", "body must be a JSON vessel", map[string]any{"err": err.Error()})
+		return
+	}
+	v := db.Vessel{ID: id, Name: strings.TrimSpace(in.Name)}
+	if v.Name == "" {
+		writeErr(w, http.StatusBadRequest, "bad_params", "name is required", nil)
+		return
+	}
+	if m := strings.TrimSpace(in.MMSI); m != "" {
+		if !mmsiRE.MatchString(m) {
+			writeErr(w, http.StatusBadRequest, "bad_params", "mmsi must be 9 digits", map[string]any{"mmsi": in.MMSI})
+			return
+		}
+		v.Mmsi = sql.NullString{String: m, Valid: true}
+	}
+	if c := strings.ToUpper(strings.TrimSpace(in.Callsign)); c != "" {
+		v.Callsign = sql.NullString{String: c, Valid: true}
+	}
+	for key, f := range map[string]struct {
+		in  *float64
+		dst *sql.NullFloat64
+	}{
+		"loa_m": {in.LoaM, &v.LoaM}, "beam_m": {in.BeamM, &v.BeamM},
+		"draft_m": {in.DraftM, &v.DraftM}, "air_draft_m": {in.AirDraftM, &v.AirDraftM},
+	} {
+		if f.in == nil {
+			continue
+		}
+		if *f.in <= 0 || *f.in > 500 {
+			writeErr(w, http.StatusBadRequest, "bad_params", key+" must be 0 < metres <= 500", map[string]any{key: *f.in})
+			return
+		}
+		*f.dst = sql.NullFloat64{Float64: *f.in, Valid: true}
+	}
+	for key, f := range map[string]struct {
+		in  json.RawMessage
+		dst *sql.NullString
+	}{"polar": {in.Polar, &v.Polar}, "engine": {in.Engine, &v.Engine}} {
+		raw := strings.TrimSpace(string(f.in))
+		if raw == "" || raw == "null" {
+			continue
+		}
+		if raw[0] != '{' && raw[0] != '[' {
+			writeErr(w, http.StatusBadRequest, "bad_params", key+" must be a JSON object or array", nil)
+			return
+		}
+		*f.dst = sql.NullString{String: raw, Valid: true}
+	}
+
+	v, err := a.Store.SaveVessel(r.Context(), v)
+	switch {
+	case errors.Is(err, data.ErrNotFound):
+		writeErr(w, http.StatusNotFound, "not_found", "vessel not found", map[string]any{"id": id})
+		return
+	case errors.Is(err, data.ErrDuplicateMMSI):
+		writeErr(w, http.StatusConflict, "conflict", err.Error(), map[string]any{"mmsi": v.Mmsi.String})
+		return
+	case err != nil:
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to save vessel", map[string]any{"err": err.Error()})
+		return
+	}
+	status := http.StatusOK
+	if id == 0 {
+		status = http.StatusCreated
+	}
+	writeJSON(w, status, vesselJSON(v, -1))
+}
+
+// vesselJSON renders a vessel; tracks < 0 leaves the track count out.
+func vesselJSON(v db.Vessel, tracks int) map[string]any {
+	m := map[string]any{
+		"id":         v.ID,
+		"name":       v.Name,
+		"created_at": data.UnixToTime(v.CreatedAt).Format(timeRFC3339),
+		"updated_at": data.UnixToTime(v.UpdatedAt).Format(timeRFC3339),
+	}
+	if tracks >= 0 {
+		m["tracks"] = tracks
+	}
+	if v.Mmsi.Valid {
+		m["mmsi"] = v.Mmsi.String
+	}
+	if v.Callsign.Valid {
+		m["callsign"] = v.Callsign.String
+	}
+	for key, f := range map[string]sql.NullFloat64{"loa_m": v.LoaM, "beam_m": v.BeamM, "draft_m": v.DraftM, "air_draft_m": v.AirDraftM} {
+		if f.Valid {
+			m[key] = f.Float64
+		}
+	}
+	for key, s := range map[string]sql.NullString{"polar": v.Polar, "engine": v.Engine} {
+		if s.Valid {
+			m[key] = json.RawMessage(s.String)
+		}
+	}
+	return m
+}
+
+// TrackVessel handles POST /api/tracks/:id/vessel {"vessel_id": 2}; null
+// unassigns the track.
+func (a *API) TrackVessel(w http.ResponseWriter, r *http.Request, id int64) {
+	if !requirePOST(w, r) {
+		return
+	}
+	var in struct {
+		VesselID *int64 `json:"vessel_id"`
+	}
+	if err := json.NewDecoder(io.LimitReader(r.Body, 1<<10)).Decode(&in); err != nil {
+		writeErr(w, http.StatusBadRequest, "bad_json", `body must be {"vessel_id": n}`, map[string]any{"err": err.Error()})
+		return
+	}
+	var vid int64
+	if in.VesselID != nil {
+		vid = *in.VesselID
+	}
+	err := a.Store.SetTrackVessel(r.Context(), id, vid)
+	if errors.Is(err, data.ErrNotFound) {
+		writeErr(w, http.StatusNotFound, "not_found", "track or vessel not found", map[string]any{"id": id, "vessel_id": in.VesselID})
+		return
+	}
+	if err != nil {
+		writeErr(w, http.StatusInternalServerError, "db_error", "failed to update track", map[string]any{"err": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"id": id, "vessel_id": in.VesselID})
+}
+
+// vesselParam reads the optional ?vessel_id= filter; 0 means any vessel.
+func vesselParam(w http.ResponseWriter, r *http.Request) (int64, bool) {
+	v := r.URL.Query().Get("vessel_id")
+	if v == "" {
+		return 0, true
+	}
+	id, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || id <= 0 {
+		writeErr(w, http.StatusBadRequest, "bad_params", "vessel_id must be a vessel id", map[string]any{"vessel_id": v})
+		return 0, false
+	}
+	return id, true
+}
+
+// ingestVessel picks the vessel for a new track: an explicit vessel_id,
+// else the one configured for the ingest source (WAKEMAP_SOURCE_VESSELS,
+// with "*" as the catch-all), else the only vessel if there is just one.
+func (a *API) ingestVessel(ctx context.Context, explicit int64, source string) (int64, error) {
+	id := explicit
+	if id == 0 {
+		if id = a.SourceVessels[source]; id == 0 {
+			id = a.SourceVessels["*"]
+		}
+	}
+	if id == 0 {
+		return a.Store.DefaultVesselID(ctx)
+	}
+	if _, err := a.Store.Vessel(ctx, id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
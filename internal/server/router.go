@@ -10,11 +10,39 @@ import (
 func NewMux(api *API) *http.ServeMux {
 	mux := http.NewServeMux()
 
-	// API
-	mux.HandleFunc("/api/tracks", api.ListTracks)        // GET
-	mux.HandleFunc("/api/tracks/", api.TrackGeoJSONByID) // GET /api/tracks/:id.geojson
+	// API. Anything but GET on tracks, edits, vessels and the logbook
+	// needs the owner token when one is set.
+	mux.HandleFunc("/api/tracks", api.ListTracks)               // GET
+	mux.HandleFunc("/api/tracks/", api.TrackRoutes)             // GET /api/tracks/:id.{geojson,kml,kmz,csv}, /api/tracks/:id/{stats,segments}, POST /api/tracks/:id/{split,trim,positions,vessel}
+	mux.HandleFunc("/api/tracks/import", api.ImportTrackCSV)    // POST CSV
+	mux.HandleFunc("/api/tracks/merge", api.MergeTracks)        // POST ?ids=
+	mux.HandleFunc("/api/track-edits", api.TrackEdits)          // GET
+	mux.HandleFunc("/api/track-edits/", api.TrackEdits)         // POST /api/track-edits/:id/undo
+	mux.HandleFunc("/api/places", api.Places)                   // GET anchorages/berths across all tracks
+	mux.HandleFunc("/api/stats", api.CruiseStats)               // GET ?group=month|year&from=&to=&year=
+	mux.HandleFunc("/api/search/tracks", api.SearchTracks)      // GET ?bbox= or ?near=lon,lat&radius=
+	mux.HandleFunc("/api/position", api.PositionAt)             // GET ?at=
+	mux.HandleFunc("/api/logbook", api.Logbook)                 // GET ?track_id=|from=&to=&format=, POST manual entry
+	mux.HandleFunc("/api/logbook/", api.Logbook)                // PUT, DELETE /api/logbook/:id
+	mux.HandleFunc("/api/geocode/reverse", api.ReverseGeocode)  // GET ?near=lon,lat
+	mux.HandleFunc("/api/vessels", api.Vessels)                 // GET, POST
+	mux.HandleFunc("/api/vessels/", api.Vessels)                // GET, PUT, DELETE /api/vessels/:id
+	mux.HandleFunc("/api/redaction-zones", api.RedactionZones)  // GET, POST (owner only)
+	mux.HandleFunc("/api/redaction-zones/", api.RedactionZones) // GET, PUT, DELETE /api/redaction-zones/:id
+	mux.HandleFunc("/api/owner", api.OwnerLogin)                // POST {"token"} sets the owner cookie, DELETE clears it
+	mux.HandleFunc("/api/shares", api.Shares)                   // GET, POST (owner only)
+	mux.HandleFunc("/api/shares/", api.Shares)                  // GET, DELETE /api/shares/:id
+	mux.HandleFunc("/share/", api.SharedView)                   // GET /share/:token[/tracks/:id.{geojson,kml}], public
+	mux.HandleFunc("/api/playback", api.Playback)               // GET ?track_ids=&speed=&from=&align=, an SSE stream
+	mux.HandleFunc("/api/playback/", api.Playback)              // POST /api/playback/:session pause, play, seek, speed
+	mux.HandleFunc("/api/admin/backup", api.Backup)             // GET snapshots, POST take one now (owner only)
+	mux.HandleFunc("/api/admin/ingest", api.IngestStats)        // GET queue depth, drops and commit latency (owner only)
 	mux.HandleFunc("/api/seamarks", api.SeamarkLookup)
 
+	// Vector tiles of the whole archive
+	mux.HandleFunc("/tiles/tracks/", api.TrackTile) // GET /tiles/tracks/{z}/{x}/{y}.mvt
+	mux.HandleFunc("/tiles/heat/", api.HeatTile)    // GET /tiles/heat/{z}/{x}/{y}.{png,mvt}?from=&to=
+
 	// Seamark proxy (adds CORS + caching)
 	mux.Handle("/seamark/", WithCORS(http.StripPrefix("/seamark", seamark.Handler())))
 
@@ -0,0 +1,75 @@
+package tiles
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Cache keeps rendered tiles on disk as <dir>/<z>/<x>/<y>.<fingerprint>.mvt.
+// The fingerprint comes from the data the tile was built from, so a tile
+// whose tracks changed simply misses and is re-rendered; the stale file is
+// removed when the new one is written.
+type Cache struct {
+	Dir string
+}
+
+func NewCache(dir string) *Cache { return &Cache{Dir: dir} }
+
+// Sub is a separate cache in a subdirectory, for a filtered variant of the
+// same tiles.
+func (c *Cache) Sub(name string) *Cache {
+	if c == nil {
+		return nil
+	}
+	return &Cache{Dir: filepath.Join(c.Dir, name)}
+}
+
+func (c *Cache) path(t TileID, fp string) string {
+	return filepath.Join(c.Dir, fmt.Sprint(t.Z), fmt.Sprint(t.X), fmt.Sprintf("%d.%s.mvt", t.Y, fp))
+}
+
+// Get returns a cached tile. An empty tile is a hit with zero bytes.
+func (c *Cache) Get(t TileID, fp string) ([]byte, bool) {
+	if c == nil {
+		return nil, false
+	}
+	b, err := os.ReadFile(c.path(t, fp))
+	return b, err == nil
+}
+
+// Put stores a tile and drops older renderings of it.
+func (c *Cache) Put(t TileID, fp string, b []byte) error {
+	if c == nil {
+		return nil
+	}
+	p := c.path(t, fp)
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	old, _ := filepath.Glob(filepath.Join(filepath.Dir(p), fmt.Sprintf("%d.*.mvt", t.Y)))
+
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tile-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	for _, o := range old {
+		if o != p {
+			_ = os.Remove(o)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package tiles
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+)
+
+// TileSize is the raster tile size in pixels.
+const TileSize = 256
+
+// Cell is a value in one cell of a level-L Web Mercator grid (2^L cells
+// square).
+type Cell struct {
+	X, Y  int64
+	Value int64
+}
+
+// HeatLevel is the grid level drawn on tile zoom z: cells of 4 px,
+// bottoming out at maxLevel.
+func HeatLevel(z, maxLevel int) int { return min(z+6, maxLevel) }
+
+// CellRange is the inclusive range of level-L cells covering the tile.
+func (t TileID) CellRange(level int) (x0, y0, x1, y1 int64) {
+	if level >= t.Z {
+		s := uint(level - t.Z)
+		return int64(t.X) << s, int64(t.Y) << s, int64(t.X+1)<<s - 1, int64(t.Y+1)<<s - 1
+	}
+	s := uint(t.Z - level)
+	return int64(t.X) >> s, int64(t.Y) >> s, int64(t.X) >> s, int64(t.Y) >> s
+}
+
+// cellScale is the size of a level-L cell in units where the tile spans
+// size.
+func (t TileID) cellScale(level int, size float64) float64 {
+	return size * math.Ldexp(1, t.Z-level)
+}
+
+// Dwell seconds mapped to full colour; the ramp is logarithmic from one
+// minute up to this.
+const heatFullS = 7 * 24 * 3600
+
+// RenderHeatPNG draws dwell-time cells onto a transparent 256px tile.
+func (t TileID) RenderHeatPNG(level int, cells []Cell) ([]byte, error) {
+	img := image.NewNRGBA(image.Rect(0, 0, TileSize, TileSize))
+	scale := t.cellScale(level, TileSize)
+	ox, oy := float64(t.X)*TileSize, float64(t.Y)*TileSize
+	for _, c := range cells {
+		x0 := int(math.Floor(float64(c.X)*scale - ox))
+		y0 := int(math.Floor(float64(c.Y)*scale - oy))
+		x1 := int(math.Ceil(float64(c.X+1)*scale - ox))
+		y1 := int(math.Ceil(float64(c.Y+1)*scale - oy))
+		col := heatColor(c.Value)
+		for y := max(y0, 0); y < min(y1, TileSize); y++ {
+			for x := max(x0, 0); x < min(x1, TileSize); x++ {
+				img.SetNRGBA(x, y, col)
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// HeatLayer turns cells into a point layer (one point per cell centre)
+// with dwell_s for MapLibre heatmap weights.
+func (t TileID) HeatLayer(name string, level int, cells []Cell) *Layer {
+	l := NewLayer(name, DefaultExtent)
+	scale := t.cellScale(level, DefaultExtent)
+	ox, oy := float64(t.X)*DefaultExtent, float64(t.Y)*DefaultExtent
+	for i, c := range cells {
+		x := int32(math.Round((float64(c.X)+0.5)*scale - ox))
+		y := int32(math.Round((float64(c.Y)+0.5)*scale - oy))
+		l.AddPoint(uint64(i+1), x, y, map[string]any{"dwell_s": c.Value})
+	}
+	return l
+}
+
+var heatRamp = []color.NRGBA{
+	{0, 80, 255, 90},
+	{0, 220, 255, 150},
+	{255, 230, 0, 200},
+	{230, 30, 0, 235},
+}
+
+func heatColor(dwellS int64) color.NRGBA {
+	v := math.Log1p(float64(dwellS)/60) / math.Log1p(heatFullS/60)
+	v = max(0, min(v, 1)) * float64(len(heatRamp)-1)
+	i := min(int(v), len(heatRamp)-2)
+	f := v - float64(i)
+	a, b := heatRamp[i], heatRamp[i+1]
+	lerp := func(p, q uint8) uint8 { return uint8(float64(p) + f*(float64(q)-float64(p))) }
+	return color.NRGBA{lerp(a.R, b.R), lerp(a.G, b.G), lerp(a.B, b.B), lerp(a.A, b.A)}
+}
@@ -0,0 +1,198 @@
+package tiles
+
+import (
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// Just enough of the Mapbox Vector Tile 2.1 protobuf schema to write
+// point and linestring layers, encoded by hand to avoid a protobuf dependency.
+
+const (
+	DefaultExtent = 4096
+
+	geomPoint      = 1
+	geomLineString = 2
+
+	cmdMoveTo = 1
+	cmdLineTo = 2
+)
+
+// Layer is one named layer of a vector tile.
+type Layer struct {
+	Name   string
+	Extent uint32
+
+	keys     []string
+	keyIdx   map[string]uint32
+	values   []any
+	valueIdx map[any]uint32
+	features [][]byte
+}
+
+func NewLayer(name string, extent uint32) *Layer {
+	return &Layer{
+		Name:     name,
+		Extent:   extent,
+		keyIdx:   map[string]uint32{},
+		valueIdx: map[any]uint32{},
+	}
+}
+
+// Len is the number of features added so far.
+func (l *Layer) Len() int { return len(l.features) }
+
+// AddPoint adds a point feature in tile coordinates. Properties are as for
+// AddLineString.
+func (l *Layer) AddPoint(id uint64, x, y int32, props map[string]any) {
+	l.addFeature(id, geomPoint, []uint32{command(cmdMoveTo, 1), zigzag(x), zigzag(y)}, props)
+}
+
+// AddLineString adds a (multi)linestring feature in tile coordinates.
+// Property values may be string, int64, float64 or bool; others are
+// skipped. Parts with fewer than two points are dropped.
+func (l *Layer) AddLineString(id uint64, parts [][][2]int32, props map[string]any) {
+	var geom []uint32
+	var cx, cy int32
+	for _, p := range parts {
+		if len(p) < 2 {
+			continue
+		}
+		geom = append(geom, command(cmdMoveTo, 1), zigzag(p[0][0]-cx), zigzag(p[0][1]-cy))
+		cx, cy = p[0][0], p[0][1]
+		geom = append(geom, command(cmdLineTo, len(p)-1))
+		for _, pt := range p[1:] {
+			geom = append(geom, zigzag(pt[0]-cx), zigzag(pt[1]-cy))
+			cx, cy = pt[0], pt[1]
+		}
+	}
+	if len(geom) == 0 {
+		return
+	}
+	l.addFeature(id, geomLineString, geom, props)
+}
+
+func (l *Layer) addFeature(id uint64, typ uint64, geom []uint32, props map[string]any) {
+	keys := make([]string, 0, len(props))
+	for k := range props {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var tags []uint32
+	for _, k := range keys {
+		v := props[k]
+		switch x := v.(type) {
+		case int:
+			v = int64(x)
+		case string, int64, float64, bool:
+		default:
+			continue
+		}
+		tags = append(tags, l.key(k), l.value(v))
+	}
+
+	var f []byte
+	f = appendVarintField(f, 1, id)
+	f = appendPacked(f, 2, tags)
+	f = appendVarintField(f, 3, typ)
+	f = appendPacked(f, 4, geom)
+	l.features = append(l.features, f)
+}
+
+func (l *Layer) key(k string) uint32 {
+	i, ok := l.keyIdx[k]
+	if !ok {
+		i = uint32(len(l.keys))
+		l.keys = append(l.keys, k)
+		l.keyIdx[k] = i
+	}
+	return i
+}
+
+func (l *Layer) value(v any) uint32 {
+	i, ok := l.valueIdx[v]
+	if !ok {
+		i = uint32(len(l.values))
+		l.values = append(l.values, v)
+		l.valueIdx[v] = i
+	}
+	return i
+}
+
+func (l *Layer) encode() []byte {
+	var b []byte
+	b = appendVarintField(b, 15, 2) // version
+	b = appendBytesField(b, 1, []byte(l.Name))
+	for _, f := range l.features {
+		b = appendBytesField(b, 2, f)
+	}
+	for _, k := range l.keys {
+		b = appendBytesField(b, 3, []byte(k))
+	}
+	for _, v := range l.values {
+		b = appendBytesField(b, 4, encodeValue(v))
+	}
+	b = appendVarintField(b, 5, uint64(l.Extent))
+	return b
+}
+
+// Encode serialises layers into a tile. Empty layers are left out, so a
+// tile with nothing in it encodes to zero bytes.
+func Encode(layers ...*Layer) []byte {
+	var b []byte
+	for _, l := range layers {
+		if l.Len() == 0 {
+			continue
+		}
+		b = appendBytesField(b, 3, l.encode())
+	}
+	return b
+}
+
+func encodeValue(v any) []byte {
+	var b []byte
+	switch x := v.(type) {
+	case string:
+		b = appendBytesField(b, 1, []byte(x))
+	case float64:
+		b = appendTag(b, 3, 1)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(x))
+	case int64:
+		b = appendVarintField(b, 6, uint64((x<<1)^(x>>63))) // sint_value
+	case bool:
+		n := uint64(0)
+		if x {
+			n = 1
+		}
+		b = appendVarintField(b, 7, n)
+	}
+	return b
+}
+
+func command(id, count int) uint32 { return uint32(id&7) | uint32(count)<<3 }
+
+func zigzag(n int32) uint32 { return uint32((n << 1) ^ (n >> 31)) }
+
+func appendTag(b []byte, field, wire int) []byte {
+	return binary.AppendUvarint(b, uint64(field<<3|wire))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendTag(b, field, 0)
+	return binary.AppendUvarint(b, v)
+}
+
+func appendBytesField(b []byte, field int, v []byte) []byte {
+	b = appendTag(b, field, 2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendPacked(b []byte, field int, vs []uint32) []byte {
+	var p []byte
+	for _, v := range vs {
+		p = binary.AppendUvarint(p, uint64(v))
+	}
+	return appendBytesField(b, field, p)
+}
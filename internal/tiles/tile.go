@@ -0,0 +1,144 @@
+package tiles
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// MaxZoom is the deepest tile served.
+const MaxZoom = 20
+
+// TileID is a Web Mercator (XYZ) tile.
+type TileID struct {
+	Z, X, Y int
+}
+
+func (t TileID) String() string { return fmt.Sprintf("%d/%d/%d", t.Z, t.X, t.Y) }
+
+// ParseTileID parses "z/x/y<ext>".
+func ParseTileID(s, ext string) (TileID, error) {
+	var t TileID
+	parts := strings.Split(strings.TrimSuffix(s, ext), "/")
+	if len(parts) != 3 || !strings.HasSuffix(s, ext) {
+		return t, fmt.Errorf("tile path must be z/x/y%s", ext)
+	}
+	var err error
+	for i, dst := range []*int{&t.Z, &t.X, &t.Y} {
+		if *dst, err = strconv.Atoi(parts[i]); err != nil {
+			return t, fmt.Errorf("tile path must be z/x/y%s", ext)
+		}
+	}
+	if t.Z < 0 || t.Z > MaxZoom {
+		return t, fmt.Errorf("tile %s out of range", t)
+	}
+	n := 1 << t.Z
+	if t.X < 0 || t.X >= n || t.Y < 0 || t.Y >= n {
+		return t, fmt.Errorf("tile %s out of range", t)
+	}
+	return t, nil
+}
+
+// Bounds is the tile's [minLon, minLat, maxLon, maxLat], grown by buffer
+// tile units on each side (out of extent).
+func (t TileID) Bounds(buffer, extent float64) [4]float64 {
+	n := float64(int(1) << t.Z)
+	pad := buffer / extent
+	minLon := (float64(t.X)-pad)/n*360 - 180
+	maxLon := (float64(t.X)+1+pad)/n*360 - 180
+	maxLat := mercToLat(math.Pi * (1 - 2*(float64(t.Y)-pad)/n))
+	minLat := mercToLat(math.Pi * (1 - 2*(float64(t.Y)+1+pad)/n))
+	return [4]float64{max(minLon, -180), max(minLat, -85.0511), min(maxLon, 180), min(maxLat, 85.0511)}
+}
+
+func mercToLat(y float64) float64 { return math.Atan(math.Sinh(y)) * 180 / math.Pi }
+
+// Project maps lon/lat to this tile's coordinate space, where the tile
+// itself spans [0, extent).
+func (t TileID) Project(lon, lat, extent float64) (x, y float64) {
+	n := float64(int(1) << t.Z)
+	lat = max(min(lat, 85.0511), -85.0511)
+	s := math.Sin(lat * math.Pi / 180)
+	x = ((lon+180)/360*n - float64(t.X)) * extent
+	y = ((0.5-math.Log((1+s)/(1-s))/(4*math.Pi))*n - float64(t.Y)) * extent
+	return x, y
+}
+
+// ClipLine projects a lon/lat polyline into the tile and clips it to the
+// tile plus buffer, returning the pieces that remain with consecutive
+// duplicate vertices removed.
+func (t TileID) ClipLine(coords [][2]float64, buffer, extent float64) [][][2]int32 {
+	lo, hi := -buffer, extent+buffer
+
+	var parts [][][2]int32
+	var cur [][2]int32
+	push := func(x, y float64) {
+		p := [2]int32{int32(math.Round(x)), int32(math.Round(y))}
+		if n := len(cur); n > 0 && cur[n-1] == p {
+			return
+		}
+		cur = append(cur, p)
+	}
+	flush := func() {
+		if len(cur) >= 2 {
+			parts = append(parts, cur)
+		}
+		cur = nil
+	}
+
+	var px, py float64
+	for i, c := range coords {
+		x, y := t.Project(c[0], c[1], extent)
+		if i == 0 {
+			px, py = x, y
+			continue
+		}
+		ax, ay, bx, by, ok := clipSegment(px, py, x, y, lo, hi)
+		if !ok {
+			flush()
+		} else {
+			if ax != px || ay != py {
+				flush() // entered from outside
+			}
+			if len(cur) == 0 {
+				push(ax, ay)
+			}
+			push(bx, by)
+			if bx != x || by != y {
+				flush() // left the tile
+			}
+		}
+		px, py = x, y
+	}
+	flush()
+	return parts
+}
+
+// clipSegment is Liang-Barsky against the square [lo, hi]².
+func clipSegment(x0, y0, x1, y1, lo, hi float64) (ax, ay, bx, by float64, ok bool) {
+	t0, t1 := 0.0, 1.0
+	dx, dy := x1-x0, y1-y0
+	for _, e := range [4][2]float64{{-dx, x0 - lo}, {dx, hi - x0}, {-dy, y0 - lo}, {dy, hi - y0}} {
+		p, q := e[0], e[1]
+		if p == 0 {
+			if q < 0 {
+				return 0, 0, 0, 0, false
+			}
+			continue
+		}
+		r := q / p
+		if p < 0 {
+			if r > t1 {
+				return 0, 0, 0, 0, false
+			}
+			t0 = max(t0, r)
+		} else {
+			if r < t0 {
+				return 0, 0, 0, 0, false
+			}
+			t1 = min(t1, r)
+		}
+	}
+	return x0 + t0*dx, y0 + t0*dy, x0 + t1*dx, y0 + t1*dy, true
+}
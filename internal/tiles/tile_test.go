@@ -0,0 +1,39 @@
+package tiles
+
+import "testing"
+
+func TestParseTileID(t *testing.T) {
+	for _, tc := range []struct {
+		path string
+		want TileID
+		ok   bool
+	}{
+		{"0/0/0.mvt", TileID{0, 0, 0}, true},
+		{"3/7/5.mvt", TileID{3, 7, 5}, true},
+		{"20/1048575/1048575.mvt", TileID{20, 1048575, 1048575}, true},
+
+		{"-1/0/0.mvt", TileID{}, false},
+		{"-64/0/0.mvt", TileID{}, false},
+		{"21/0/0.mvt", TileID{}, false},
+		{"99/0/0.mvt", TileID{}, false},
+		{"3/-1/0.mvt", TileID{}, false},
+		{"3/0/-1.mvt", TileID{}, false},
+		{"3/8/0.mvt", TileID{}, false},
+		{"3/0/8.mvt", TileID{}, false},
+		{"0/1/0.mvt", TileID{}, false},
+
+		{"3/7/5.png", TileID{}, false},
+		{"3/7.mvt", TileID{}, false},
+		{"3/7/5/1.mvt", TileID{}, false},
+		{"a/7/5.mvt", TileID{}, false},
+	} {
+		got, err := ParseTileID(tc.path, ".mvt")
+		if tc.ok != (err == nil) {
+			t.Errorf("ParseTileID(%q): err = %v, want ok = %v", tc.path, err, tc.ok)
+			continue
+		}
+		if tc.ok && got != tc.want {
+			t.Errorf("ParseTileID(%q) = %v, want %v", tc.path, got, tc.want)
+		}
+	}
+}
@@ -348,6 +348,37 @@ function ensureTrackLayer() {
   }
 }
 
+// Whole archive as vector tiles; click a line to open that track.
+function ensureArchiveLayer() {
+  if (!map.getSource('archive')) {
+    map.addSource('archive', {
+      type: 'vector',
+      tiles: [`${location.origin}/tiles/tracks/{z}/{x}/{y}.mvt`],
+      maxzoom: 20,
+    });
+  }
+  if (!map.getLayer('archive-line')) {
+    map.addLayer({
+      id: 'archive-line',
+      type: 'line',
+      source: 'archive',
+      'source-layer': 'tracks',
+      layout: { 'line-cap': 'round', 'line-join': 'round' },
+      paint: { 'line-width': 2, 'line-color': '#6084eb', 'line-opacity': 0.45 },
+    }, map.getLayer('track-line') ? 'track-line' : undefined);
+
+    map.on('mouseenter', 'archive-line', () => map.getCanvas().style.cursor = 'pointer');
+    map.on('mouseleave', 'archive-line', () => map.getCanvas().style.cursor = '');
+    map.on('click', 'archive-line', (e) => {
+      const id = e.features?.[0]?.properties?.id;
+      if (id === undefined) return;
+      const sel = document.getElementById('trackSelect') as HTMLSelectElement | null;
+      if (sel) sel.value = String(id);
+      showTrack(String(id));
+    });
+  }
+}
+
 type BBox = [number, number, number, number];
 
 function ensureTrackPointsLayer(map: maplibregl.Map) {
@@ -381,14 +412,19 @@ function ensureTrackPointsLayer(map: maplibregl.Map) {
 
 const MS_TO_KN = 1.943844492;
 
+// The track line's parts: one for a LineString, several when the server
+// broke a MultiLineString across gaps in the record.
+function trackLines(gj: any): any[][] {
+  const f = gj?.type === 'Feature' ? gj
+    : gj?.features?.find((x: any) => x?.geometry?.type === 'LineString' || x?.geometry?.type === 'MultiLineString');
+  const g = f?.geometry;
+  if (g?.type === 'LineString') return [g.coordinates ?? []];
+  if (g?.type === 'MultiLineString') return g.coordinates ?? [];
+  return [];
+}
+
 function coordsToPointsFC(gj: any): GeoJSON.FeatureCollection {
-  let coords: any[] = [];
-  if (gj?.type === 'Feature' && gj?.geometry?.type === 'LineString') {
-    coords = gj.geometry.coordinates;
-  } else if (gj?.type === 'FeatureCollection') {
-    const f = gj.features?.find((x: any) => x?.geometry?.type === 'LineString');
-    coords = f?.geometry?.coordinates ?? [];
-  }
+  const coords: any[] = trackLines(gj).flat();
 
   const features = coords.map((c: any, i: number) => {
     const lon = c[0], lat = c[1];
@@ -439,23 +475,11 @@ function formatDuration(sec: number): string {
 type TrackStats = { distanceNm: number; durationSec: number; avgKnots: number; name?: string };
 
 function computeTrackStats(gj: any): TrackStats {
-  // find first LineString coords
-  let coords: [number, number][] | undefined;
-  let name: string | undefined;
-
-  if (gj?.type === 'FeatureCollection' && Array.isArray(gj.features)) {
-    const f = gj.features.find((x: any) => x?.geometry?.type === 'LineString');
-    if (f) {
-      coords = f.geometry.coordinates as [number, number][];
-      name = f.properties?.name ?? gj.properties?.name;
-    }
-  } else if (gj?.type === 'Feature' && gj.geometry?.type === 'LineString') {
-    coords = gj.geometry.coordinates as [number, number][];
-    name = gj.properties?.name;
-  }
+  const name: string | undefined = gj?.properties?.name ?? gj?.features?.[0]?.properties?.name;
 
+  // Distance along each part; nothing is sailed across a gap.
   let meters = 0;
-  if (Array.isArray(coords) && coords.length > 1) {
+  for (const coords of trackLines(gj) as [number, number][][]) {
     for (let i = 1; i < coords.length; i++) {
       meters += haversineM(coords[i - 1], coords[i]);
     }
@@ -494,14 +518,8 @@ function updateStatsUI(stats: TrackStats) {
 }
 
 function updateTrackMarkersFromGeoJSON(gj: any) {
-  // Accept Feature(LineString) or FC containing one LineString
-  let coords: any[] = [];
-  if (gj?.type === 'Feature' && gj?.geometry?.type === 'LineString') {
-    coords = gj.geometry.coordinates;
-  } else if (gj?.type === 'FeatureCollection') {
-    const f = gj.features?.find((x: any) => x?.geometry?.type === 'LineString');
-    coords = f?.geometry?.coordinates ?? [];
-  }
+  // Accept Feature or FC with a LineString or MultiLineString
+  const coords: any[] = trackLines(gj).flat();
 
   if (!Array.isArray(coords) || coords.length < 2) {
     removeTrackMarkers();
@@ -535,13 +553,7 @@ function updateTrackMarkersFromGeoJSON(gj: any) {
 }
 
 function getLineCoordsFromGeoJSON(gj: any): TrackCoord[] {
-  let coords: any[] = [];
-  if (gj?.type === 'Feature' && gj?.geometry?.type === 'LineString') {
-    coords = gj.geometry.coordinates;
-  } else if (gj?.type === 'FeatureCollection') {
-    const f = gj.features?.find((x: any) => x?.geometry?.type === 'LineString');
-    coords = f?.geometry?.coordinates ?? [];
-  }
+  const coords: any[] = trackLines(gj).flat();
   return coords.map((c: any) => ({
     lon: c[0],
     lat: c[1],
@@ -646,7 +658,9 @@ async function populateTrackSelect() {
     opt.value = t.id;
     const started = t.started_at ? new Date(t.started_at).toLocaleString() : '';
     const distNm = t.distance_m ? (t.distance_m / 1852).toFixed(1) + ' nm' : '';
-    opt.textContent = `${t.name || t.id} — ${started} ${distNm}`;
+    // Hand-named tracks also show where they went.
+    const route = t.route && t.route !== t.name ? ` (${t.route})` : '';
+    opt.textContent = `${t.name || t.id}${route} — ${started} ${distNm}`;
     sel.appendChild(opt);
   }
   sel.onchange = () => {
@@ -676,6 +690,7 @@ async function populateTrackSelect() {
   // Add seamarks raster overlay through our Go proxy (CORS + cache)
   map.on('load', async () => {
     await populateTrackSelect();
+    ensureArchiveLayer();
     if (await checkSeamarkAvailable()) {
       map.addSource('seamarks', {
         type: 'raster',
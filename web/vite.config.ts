@@ -13,6 +13,11 @@ export default defineConfig({
         target: 'http://localhost:8080',
         changeOrigin: true,
       },
+      // archive vector tiles
+      '/tiles': {
+        target: 'http://localhost:8080',
+        changeOrigin: true,
+      },
     },
   },
 })